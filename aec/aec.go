@@ -34,8 +34,11 @@ int luxnj_algo_destory(void* adsp_srv);
 // ------------------------------------------------------------------
 // 2. 编写一个 C 辅助函数 (Wrapper)
 //    负责：类型转换 (short->float) + 数据重排 + 调用算法
+//    返回 0 成功，-1 未初始化；DOA 写到 doa_out（算法没给出方向时为 -1）
 // ------------------------------------------------------------------
-int wrap_aec_process(short* input_raw, short* output_clean) {
+int wrap_aec_process(short* input_raw, short* output_clean, int* doa_out) {
+    *doa_out = -1;
+
     // 检查全局指针是否已初始化
     if (!adsp_srv || !adsp_srv->ptr_mic_buf) {
         return -1;
@@ -43,7 +46,7 @@ int wrap_aec_process(short* input_raw, short* output_clean) {
 
     int frame_size = adsp_srv->frame_size;
     float* internal_buf = adsp_srv->ptr_mic_buf;
-    int doa = 0;
+    int doa = -1;
 
     // --- 步骤 A: 数据输入 (Interleaved int16 -> Planar float) ---
     // 严格照搬 c_algodemo.c 的逻辑
@@ -73,11 +76,14 @@ int wrap_aec_process(short* input_raw, short* output_clean) {
         output_clean[i] = (short)(internal_buf[0 * frame_size + i]);
     }
 
-    return doa;
+    *doa_out = doa;
+    return 0;
 }
 */
 import "C"
 import (
+	"errors"
+	"fmt"
 	"unsafe"
 )
 
@@ -91,6 +97,12 @@ const (
 	InputSize    = FrameSize * InputTotalCh
 )
 
+// NoDOA 没有方向信息
+const NoDOA = -1
+
+// ErrNotInitialized 算法库未初始化（luxnj_algo_init 失败或未调用）
+var ErrNotInitialized = errors.New("aec: 算法未初始化")
+
 type Processor struct {
 	// 不需要存 handle 了，C 代码里用的是全局变量
 }
@@ -104,10 +116,10 @@ func NewProcessor() *Processor {
 
 // Process 处理函数
 // input: 256帧 * 10通道 (int16)
-// return: 256帧 单声道 (int16), DOA角度
-func (p *Processor) Process(input []int16) ([]int16, int) {
+// return: 256帧 单声道 (int16), DOA角度（0~359，NoDOA 表示没有方向信息）, 错误（输入长度不对、算法未初始化）
+func (p *Processor) Process(input []int16) ([]int16, int, error) {
 	if len(input) != InputSize {
-		return nil, 0
+		return nil, NoDOA, fmt.Errorf("aec: 输入 %d 个样本，应为 %d", len(input), InputSize)
 	}
 
 	// 准备输出缓冲区
@@ -118,12 +130,10 @@ func (p *Processor) Process(input []int16) ([]int16, int) {
 	outPtr := (*C.short)(unsafe.Pointer(&output[0]))
 
 	// 调用我们写的 C wrapper
-	doa := C.wrap_aec_process(inPtr, outPtr)
-
-	if doa == -1 {
-		// 错误处理：如果未初始化
-		return make([]int16, FrameSize), 0
+	var doa C.int
+	if C.wrap_aec_process(inPtr, outPtr, &doa) != 0 {
+		return nil, NoDOA, ErrNotInitialized
 	}
 
-	return output, int(doa)
+	return output, int(doa), nil
 }
@@ -3,6 +3,8 @@
 
 package aec
 
+import "fmt"
+
 // 说明：
 // - 本文件用于在非 Linux 或未启用 CGO 的环境下编译通过（例如 macOS 本地开发）。
 // - 真实板端（rk3308b）使用 `aec.go`（linux+cgo）加载 libluxaudio 做 AEC/降噪。
@@ -15,18 +17,21 @@ const (
 	InputSize    = FrameSize * InputTotalCh
 )
 
+// NoDOA 没有方向信息
+const NoDOA = -1
+
 type Processor struct{}
 
 func NewProcessor() *Processor { return &Processor{} }
 
-// Process 直通回退：默认取第 0 通道作为“干净单声道”输出
-func (p *Processor) Process(input []int16) ([]int16, int) {
+// Process 直通回退：默认取第 0 通道作为“干净单声道”输出，没有 DOA（返回 NoDOA）
+func (p *Processor) Process(input []int16) ([]int16, int, error) {
 	if len(input) != InputSize {
-		return nil, 0
+		return nil, NoDOA, fmt.Errorf("aec: 输入 %d 个样本，应为 %d", len(input), InputSize)
 	}
 	out := make([]int16, FrameSize)
 	for i := 0; i < FrameSize; i++ {
 		out[i] = input[i*InputTotalCh+0]
 	}
-	return out, 0
+	return out, NoDOA, nil
 }
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"ai_box/localasr"
+)
+
+// ================= ASR 后端 =================
+// 说明：
+// - processASR 只依赖 ASRProvider，不关心是云端还是板端识别；
+// - dashscope：云端 paraformer（默认）；local：板端 sherpa-onnx 流式 zipformer；
+// - 云端建连失败（断网/DNS/鉴权握手失败）时自动回退到本地引擎，保证“停止/下一首/几点了”等基础指令可用；
+// - 识别是流式的：VAD 触发时即开会话，语音帧边说边推，说完只需等最后一包结果。
+
+// ASRResultFunc 识别过程中的结果回调：text 为截至目前的完整文本，sentenceEnd 表示最近一句已由引擎判定结束。
+type ASRResultFunc func(text string, sentenceEnd bool)
+
+// ASRProvider 语音识别后端。
+type ASRProvider interface {
+	Name() string
+	// Start 开启一次流式识别；onResult 可为 nil。
+	Start(onResult ASRResultFunc) (ASRSession, error)
+}
+
+// ASRSession 一次流式识别会话。pcm 为 16bit 小端单声道。
+// Finish 返回 ("", nil) 表示确实没识别出文字；err != nil 表示识别链路本身失败。
+type ASRSession interface {
+	Write(pcm []byte) error
+	Finish() (string, error)
+	Close()
+}
+
+// errASRDial 云端建连失败，可回退到本地引擎
+var errASRDial = errors.New("asr: 建连失败")
+
+var asrEngine ASRProvider
+
+// ---------- DashScope paraformer ----------
+
+type dashScopeASR struct{}
+
+func (dashScopeASR) Name() string { return "dashscope" }
+
+func (dashScopeASR) Start(onResult ASRResultFunc) (ASRSession, error) {
+	return startDashScopeASR(onResult)
+}
+
+type dashScopeASRSession struct {
+	conn     *websocket.Conn
+	taskID   string
+	onResult ASRResultFunc
+
+	mu        sync.Mutex
+	sentences []string // 已结束的句子
+	partial   string   // 当前句的中间结果
+	err       error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func startDashScopeASR(onResult ASRResultFunc) (*dashScopeASRSession, error) {
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, HandshakeTimeout: 5 * time.Second}
+	headers := http.Header{}
+	headers.Add("Authorization", "Bearer "+dashAPIKey)
+	conn, _, err := dialer.Dial(asrWsURL, headers)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errASRDial, err)
+	}
+	s := &dashScopeASRSession{
+		conn:     conn,
+		taskID:   fmt.Sprintf("%032x", rand.Int63()),
+		onResult: onResult,
+		done:     make(chan struct{}),
+	}
+	if err := conn.WriteJSON(map[string]interface{}{
+		"header":  map[string]interface{}{"task_id": s.taskID, "action": "run-task", "streaming": "duplex"},
+		"payload": map[string]interface{}{"task_group": "audio", "task": "asr", "function": "recognition", "model": asrModel, "parameters": map[string]interface{}{"format": "pcm", "sample_rate": asrSampleRate}, "input": map[string]interface{}{}},
+	}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %v", errASRDial, err)
+	}
+	go s.readLoop()
+	return s, nil
+}
+
+func (s *dashScopeASRSession) text() string {
+	return strings.TrimSpace(strings.Join(s.sentences, "") + s.partial)
+}
+
+func (s *dashScopeASRSession) readLoop() {
+	defer close(s.done)
+	for {
+		_, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			s.mu.Lock()
+			s.err = fmt.Errorf("asr: 连接中断: %w", err)
+			s.mu.Unlock()
+			return
+		}
+		var r map[string]interface{}
+		if json.Unmarshal(msg, &r) != nil {
+			continue
+		}
+		h, _ := r["header"].(map[string]interface{})
+		switch h["event"] {
+		case "result-generated":
+			p, _ := r["payload"].(map[string]interface{})
+			o, _ := p["output"].(map[string]interface{})
+			sentence, ok := o["sentence"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			t, _ := sentence["text"].(string)
+			// paraformer-realtime：sentence_end=true（或 end_time 非空）表示该句已定稿
+			end, _ := sentence["sentence_end"].(bool)
+			if et, ok := sentence["end_time"]; ok && et != nil {
+				end = true
+			}
+			s.mu.Lock()
+			if end {
+				s.sentences = append(s.sentences, t)
+				s.partial = ""
+			} else {
+				s.partial = t
+			}
+			text := s.text()
+			s.mu.Unlock()
+			if s.onResult != nil {
+				s.onResult(text, end)
+			}
+		case "task-finished":
+			return
+		case "task-failed":
+			s.mu.Lock()
+			s.err = fmt.Errorf("asr: task-failed: %v", h["error_message"])
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (s *dashScopeASRSession) Write(pcm []byte) error {
+	select {
+	case <-s.done:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.err != nil {
+			return s.err
+		}
+		return errors.New("asr: 会话已结束")
+	default:
+	}
+	return s.conn.WriteMessage(websocket.BinaryMessage, pcm)
+}
+
+func (s *dashScopeASRSession) Finish() (string, error) {
+	s.conn.WriteJSON(map[string]interface{}{"header": map[string]interface{}{"task_id": s.taskID, "action": "finish-task"}, "payload": map[string]interface{}{"input": map[string]interface{}{}}})
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		s.Close()
+		<-s.done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.text(), s.err
+}
+
+func (s *dashScopeASRSession) Close() {
+	s.closeOnce.Do(func() { s.conn.Close() })
+}
+
+// ---------- 本地 sherpa-onnx ----------
+
+type localASR struct {
+	rec *localasr.Recognizer
+}
+
+func (a *localASR) Name() string { return "local" }
+
+func (a *localASR) Start(onResult ASRResultFunc) (ASRSession, error) {
+	st := a.rec.NewStream()
+	if st == nil {
+		return nil, errors.New("asr: 本地识别不可用")
+	}
+	return &localASRSession{stream: st, onResult: onResult}, nil
+}
+
+type localASRSession struct {
+	stream   *localasr.Stream
+	onResult ASRResultFunc
+	last     string
+}
+
+func (s *localASRSession) Write(pcm []byte) error {
+	text := s.stream.Accept(pcmBytesToInt16(pcm))
+	if text != s.last {
+		s.last = text
+		if s.onResult != nil {
+			s.onResult(text, false)
+		}
+	}
+	return nil
+}
+
+func (s *localASRSession) Finish() (string, error) { return s.stream.Finish(), nil }
+
+func (s *localASRSession) Close() { s.stream.Close() }
+
+// ---------- 回退组合 ----------
+
+// fallbackASR 主引擎建连失败时改用备用引擎
+type fallbackASR struct {
+	primary  ASRProvider
+	fallback ASRProvider
+}
+
+func (f *fallbackASR) Name() string { return f.primary.Name() + "+" + f.fallback.Name() }
+
+func (f *fallbackASR) Start(onResult ASRResultFunc) (ASRSession, error) {
+	sess, err := f.primary.Start(onResult)
+	if err != nil && errors.Is(err, errASRDial) {
+		log.Printf("⚠️ [ASR] %s 不可用，回退到 %s: %v", f.primary.Name(), f.fallback.Name(), err)
+		return f.fallback.Start(onResult)
+	}
+	return sess, err
+}
+
+func initASRProvider() ASRProvider {
+	var local ASRProvider
+	if asrProvider == "local" || asrFallback == "local" {
+		rec, err := localasr.NewRecognizer(localasr.Config{
+			ModelDir:   asrLocalModelDir,
+			NumThreads: asrLocalNumThreads,
+			SampleRate: asrSampleRate,
+		})
+		if err != nil {
+			log.Printf("⚠️ [ASR] 本地识别初始化失败: %v", err)
+		} else {
+			local = &localASR{rec: rec}
+		}
+	}
+
+	switch asrProvider {
+	case "local":
+		if local != nil {
+			return local
+		}
+		log.Println("⚠️ [ASR] 本地识别不可用，改用云端 dashscope")
+		return dashScopeASR{}
+	case "dashscope", "":
+	default:
+		log.Printf("⚠️ [ASR] 未知 AI_BOX_ASR_PROVIDER=%q，使用 dashscope", asrProvider)
+	}
+
+	if local != nil {
+		return &fallbackASR{primary: dashScopeASR{}, fallback: local}
+	}
+	return dashScopeASR{}
+}
+
+// callASRWebSocket 一次性识别整段音频（调试/回放用），内部走与实时链路相同的流式会话。
+func callASRWebSocket(data []byte) (string, error) {
+	sess, err := asrEngine.Start(nil)
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+	for i := 0; i < len(data); i += 3200 {
+		end := i + 3200
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := sess.Write(data[i:end]); err != nil {
+			break
+		}
+	}
+	return sess.Finish()
+}
+
+// ================= 流式识别轮次 =================
+// asrTurn 把 audioLoop 的实时帧转交给识别会话：
+// - 建连在后台进行，期间的帧先进 channel 排队，不阻塞录音循环；
+// - 引擎判定句末（sentenceEnd）后 Endpointed() 变为 true，本地 VAD 也听到一小段静音时 audioLoop 才提前收尾（见 segmentShouldEnd）。
+type asrTurn struct {
+	frames    chan []byte
+	cancelled chan struct{}
+	localWake chan bool
+	doa       int // 语音段方向，Finish 时写入
+	endpoint  chan struct{}
+	epOnce    sync.Once
+	closeOnce sync.Once
+	done      func()
+}
+
+// onASRPartial 中间结果钩子（日志/事件记录用）
+var onASRPartial = func(text string, sentenceEnd bool) {
+	if sentenceEnd {
+		log.Printf("ASR句末: [%s]", text)
+	} else {
+		log.Printf("ASR中间结果: [%s]", text)
+	}
+}
+
+func startASRTurn(preroll []int16) *asrTurn {
+	t := &asrTurn{
+		frames:    make(chan []byte, 512),
+		cancelled: make(chan struct{}),
+		localWake: make(chan bool, 1),
+		endpoint:  make(chan struct{}),
+		doa:       doaUnknown,
+		done:      trackPipeline(),
+	}
+	t.Push(preroll)
+	go t.run()
+	return t
+}
+
+func (t *asrTurn) run() {
+	defer t.done()
+	sess, err := asrEngine.Start(func(text string, sentenceEnd bool) {
+		if onASRPartial != nil {
+			onASRPartial(text, sentenceEnd)
+		}
+		if sentenceEnd {
+			t.epOnce.Do(func() { close(t.endpoint) })
+		}
+	})
+	if err != nil {
+		log.Printf("❌ [ASR] %s 建连失败: %v", asrEngine.Name(), err)
+	}
+	writeFailed := false
+	for f := range t.frames {
+		if sess == nil || writeFailed {
+			continue
+		}
+		if werr := sess.Write(f); werr != nil {
+			log.Printf("❌ [ASR] 推流失败: %v", werr)
+			writeFailed = true
+		}
+	}
+
+	select {
+	case <-t.cancelled:
+		if sess != nil {
+			sess.Close()
+		}
+		return
+	default:
+	}
+	localWake := <-t.localWake
+
+	text := ""
+	if sess != nil {
+		text, err = sess.Finish()
+		sess.Close()
+		if err != nil {
+			log.Printf("❌ [ASR] %s 识别失败: %v", asrEngine.Name(), err)
+		}
+	}
+	// 唤醒态下识别失败（建连或识别出错）提示一声，休眠态不出声
+	if (sess == nil || err != nil) && (localWake || assistant.Awake()) {
+		playEarcon(earconError)
+	}
+	processASR(text, localWake, t.doa)
+}
+
+// Push 推送一帧（非阻塞；队列满说明建连严重滞后，直接丢帧）。
+func (t *asrTurn) Push(frame []int16) {
+	if len(frame) == 0 {
+		return
+	}
+	select {
+	case t.frames <- pcmInt16ToBytes(frame):
+	default:
+		log.Println("⚠️ [ASR] 推流队列已满，丢弃一帧")
+	}
+}
+
+// Endpointed 引擎是否已判定句末
+func (t *asrTurn) Endpointed() bool {
+	select {
+	case <-t.endpoint:
+		return true
+	default:
+		return false
+	}
+}
+
+// Finish 语音段结束：等待最终结果并交给 processASR；doa 为整段的方向
+func (t *asrTurn) Finish(localWake bool, doa int) {
+	t.closeOnce.Do(func() {
+		t.doa = doa
+		t.localWake <- localWake
+		close(t.frames)
+	})
+}
+
+// Cancel 语音段作废（过短/休眠态），不处理识别结果
+func (t *asrTurn) Cancel() {
+	t.closeOnce.Do(func() {
+		close(t.cancelled)
+		close(t.frames)
+	})
+}
@@ -0,0 +1,764 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ================= 助手状态机 =================
+// 说明：
+// - 唤醒、会话、静音、播报/放歌占用等状态统一收在 Assistant 里，只能通过 Dispatch(事件) 迁移；
+// - 迁移本身在锁内完成（只改状态、记事件），对外的动作（播报、放歌、切断声音、调 LLM）
+//   通过 assistantEffects 在锁外执行，动作产生的新事件（如音乐停止）再回到 Dispatch；
+// - 单元测试用假的 assistantEffects 即可覆盖 processASR / performStop 的每个分支，不需要声卡。
+
+// AssistantState 助手对外呈现的状态
+type AssistantState int
+
+const (
+	StateSleeping  AssistantState = iota // 休眠：只响应唤醒词
+	StateListening                       // 唤醒待命：等待指令
+	StateThinking                        // 指令已交给 LLM，等待回复
+	StateSpeaking                        // 正在播报
+	StatePlaying                         // 正在放歌
+	StateDucked                          // 放歌中，音乐被压低（用户说话/助手播报）
+)
+
+func (s AssistantState) String() string {
+	switch s {
+	case StateSleeping:
+		return "sleeping"
+	case StateListening:
+		return "listening"
+	case StateThinking:
+		return "thinking"
+	case StateSpeaking:
+		return "speaking"
+	case StatePlaying:
+		return "playing"
+	case StateDucked:
+		return "ducked"
+	}
+	return "unknown"
+}
+
+// musicState 音乐层状态（与对话层并存：放歌时仍可唤醒、对话）
+type musicState int
+
+const (
+	musicOff musicState = iota
+	musicPlaying
+	musicDucked
+	musicPaused // 被打断词暂停，等“继续播放”；不算忙碌
+)
+
+// ---------- 迁移事件 ----------
+
+// AssistantEvent 状态迁移事件
+type AssistantEvent interface{ eventName() string }
+
+type (
+	// EvASRResult 一轮最终识别结果；LocalWake 表示本段语音本地 KWS 已命中唤醒词，DOA 为语音段方向（doaUnknown 表示没有）
+	EvASRResult struct {
+		Text      string
+		LocalWake bool
+		DOA       int
+	}
+	// EvKeywordSpotted 本地 KWS 命中唤醒词
+	EvKeywordSpotted struct{ Keyword string }
+	// EvSleep 主动进入休眠
+	EvSleep struct{}
+	// EvIdleCheck 定时检查是否长时间无交互
+	EvIdleCheck struct{ Now time.Time }
+	// EvDuck 用户开口/助手插话，压低音乐
+	EvDuck struct{}
+	// EvUnduck 恢复音乐音量
+	EvUnduck struct{}
+	// EvSpeakingStarted 播报输出已打开
+	EvSpeakingStarted struct{}
+	// EvSpeakingDone 播报输出已关闭
+	EvSpeakingDone struct{}
+	// EvMusicStarted 开始放歌
+	EvMusicStarted struct{ Path string }
+	// EvMusicStopped 音乐停止（打断或自然播完）
+	EvMusicStopped struct{}
+	// EvMusicPaused 打断词暂停了音乐（PauseMusic 成功之后才发）
+	EvMusicPaused struct{}
+	// EvReplyDone 一轮 LLM 回复结束；Session 为发起这一轮时的会话，旧会话的结束不影响新一轮
+	EvReplyDone struct{ Session string }
+	// EvStop 切断一切声音（performStop）
+	EvStop struct{}
+)
+
+func (EvASRResult) eventName() string       { return "asr" }
+func (EvKeywordSpotted) eventName() string  { return "kws" }
+func (EvSleep) eventName() string           { return "sleep" }
+func (EvIdleCheck) eventName() string       { return "idle_check" }
+func (EvDuck) eventName() string            { return "duck" }
+func (EvUnduck) eventName() string          { return "unduck" }
+func (EvSpeakingStarted) eventName() string { return "speaking_started" }
+func (EvSpeakingDone) eventName() string    { return "speaking_done" }
+func (EvMusicStarted) eventName() string    { return "music_started" }
+func (EvMusicStopped) eventName() string    { return "music_stopped" }
+func (EvMusicPaused) eventName() string     { return "music_paused" }
+func (EvReplyDone) eventName() string       { return "reply_done" }
+func (EvStop) eventName() string            { return "stop" }
+
+// Transition 一次 Dispatch 的结果
+type Transition struct {
+	From, To AssistantState
+	Intent   asrIntent // EvASRResult 的判定结果（与 intent 事件的 detail 一致），其余事件为空
+}
+
+// ---------- 对外动作 ----------
+
+// assistantEffects 状态机触发的动作；线上实现见 deviceEffects，测试里替换为记录调用的假实现
+type assistantEffects interface {
+	SpeakWakeAck()
+	Duck()
+	Unduck()
+	// Silence 切断一切声音：中止合成、清空队列、停音乐、关播报输出
+	Silence()
+	SongExists(query string) bool
+	CurrentSong() string
+	// PauseMusic 暂停音乐，保留播放位置；歌恰好放完等没有可暂停的内容时返回 false
+	PauseMusic() bool
+	// ResumeMusic 从暂停处继续；没有可继续的歌时返回 false
+	ResumeMusic() bool
+	// PlayRandom 播报确认后随机放一首（排除 exclude）
+	PlayRandom(exclude string)
+	// HasPrevious 上一首是否有歌可放；musicOn 同 PlayPrevious
+	HasPrevious(musicOn bool) bool
+	// PlayPrevious 上一首；musicOn 表示说话时有歌在放（含暂停），否则重放最近放过的那首
+	PlayPrevious(musicOn bool)
+	// Replay 从头重放最近放的那首
+	Replay()
+	// AnswerLastSong 本地回答“刚才那首歌叫什么”；musicOn 表示说话时音乐正在出声
+	AnswerLastSong(musicOn bool)
+	// AnswerNowPlaying 本地回答正在放的歌，播报结束后返回
+	AnswerNowPlaying()
+	// Favorite 收藏/取消收藏当前曲目、播放或播报收藏列表
+	Favorite(action string)
+	// Seek 当前曲目内跳转并播报确认
+	Seek(cmd seekCommand)
+	// SetPlayMode 切换并保存播放模式、播报确认；没在放歌时按新模式开始放
+	SetPlayMode(mode PlayMode)
+	// SavePlayMode 只切换并保存播放模式，不播报也不开始放
+	SavePlayMode(mode PlayMode)
+	// AdjustVolume 调整并保存设备音量；speaking 时用提示音确认，不插话
+	AdjustVolume(cmd volumeCommand, speaking bool)
+	AnswerTime()
+	// Chat 异步开始一轮 LLM 对话，结束后须 Dispatch(EvReplyDone{Session: session})
+	Chat(ctx context.Context, session, text string, enableSearch bool)
+	ClearMemory()
+	Exit()
+}
+
+// ---------- Assistant ----------
+
+type Assistant struct {
+	fx assistantEffects
+
+	mu         sync.Mutex
+	awake      bool
+	thinking   bool
+	speaking   bool
+	music      musicState
+	lastActive time.Time
+
+	sessionCtx    context.Context
+	sessionCancel context.CancelFunc
+	sessionID     string
+
+	// muted 打断词命中后丢弃后续播报，直到下一条非打断指令；音频路径上高频读取，单独用原子量
+	muted atomic.Bool
+}
+
+func newAssistant(fx assistantEffects) *Assistant {
+	a := &Assistant{fx: fx}
+	a.sessionCtx, a.sessionCancel = context.WithCancel(context.Background())
+	a.sessionID = uuid.New().String()
+	return a
+}
+
+var assistant = newAssistant(deviceEffects{})
+
+// State 当前状态
+func (a *Assistant) State() AssistantState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stateLocked()
+}
+
+func (a *Assistant) stateLocked() AssistantState {
+	switch {
+	case a.music == musicDucked:
+		return StateDucked
+	case a.speaking:
+		return StateSpeaking
+	case a.music == musicPlaying:
+		return StatePlaying
+	case a.thinking:
+		return StateThinking
+	case a.awake:
+		return StateListening
+	}
+	return StateSleeping
+}
+
+// Awake 是否处于唤醒态（放歌/播报时也可能是唤醒态）
+func (a *Assistant) Awake() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.awake
+}
+
+// Busy 正在播报或放歌
+func (a *Assistant) Busy() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.busyLocked()
+}
+
+func (a *Assistant) busyLocked() bool { return a.speaking || a.musicOnLocked() }
+
+// musicOnLocked 音乐正在出声（含压低）
+func (a *Assistant) musicOnLocked() bool { return a.music == musicPlaying || a.music == musicDucked }
+
+// Muted 打断后是否静音播报
+func (a *Assistant) Muted() bool { return a.muted.Load() }
+
+// Session 当前会话的 ctx 与 ID；ID 变化表示旧会话的合成需要丢弃
+func (a *Assistant) Session() (context.Context, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sessionCtx, a.sessionID
+}
+
+// NewSession 取消当前会话并开启新会话
+func (a *Assistant) NewSession() context.Context {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sessionCancel != nil {
+		a.sessionCancel()
+	}
+	a.sessionCtx, a.sessionCancel = context.WithCancel(context.Background())
+	a.sessionID = uuid.New().String()
+	return a.sessionCtx
+}
+
+// Dispatch 处理一个事件：锁内迁移状态，锁外执行动作
+func (a *Assistant) Dispatch(ev AssistantEvent) Transition {
+	switch e := ev.(type) {
+	case EvASRResult:
+		return a.onASR(e)
+	case EvKeywordSpotted:
+		return a.update(ev, func() {
+			if !a.awake {
+				log.Printf("🔔 [KWS] 检测到唤醒词: %s", e.Keyword)
+				emitEvent(EventWake, "kws", e.Keyword)
+			}
+			a.awake = true
+			a.lastActive = time.Now()
+		})
+	case EvSleep:
+		wasAwake := false
+		tr := a.update(ev, func() {
+			wasAwake = a.awake
+			a.awake = false
+		})
+		if wasAwake {
+			a.fx.ClearMemory()
+			emitEvent(EventSleep, "", "")
+		}
+		return tr
+	case EvIdleCheck:
+		return a.onIdleCheck(e.Now)
+	case EvDuck:
+		tr := a.update(ev, func() {
+			if a.music == musicPlaying {
+				a.music = musicDucked
+			}
+		})
+		a.fx.Duck()
+		return tr
+	case EvUnduck:
+		tr := a.update(ev, func() {
+			if a.music == musicDucked {
+				a.music = musicPlaying
+			}
+		})
+		a.fx.Unduck()
+		return tr
+	case EvSpeakingStarted:
+		return a.update(ev, func() { a.speaking = true })
+	case EvSpeakingDone:
+		return a.update(ev, func() { a.speaking = false })
+	case EvMusicStarted:
+		return a.update(ev, func() { a.music = musicPlaying })
+	case EvMusicStopped:
+		return a.update(ev, func() { a.music = musicOff })
+	case EvMusicPaused:
+		return a.update(ev, func() { a.music = musicPaused })
+	case EvReplyDone:
+		return a.update(ev, func() {
+			if e.Session == a.sessionID {
+				a.thinking = false
+			}
+		})
+	case EvStop:
+		return a.stop()
+	}
+	return a.update(ev, func() {})
+}
+
+// update 在同一个锁区间内读迁移前状态、修改、读迁移后状态，保证记下的 From→To 确实发生过；
+// 状态有变化时记日志和 state 事件
+func (a *Assistant) update(ev AssistantEvent, mutate func()) Transition {
+	a.mu.Lock()
+	from := a.stateLocked()
+	mutate()
+	to := a.stateLocked()
+	a.mu.Unlock()
+	if to != from {
+		log.Printf("🔀 [状态] %s -> %s (%s)", from, to, ev.eventName())
+		emitEvent(EventState, to.String(), ev.eventName())
+	}
+	return Transition{From: from, To: to}
+}
+
+// stop 物理清理：取消会话，切断所有声音源
+func (a *Assistant) stop() Transition {
+	log.Println("物理清理: 强制切断所有声音源")
+	tr := a.update(EvStop{}, func() {
+		if a.sessionCancel != nil {
+			a.sessionCancel()
+		}
+		a.thinking = false
+		a.speaking = false
+		if a.music != musicPaused {
+			a.music = musicOff
+		}
+	})
+	a.fx.Silence()
+	return tr
+}
+
+func (a *Assistant) onIdleCheck(now time.Time) Transition {
+	slept := false
+	tr := a.update(EvIdleCheck{Now: now}, func() {
+		// 正在播报/放歌时不进入休眠，避免“音乐无人能停”的体验
+		if !a.awake || a.busyLocked() || a.lastActive.IsZero() || now.Sub(a.lastActive) <= wakeIdleTimeout {
+			return
+		}
+		a.awake = false
+		slept = true
+	})
+	if slept {
+		a.fx.ClearMemory()
+		emitEvent(EventSleep, "", "")
+		log.Println("😴 [伪唤醒] 长时间无交互，进入休眠态，等待唤醒词...")
+	}
+	return tr
+}
+
+// ---------- 识别结果的判定 ----------
+
+// asrIntent 识别结果的判定（也是 intent 事件的 detail）
+type asrIntent string
+
+const (
+	intentWakeAck       asrIntent = "wake_ack"       // 只有唤醒词（或本地 KWS 已唤醒但没识别出文字）：回应“我在”
+	intentEmpty         asrIntent = "empty"          // 没识别出文字
+	intentIgnoreAsleep  asrIntent = "ignore_asleep"  // 休眠态未命中唤醒词
+	intentExit          asrIntent = "exit"           // 退出程序
+	intentPlayMode      asrIntent = "play_mode"      // 切换播放模式
+	intentVolume        asrIntent = "volume"         // 调整设备音量
+	intentResume        asrIntent = "resume"         // 继续播放暂停的歌
+	intentPause         asrIntent = "pause"          // 放歌时的打断词：暂停
+	intentPreviousTrack asrIntent = "previous_track" // 上一首
+	intentReplay        asrIntent = "replay"         // 重放刚才的歌
+	intentLastSong      asrIntent = "last_song"      // 问刚才放的歌
+	intentNowPlaying    asrIntent = "now_playing"    // 问正在放的歌
+	intentSeek          asrIntent = "seek"           // 曲内跳转
+	intentFavorite      asrIntent = "favorite"       // 收藏相关
+	intentInvalidMusic  asrIntent = "invalid_music"  // 点的歌曲库里没有
+	intentQuickSwitch   asrIntent = "quick_switch"   // 放歌时切歌
+	intentRandomPlay    asrIntent = "random_play"    // 随机放歌
+	intentInterrupt     asrIntent = "interrupt"      // 打断播报
+	intentBusyIgnore    asrIntent = "busy_ignore"    // 忙碌时的无关指令
+	intentTimeQuery     asrIntent = "time_query"     // 本地报时
+	intentLLM           asrIntent = "llm"            // 交给 LLM
+	intentLLMSearch     asrIntent = "llm_search"     // 交给 LLM 并开联网搜索
+)
+
+// asrDecision processASR 的判定结果
+type asrDecision struct {
+	intent   asrIntent
+	text     string // 剥离唤醒词后的指令
+	stop     bool   // 忙碌穿透：执行前先切断当前声音并开新会话
+	mode     PlayMode
+	music    musicState // 判定时的音乐状态（执行时音乐可能已被切断）
+	seek     seekCommand
+	fav      string // favorite 的动作：add / remove / play / list
+	volume   volumeCommand
+	speaking bool     // 判定时是否正在播报
+	noPrev   bool     // previous_track：前面没有歌，只播报，不切断当前声音
+	withMode PlayMode // 随指令一起说的播放模式（“循环播放周杰伦的歌”），执行前先切换
+}
+
+// onASR 判定本身是一次迁移（返回的 Transition 即判定前后的状态）；执行动作时的后续迁移各自记录
+func (a *Assistant) onASR(ev EvASRResult) Transition {
+	if ev.LocalWake {
+		emitEventDOA(EventASR, "kws", ev.Text, ev.DOA)
+	} else {
+		emitEventDOA(EventASR, "", ev.Text, ev.DOA)
+	}
+	look := a.lookup(ev.Text)
+	var d asrDecision
+	tr := a.update(ev, func() { d = a.decideLocked(ev.Text, ev.LocalWake, look) })
+	tr.Intent = d.intent
+	a.execute(d)
+	return tr
+}
+
+// asrLookups 判定要用到的曲库、播放历史查询结果。
+// 这些 effects 会取曲库和 musicMgr 的锁，必须在加 Assistant.mu 之前查好，锁内只读结果
+type asrLookups struct {
+	songs           map[string]bool // 点歌关键词 → 曲库里是否有
+	prevOn, prevOff bool            // 上一首是否有歌可放：音乐开着 / 没开时
+}
+
+func (l asrLookups) hasPrevious(musicOn bool) bool {
+	if musicOn {
+		return l.prevOn
+	}
+	return l.prevOff
+}
+
+// lookup 按判定时可能处理的每种指令文本（原文、剥离唤醒词后、去掉播放模式词后）预先查好曲库和播放历史
+func (a *Assistant) lookup(text string) asrLookups {
+	l := asrLookups{songs: map[string]bool{}}
+	cands := []string{text}
+	if tail, hit, _ := stripWakeAndGetTail(text); hit && strings.TrimSpace(tail) != "" {
+		cands = append(cands, tail)
+	}
+	for i := 0; i < len(cands); i++ {
+		t := cands[i]
+		if _, rest, ok := parsePlayModeCommand(t); ok && rest != "" {
+			cands = append(cands, playModeRequest(rest))
+		}
+		if hasMusicIntent(t) && !isRandomPlayIntent(t) {
+			if q := extractSongQuery(t); q != "" {
+				if _, done := l.songs[q]; !done {
+					l.songs[q] = a.fx.SongExists(q)
+				}
+			}
+		}
+		if isPreviousTrackCommand(t) {
+			l.prevOn, l.prevOff = a.fx.HasPrevious(true), a.fx.HasPrevious(false)
+		}
+	}
+	return l
+}
+
+// decideLocked 在锁内完成判定和状态迁移（唤醒、活跃时间、静音），不执行任何动作；曲库和播放历史只读 look
+func (a *Assistant) decideLocked(text string, localWake bool, look asrLookups) asrDecision {
+
+	if text == "" {
+		if localWake {
+			// 本地已确认唤醒，云端没识别出文字也要回应一声
+			return asrDecision{intent: intentWakeAck}
+		}
+		return asrDecision{intent: intentEmpty}
+	}
+	interrupt := isInterrupt(text)
+	if !interrupt {
+		a.muted.Store(false)
+	}
+
+	// ================= 伪唤醒门控 =================
+	tail, hitWake, pureWake := stripWakeAndGetTail(text)
+
+	if !a.awake {
+		// 休眠态：只有命中唤醒词才进入后续处理，其余任何指令都忽略
+		if !hitWake {
+			log.Printf("[休眠] 未检测到唤醒词，忽略: [%s]", text)
+			return asrDecision{intent: intentIgnoreAsleep, text: text}
+		}
+
+		a.awake = true
+		a.lastActive = time.Now()
+		emitEvent(EventWake, "asr", text)
+
+		// 纯唤醒词：播报“我在”
+		if pureWake {
+			log.Println("[伪唤醒] 唤醒成功")
+			return asrDecision{intent: intentWakeAck, text: text}
+		}
+
+		// 唤醒词后携带指令：直接处理（不播“我在”）
+		if strings.TrimSpace(tail) != "" {
+			log.Printf("[伪唤醒] 唤醒并转入指令: [%s]", tail)
+			text = tail
+		} else {
+			// 理论不会出现：pureWake=false 但 tail 为空；兜底不改原 text
+			log.Printf("[伪唤醒] 唤醒命中但未解析到后续指令，按原文处理: [%s]", text)
+		}
+	} else {
+		// 唤醒态：刷新活跃时间；若仅唤醒词则回应“我在”，若携带指令则剥离后继续处理
+		a.lastActive = time.Now()
+		if hitWake {
+			if pureWake {
+				log.Println("[伪唤醒] 收到唤醒词")
+				return asrDecision{intent: intentWakeAck, text: text}
+			}
+			if strings.TrimSpace(tail) != "" && tail != text {
+				text = tail
+			}
+		} else if localWake {
+			// 本地 KWS 命中但云端文本未包含唤醒词（同音字等），按纯唤醒处理
+			log.Printf("[KWS] 唤醒段文本未匹配唤醒词，按纯唤醒处理: [%s]", text)
+			return asrDecision{intent: intentWakeAck, text: text}
+		}
+	}
+
+	log.Printf("ASR识别结果: [%s]", text)
+
+	// 1. 二级打断：退出判定
+	if isExit(text) {
+		log.Println("收到退出指令，关闭系统")
+		return asrDecision{intent: intentExit, text: text}
+	}
+
+	// 2. 当前占用状态
+	isMusicBusy := a.musicOnLocked()
+	busy := a.busyLocked()
+
+	// 2.5 播放模式切换：不打断正在放的歌，忙碌时也直接执行；
+	// 必须在点歌判定之前，否则“随机播放”会被当成点一首叫“随机”的歌
+	if mode, rest, ok := parsePlayModeCommand(text); ok {
+		if rest == "" {
+			return asrDecision{intent: intentPlayMode, text: text, mode: mode}
+		}
+		// “循环播放周杰伦的歌”：先切模式，剩下的部分按点歌继续判定
+		d := a.decideLocked(playModeRequest(rest), false, look)
+		d.withMode = mode
+		return d
+	}
+	// 2.55 音量：任何时候都直接调，不打断音乐和播报
+	if cmd, ok := parseVolumeCommand(text); ok {
+		return asrDecision{intent: intentVolume, text: text, volume: cmd, speaking: a.speaking}
+	}
+	// 2.6 继续播放暂停的歌；暂停后问了别的问题、播报还没完时也直接切断播报接着放
+	if a.music == musicPaused && isResume(text) {
+		return asrDecision{intent: intentResume, text: text, stop: a.speaking}
+	}
+	// 2.65 曲内跳转（“快进30秒”“从头放”）：只在有歌时生效，不打断音乐
+	if a.music != musicOff {
+		if cmd, ok := parseSeekCommand(text); ok {
+			return asrDecision{intent: intentSeek, text: text, seek: cmd}
+		}
+	}
+	// 2.7 播放历史：问歌名不打断音乐；上一首/重放切断当前声音后直接放，不经过 LLM
+	if isLastSongQuery(text) {
+		return asrDecision{intent: intentLastSong, text: text, music: a.music}
+	}
+	if a.music != musicOff && isNowPlayingQuery(text) {
+		return asrDecision{intent: intentNowPlaying, text: text}
+	}
+	// 2.8 收藏：播放收藏切断当前声音，其余只播报不打断音乐
+	if action, ok := parseFavoriteCommand(text); ok {
+		return asrDecision{intent: intentFavorite, text: text, fav: action, stop: action == favoritePlay && a.busyLocked()}
+	}
+	if isPreviousTrackCommand(text) {
+		// 前面没有歌时正在放的歌照常放，只播报一句
+		if !look.hasPrevious(a.music != musicOff) {
+			return asrDecision{intent: intentPreviousTrack, text: text, music: a.music, noPrev: true}
+		}
+		return asrDecision{intent: intentPreviousTrack, text: text, stop: a.busyLocked(), music: a.music}
+	}
+	if isReplayCommand(text) {
+		return asrDecision{intent: intentReplay, text: text, stop: a.busyLocked(), music: a.music}
+	}
+
+	// 3. 意图判断与错误指令过滤
+	interrupt = isInterrupt(text)
+	randomPlay := isRandomPlayIntent(text)
+	musicReq := hasMusicIntent(text) || randomPlay
+	quickSwitch := isQuickSwitchCommand(text, isMusicBusy)
+	songQuery := ""
+	invalidMusic := false
+	if musicReq && !randomPlay {
+		songQuery = extractSongQuery(text)
+		if songQuery == "" || !look.songs[songQuery] {
+			invalidMusic = true
+			musicReq = false
+		}
+	}
+	if invalidMusic && !interrupt && !quickSwitch {
+		log.Printf("音乐指令未命中本地曲库，忽略: text=%q query=%q", text, songQuery)
+		return asrDecision{intent: intentInvalidMusic, text: text}
+	}
+
+	// 4. 忙碌状态下的穿透逻辑：只允许打断词、点歌、切歌穿透
+	stop := false
+	if busy {
+		log.Printf("忙碌判断: text=%q cleaned=%q musicReq=%v randomPlay=%v interrupt=%v quickSwitch=%v", text, normalizeIntentText(text), musicReq, randomPlay, interrupt, quickSwitch)
+		if !interrupt && !musicReq && !quickSwitch {
+			// 真正的无关闲聊，在忙碌时依然拦截
+			log.Printf("锁定拦截: 忽略非控制类指令: [%s]", text)
+			return asrDecision{intent: intentBusyIgnore, text: text}
+		}
+		log.Printf("忙碌穿透: 指令 [%s] 合法，执行物理清理并重置意图", text)
+		stop = true
+		switch {
+		case quickSwitch:
+			// 快速切歌：直接随机播放，避免 LLM 推理延迟
+			return asrDecision{intent: intentQuickSwitch, text: text, stop: true}
+		case randomPlay:
+			return asrDecision{intent: intentRandomPlay, text: text, stop: true}
+		case interrupt:
+			// 打断词：物理切断后直接返回，避免再次进入 LLM/TTS；放着的歌只暂停，之后可以“继续播放”
+			a.muted.Store(true)
+			if isMusicBusy {
+				return asrDecision{intent: intentPause, text: text, stop: true}
+			}
+			return asrDecision{intent: intentInterrupt, text: text, stop: true}
+		}
+		// 点歌（“听庙堂之外”）：切断后继续往下走，交给 LLM 调用 play_music 工具
+	}
+
+	// 4.5 本地可答的问题（报时），不依赖 LLM，断网时也能用
+	if isTimeQuery(text) {
+		return asrDecision{intent: intentTimeQuery, text: text, stop: stop}
+	}
+
+	// 5. 随机播放意图：直接随机播放
+	if randomPlay {
+		return asrDecision{intent: intentRandomPlay, text: text, stop: stop}
+	}
+
+	// 6. 联网搜索判定，交给 LLM
+	for _, k := range []string{"天气", "今天", "星期几", "实时", "最新"} {
+		if strings.Contains(text, k) {
+			return asrDecision{intent: intentLLMSearch, text: text, stop: stop}
+		}
+	}
+	return asrDecision{intent: intentLLM, text: text, stop: stop}
+}
+
+// execute 在锁外执行判定对应的动作
+func (a *Assistant) execute(d asrDecision) {
+	exclude := ""
+	if d.intent == intentQuickSwitch || d.intent == intentRandomPlay {
+		exclude = a.fx.CurrentSong()
+	}
+	if d.intent == intentPause {
+		// 先暂停，后面的物理清理就不会把这首歌停掉；暂停成功才记为暂停，否则按停止处理
+		if a.fx.PauseMusic() {
+			a.Dispatch(EvMusicPaused{})
+		}
+	}
+	if d.stop {
+		a.Dispatch(EvStop{})
+		a.NewSession()
+	}
+	if d.withMode != "" {
+		a.fx.SavePlayMode(d.withMode)
+	}
+
+	switch d.intent {
+	case intentExit:
+		emitIntent(d.intent, d.text)
+		a.Dispatch(EvStop{})
+		a.fx.Exit()
+		return
+	case intentQuickSwitch, intentRandomPlay:
+		emitIntent(d.intent, d.text)
+		a.fx.PlayRandom(exclude)
+		return
+	case intentInterrupt, intentPause:
+		emitIntent(d.intent, d.text)
+		return
+	case intentResume:
+		emitIntent(d.intent, d.text)
+		if a.fx.ResumeMusic() {
+			a.Dispatch(EvMusicStarted{Path: a.fx.CurrentSong()})
+		} else {
+			a.Dispatch(EvMusicStopped{})
+		}
+		return
+	case intentPlayMode:
+		emitIntent(d.intent, d.text)
+		a.fx.SetPlayMode(d.mode)
+		a.Dispatch(EvUnduck{})
+		return
+	case intentSeek:
+		emitIntent(d.intent, d.text)
+		a.fx.Seek(d.seek)
+		a.Dispatch(EvUnduck{})
+		return
+	case intentVolume:
+		emitIntent(d.intent, d.text)
+		a.fx.AdjustVolume(d.volume, d.speaking)
+		a.Dispatch(EvUnduck{})
+		return
+	case intentNowPlaying:
+		emitIntent(d.intent, d.text)
+		a.fx.AnswerNowPlaying()
+		a.Dispatch(EvUnduck{})
+		return
+	case intentFavorite:
+		emitIntent(d.intent, d.text)
+		a.fx.Favorite(d.fav)
+		if d.fav != favoritePlay {
+			a.Dispatch(EvUnduck{})
+		}
+		return
+	case intentLastSong:
+		emitIntent(d.intent, d.text)
+		a.fx.AnswerLastSong(d.music == musicPlaying || d.music == musicDucked)
+		a.Dispatch(EvUnduck{})
+		return
+	case intentPreviousTrack:
+		emitIntent(d.intent, d.text)
+		a.fx.PlayPrevious(d.music != musicOff)
+		if d.noPrev {
+			a.Dispatch(EvUnduck{})
+		}
+		return
+	case intentReplay:
+		emitIntent(d.intent, d.text)
+		a.fx.Replay()
+		return
+	case intentTimeQuery:
+		emitIntent(d.intent, d.text)
+		a.fx.AnswerTime()
+		return
+	case intentLLM, intentLLMSearch:
+		a.NewSession()
+		var ctx context.Context
+		var session string
+		a.update(EvASRResult{Text: d.text}, func() {
+			a.thinking = true
+			ctx, session = a.sessionCtx, a.sessionID
+		})
+		emitIntent(d.intent, d.text)
+		a.fx.Chat(ctx, session, d.text, d.intent == intentLLMSearch)
+		return
+	}
+
+	// 其余判定（wake_ack / empty / ignore_asleep / invalid_music / busy_ignore）：回应后恢复音乐
+	emitIntent(d.intent, d.text)
+	if d.intent == intentWakeAck {
+		a.fx.SpeakWakeAck()
+	}
+	a.Dispatch(EvUnduck{})
+}
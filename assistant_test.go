@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEffects 记录状态机触发的动作，代替声卡/云端
+type fakeEffects struct {
+	mu      sync.Mutex
+	calls   []string
+	songs   map[string]bool
+	current string
+	paused  bool
+	noPrev  bool
+	session string // 最近一次 Chat 的会话
+	ended   bool   // 歌恰好放完，没有可暂停的内容
+	owner   *Assistant
+	locked  bool // 查询类 effects 曾在 owner.mu 持有时被调用
+}
+
+// checkUnlocked 查询类 effects 会取曲库、musicMgr 的锁，不能在 Assistant.mu 下调用
+func (f *fakeEffects) checkUnlocked() {
+	if f.owner == nil {
+		return
+	}
+	if !f.owner.mu.TryLock() {
+		f.locked = true
+		return
+	}
+	f.owner.mu.Unlock()
+}
+
+func (f *fakeEffects) record(format string, args ...interface{}) {
+	f.mu.Lock()
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+	f.mu.Unlock()
+}
+
+func (f *fakeEffects) take() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := strings.Join(f.calls, ",")
+	f.calls = nil
+	return out
+}
+
+func (f *fakeEffects) SpeakWakeAck()                { f.record("wake_ack") }
+func (f *fakeEffects) Duck()                        { f.record("duck") }
+func (f *fakeEffects) Unduck()                      { f.record("unduck") }
+func (f *fakeEffects) Silence()                     { f.record("silence") }
+func (f *fakeEffects) SongExists(query string) bool { f.checkUnlocked(); return f.songs[query] }
+func (f *fakeEffects) CurrentSong() string          { return f.current }
+func (f *fakeEffects) PlayRandom(exclude string)    { f.record("play_random:%s", exclude) }
+func (f *fakeEffects) SetPlayMode(mode PlayMode)    { f.record("play_mode:%s", mode) }
+func (f *fakeEffects) PauseMusic() bool             { f.record("pause"); f.paused = !f.ended; return f.paused }
+func (f *fakeEffects) ResumeMusic() bool            { f.record("resume"); return f.paused }
+func (f *fakeEffects) PlayPrevious(musicOn bool)    { f.record("previous:%v", musicOn) }
+func (f *fakeEffects) Replay()                      { f.record("replay") }
+func (f *fakeEffects) Seek(cmd seekCommand)         { f.record("seek:%s:%v", cmd.Offset, cmd.Relative) }
+func (f *fakeEffects) AnswerNowPlaying()            { f.record("now_playing") }
+func (f *fakeEffects) Favorite(action string)       { f.record("favorite:%s", action) }
+func (f *fakeEffects) AnswerLastSong(musicOn bool)  { f.record("last_song:%v", musicOn) }
+func (f *fakeEffects) AnswerTime()                  { f.record("answer_time") }
+func (f *fakeEffects) AdjustVolume(cmd volumeCommand, speaking bool) {
+	f.record("volume:%+v:%v", cmd, speaking)
+}
+func (f *fakeEffects) SavePlayMode(mode PlayMode) { f.record("save_mode:%s", mode) }
+func (f *fakeEffects) HasPrevious(musicOn bool) bool {
+	f.checkUnlocked()
+	return !f.noPrev
+}
+func (f *fakeEffects) ClearMemory() { f.record("clear_memory") }
+func (f *fakeEffects) Exit()        { f.record("exit") }
+func (f *fakeEffects) Chat(ctx context.Context, session, text string, enableSearch bool) {
+	f.mu.Lock()
+	f.session = session
+	f.mu.Unlock()
+	f.record("chat:%s:%v", text, enableSearch)
+}
+
+// newTestAssistant 按给定初始状态构造状态机（只通过事件驱动，不直接改字段）
+func newTestAssistant(awake, speaking bool, music musicState) (*Assistant, *fakeEffects) {
+	fx := &fakeEffects{songs: map[string]bool{"庙堂之外": true}, current: "/music/陈楚生《庙堂之外》.wav"}
+	a := newAssistant(fx)
+	if awake {
+		a.Dispatch(EvKeywordSpotted{Keyword: "你好小瑞"})
+	}
+	if speaking {
+		a.Dispatch(EvSpeakingStarted{})
+	}
+	if music != musicOff {
+		a.Dispatch(EvMusicStarted{Path: fx.current})
+	}
+	if music == musicDucked {
+		a.Dispatch(EvDuck{})
+	}
+	fx.take()
+	return a, fx
+}
+
+func TestAssistantASRTransitions(t *testing.T) {
+	cases := []struct {
+		name      string
+		awake     bool
+		speaking  bool
+		music     musicState
+		text      string
+		localWake bool
+		noPrev    bool
+
+		intent asrIntent
+		calls  string
+		state  AssistantState
+	}{
+		{name: "休眠-空文本", text: "", intent: intentEmpty, calls: "unduck", state: StateSleeping},
+		{name: "休眠-KWS但无文本", text: "", localWake: true, intent: intentWakeAck, calls: "wake_ack,unduck", state: StateSleeping},
+		{name: "休眠-无唤醒词", text: "今天天气怎么样", intent: intentIgnoreAsleep, calls: "unduck", state: StateSleeping},
+		{name: "休眠-纯唤醒", text: "你好小瑞", intent: intentWakeAck, calls: "wake_ack,unduck", state: StateListening},
+		{name: "休眠-唤醒带指令", text: "你好小瑞，现在几点了", intent: intentTimeQuery, calls: "answer_time", state: StateListening},
+		{name: "唤醒-纯唤醒", awake: true, text: "你好小瑞", intent: intentWakeAck, calls: "wake_ack,unduck", state: StateListening},
+		{name: "唤醒-KWS但文本不含唤醒词", awake: true, text: "你好小", localWake: true, intent: intentWakeAck, calls: "wake_ack,unduck", state: StateListening},
+		{name: "唤醒-闲聊", awake: true, text: "讲个笑话", intent: intentLLM, calls: "chat:讲个笑话:false", state: StateThinking},
+		{name: "唤醒-联网", awake: true, text: "今天天气怎么样", intent: intentLLMSearch, calls: "chat:今天天气怎么样:true", state: StateThinking},
+		{name: "唤醒-退出", awake: true, music: musicPlaying, text: "关机", intent: intentExit, calls: "silence,exit", state: StateListening},
+		{name: "唤醒-曲库没有", awake: true, text: "播放稻香", intent: intentInvalidMusic, calls: "unduck", state: StateListening},
+		{name: "唤醒-随机放歌", awake: true, text: "来首歌", intent: intentRandomPlay, calls: "play_random:/music/陈楚生《庙堂之外》.wav", state: StateListening},
+		{name: "播报中-闲聊被拦截", awake: true, speaking: true, text: "讲个笑话", intent: intentBusyIgnore, calls: "unduck", state: StateSpeaking},
+		{name: "放歌中-切歌", awake: true, music: musicDucked, text: "下一首", intent: intentQuickSwitch, calls: "silence,play_random:/music/陈楚生《庙堂之外》.wav", state: StateListening},
+		{name: "播报中-随机放歌", awake: true, speaking: true, text: "放首歌", intent: intentRandomPlay, calls: "silence,play_random:/music/陈楚生《庙堂之外》.wav", state: StateListening},
+		{name: "放歌中-单曲循环", awake: true, music: musicDucked, text: "单曲循环", intent: intentPlayMode, calls: "play_mode:repeat_one,unduck", state: StatePlaying},
+		{name: "唤醒-循环播放某首歌", awake: true, text: "循环播放庙堂之外", intent: intentLLM, calls: "save_mode:repeat_all,chat:播放庙堂之外:false", state: StateThinking},
+		{name: "放歌中-循环播放曲库没有的歌", awake: true, music: musicDucked, text: "循环播放稻香", intent: intentInvalidMusic, calls: "save_mode:repeat_all,unduck", state: StatePlaying},
+		{name: "唤醒-随机播放是模式不是歌名", awake: true, text: "随机播放", intent: intentPlayMode, calls: "play_mode:shuffle,unduck", state: StateListening},
+		{name: "放歌中-上一首", awake: true, music: musicDucked, text: "上一首", intent: intentPreviousTrack, calls: "silence,previous:true", state: StateListening},
+		{name: "放歌中-上一首但前面没有歌", awake: true, music: musicDucked, text: "上一首", noPrev: true, intent: intentPreviousTrack, calls: "previous:true,unduck", state: StatePlaying},
+		{name: "唤醒-再放一遍", awake: true, text: "再放一遍刚才那首", intent: intentReplay, calls: "replay", state: StateListening},
+		{name: "放歌中-问刚才的歌名", awake: true, music: musicDucked, text: "刚才那首歌叫什么", intent: intentLastSong, calls: "last_song:true,unduck", state: StatePlaying},
+		{name: "唤醒-问刚才的歌名", awake: true, text: "刚才放的是什么歌", intent: intentLastSong, calls: "last_song:false,unduck", state: StateListening},
+		{name: "放歌中-问这是什么歌", awake: true, music: musicDucked, text: "这是什么歌", intent: intentNowPlaying, calls: "now_playing,unduck", state: StatePlaying},
+		{name: "放歌中-泛问还有多久不是问歌", awake: true, music: musicDucked, text: "离春节还有多久", intent: intentBusyIgnore, calls: "unduck", state: StatePlaying},
+		{name: "放歌中-问别的歌谁唱的不是问歌", awake: true, music: musicDucked, text: "《青花瓷》是谁唱的", intent: intentBusyIgnore, calls: "unduck", state: StatePlaying},
+		{name: "唤醒-泛问还有多久交给LLM", awake: true, text: "离春节还有多久", intent: intentLLM, calls: "chat:离春节还有多久:false", state: StateThinking},
+		{name: "没在放歌-问这是什么歌交给LLM", awake: true, text: "这是什么歌", intent: intentLLM, calls: "chat:这是什么歌:false", state: StateThinking},
+		{name: "放歌中-收藏", awake: true, music: musicDucked, text: "收藏这首歌", intent: intentFavorite, calls: "favorite:add,unduck", state: StatePlaying},
+		{name: "放歌中-播放收藏", awake: true, music: musicDucked, text: "播放我喜欢的歌", intent: intentFavorite, calls: "silence,favorite:play", state: StateListening},
+		{name: "放歌中-快进", awake: true, music: musicDucked, text: "快进三十秒", intent: intentSeek, calls: "seek:30s:true,unduck", state: StatePlaying},
+		{name: "放歌中-从头放", awake: true, music: musicDucked, text: "从头播放", intent: intentSeek, calls: "seek:0s:false,unduck", state: StatePlaying},
+		{name: "没在放歌-快进交给LLM", awake: true, text: "快进三十秒", intent: intentLLM, calls: "chat:快进三十秒:false", state: StateThinking},
+		{name: "放歌中-大声点", awake: true, music: musicDucked, text: "大声点", intent: intentVolume, calls: "volume:{Level:0 Delta:10 Relative:true}:false,unduck", state: StatePlaying},
+		{name: "播报中-调音量不打断", awake: true, speaking: true, text: "音量调到五十", intent: intentVolume, calls: "volume:{Level:50 Delta:0 Relative:false}:true,unduck", state: StateSpeaking},
+		{name: "放歌中-打断词暂停", awake: true, music: musicDucked, text: "暂停", intent: intentPause, calls: "pause,silence", state: StateListening},
+		{name: "播报中-打断", awake: true, speaking: true, text: "停止", intent: intentInterrupt, calls: "silence", state: StateListening},
+		{name: "放歌中-点歌交给LLM", awake: true, music: musicDucked, text: "播放庙堂之外", intent: intentLLM, calls: "silence,chat:播放庙堂之外:false", state: StateThinking},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, fx := newTestAssistant(c.awake, c.speaking, c.music)
+			fx.noPrev = c.noPrev
+			tr := a.Dispatch(EvASRResult{Text: c.text, LocalWake: c.localWake})
+			if tr.Intent != c.intent {
+				t.Fatalf("intent=%q want %q", tr.Intent, c.intent)
+			}
+			if got := fx.take(); got != c.calls {
+				t.Fatalf("动作=%q want %q", got, c.calls)
+			}
+			if a.State() != c.state {
+				t.Fatalf("状态=%s want %s", a.State(), c.state)
+			}
+		})
+	}
+}
+
+func TestAssistantInterruptMutesUntilNextCommand(t *testing.T) {
+	a, _ := newTestAssistant(true, true, musicOff)
+	ctx, _ := a.Session()
+	a.Dispatch(EvASRResult{Text: "别说了"})
+	if !a.Muted() {
+		t.Fatal("打断后应静音后续播报")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("打断应取消当前会话")
+	}
+	if newCtx, _ := a.Session(); newCtx.Err() != nil {
+		t.Fatal("打断后应开启新会话")
+	}
+	a.Dispatch(EvASRResult{Text: "讲个笑话"})
+	if a.Muted() {
+		t.Fatal("新的指令应解除静音")
+	}
+}
+
+func TestAssistantStopAndMusic(t *testing.T) {
+	a, fx := newTestAssistant(true, true, musicPlaying)
+	if a.State() != StateSpeaking {
+		t.Fatalf("播报优先于放歌，state=%s", a.State())
+	}
+	a.Dispatch(EvDuck{})
+	if a.State() != StateDucked {
+		t.Fatalf("压低音乐后 state=%s", a.State())
+	}
+	a.Dispatch(EvSpeakingDone{})
+	if tr := a.Dispatch(EvUnduck{}); tr.From != StateDucked || tr.To != StatePlaying {
+		t.Fatalf("恢复音乐: %+v", tr)
+	}
+	if got := fx.take(); got != "duck,unduck" {
+		t.Fatalf("动作=%q", got)
+	}
+
+	ctx, _ := a.Session()
+	if tr := a.Dispatch(EvStop{}); tr.To != StateListening {
+		t.Fatalf("停止后 state=%s", tr.To)
+	}
+	if ctx.Err() == nil || fx.take() != "silence" {
+		t.Fatal("停止应取消会话并切断声音")
+	}
+
+	// 没有音乐时 Duck 只下发动作，不改状态
+	if tr := a.Dispatch(EvDuck{}); tr.To != StateListening {
+		t.Fatalf("无音乐时 Duck 后 state=%s", tr.To)
+	}
+}
+
+func TestAssistantPauseResume(t *testing.T) {
+	a, fx := newTestAssistant(true, false, musicPlaying)
+	a.Dispatch(EvDuck{})
+	fx.take()
+	if tr := a.Dispatch(EvASRResult{Text: "等一下"}); tr.Intent != intentPause || tr.From != StateDucked || a.State() != StateListening {
+		t.Fatalf("放歌时打断词应暂停: %+v state=%s", tr, a.State())
+	}
+	if a.Busy() {
+		t.Fatal("暂停的歌不应锁住闲聊")
+	}
+	// 暂停期间问个问题，回答播报中说“继续播放”
+	a.Dispatch(EvASRResult{Text: "讲个笑话"})
+	a.Dispatch(EvSpeakingStarted{})
+	fx.take()
+	if tr := a.Dispatch(EvASRResult{Text: "继续播放"}); tr.Intent != intentResume || a.State() != StatePlaying {
+		t.Fatalf("继续播放: %+v state=%s", tr, a.State())
+	}
+	if got := fx.take(); got != "silence,resume" {
+		t.Fatalf("动作=%q", got)
+	}
+
+	// 歌恰好放完、没暂停上：按停止处理，不留下无法继续的“暂停”
+	c, cfx := newTestAssistant(true, false, musicPlaying)
+	cfx.ended = true
+	c.Dispatch(EvASRResult{Text: "等一下"})
+	if c.Dispatch(EvASRResult{Text: "继续播放"}).Intent == intentResume {
+		t.Fatal("没暂停上的歌不应进入暂停态")
+	}
+
+	// 没有暂停的歌：“继续播放”不当成恢复
+	b, _ := newTestAssistant(true, false, musicOff)
+	if tr := b.Dispatch(EvASRResult{Text: "继续播放"}); tr.Intent == intentResume {
+		t.Fatal("没有暂停的歌时不应恢复")
+	}
+}
+
+func TestAssistantIdleSleep(t *testing.T) {
+	a, fx := newTestAssistant(true, false, musicPlaying)
+	later := time.Now().Add(wakeIdleTimeout + time.Minute)
+	a.Dispatch(EvIdleCheck{Now: later})
+	if !a.Awake() {
+		t.Fatal("放歌时不应进入休眠")
+	}
+	a.Dispatch(EvMusicStopped{})
+	if tr := a.Dispatch(EvIdleCheck{Now: later}); tr.To != StateSleeping {
+		t.Fatalf("空闲超时后 state=%s", tr.To)
+	}
+	if got := fx.take(); got != "clear_memory" {
+		t.Fatalf("休眠应清空对话记忆，动作=%q", got)
+	}
+	if a.Dispatch(EvReplyDone{}); a.State() != StateSleeping {
+		t.Fatalf("state=%s", a.State())
+	}
+}
+
+func TestAssistantLookupsOutsideLock(t *testing.T) {
+	a, fx := newTestAssistant(true, false, musicPlaying)
+	fx.owner = a
+	for _, text := range []string{"上一首", "你好小瑞，播放庙堂之外", "循环播放稻香"} {
+		a.Dispatch(EvASRResult{Text: text})
+	}
+	if fx.locked {
+		t.Fatal("曲库/播放历史查询不应在状态机锁内进行")
+	}
+}
+
+func TestAssistantStaleReplyDone(t *testing.T) {
+	a, fx := newTestAssistant(true, false, musicOff)
+	a.Dispatch(EvASRResult{Text: "讲个笑话"})
+	old := fx.session
+	a.Dispatch(EvASRResult{Text: "讲个故事"})
+	if fx.session == old {
+		t.Fatal("新一轮应开新会话")
+	}
+	// 被打断的上一轮收尾晚到：不能把新一轮的“思考中”清掉
+	if a.Dispatch(EvReplyDone{Session: old}); a.State() != StateThinking {
+		t.Fatalf("旧会话结束后 state=%s，应仍在思考", a.State())
+	}
+	if a.Dispatch(EvReplyDone{Session: fx.session}); a.State() != StateListening {
+		t.Fatalf("本轮结束后 state=%s", a.State())
+	}
+}
+
+func TestAssistantTransitionsAreAtomic(t *testing.T) {
+	a, _ := newTestAssistant(true, false, musicPlaying)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				// 并发的 Unduck 不能让 Duck 报出一个没发生过的迁移
+				if tr := a.Dispatch(EvDuck{}); tr.To != StateDucked || (tr.From != StatePlaying && tr.From != StateDucked) {
+					t.Errorf("Duck 报出的迁移不成立: %+v", tr)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if tr := a.Dispatch(EvUnduck{}); tr.To != StatePlaying || (tr.From != StatePlaying && tr.From != StateDucked) {
+					t.Errorf("Unduck 报出的迁移不成立: %+v", tr)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// ================= 混音器 =================
+// 说明：
+// - 进程内只有一路输出（默认一个常驻 aplay，见 openAudioOutput），TTS/音乐等每段声音都是混音器里的一个源，各自带增益并重采样到 mixerSampleRate；
+// - 源的 Write 在缓冲满时阻塞，由混音器按输出节奏消费，生产者不用再自己按墙钟控速；
+// - Kill 丢掉源里没播的部分立即静音，Close 等缓冲播完再移除；停掉一个源不影响输出设备，也不用等设备重启；
+// - 没有源时照常写静音，设备一直开着；输出写失败（aplay 退出）时每隔 mixerReopenDelay 重开一次，期间按墙钟空转。
+
+const (
+	mixerBlock       = 10 * time.Millisecond // 每次混多长
+	mixerPacedLead   = 60 * time.Millisecond // 非实时输出（丢弃/文件）时领先墙钟的量
+	mixerReopenDelay = 2 * time.Second
+	gainFallTau      = 50 * time.Millisecond  // 压低要快（Duck 听感上立刻生效）
+	gainRiseTau      = 900 * time.Millisecond // 恢复慢一点，避免突然变响
+)
+
+type audioMixer struct {
+	rate int
+	open func() (audioOutput, error)
+
+	mu      sync.Mutex
+	sources []*mixerSource
+	master  float64 // 整体增益（软件音量），每块内线性过渡到 target
+	target  float64
+	wake    chan struct{} // 非实时输出空闲时，有新源加入就唤醒
+}
+
+var (
+	outputMixer     *audioMixer
+	outputMixerOnce sync.Once
+)
+
+// currentMixer 全局混音器，第一次用到时启动
+func currentMixer() *audioMixer {
+	outputMixerOnce.Do(func() {
+		outputMixer = newAudioMixer(mixerSampleRate, openAudioOutput)
+		go outputMixer.run()
+	})
+	return outputMixer
+}
+
+func newAudioMixer(rate int, open func() (audioOutput, error)) *audioMixer {
+	return &audioMixer{rate: rate, open: open, master: 1, target: 1, wake: make(chan struct{}, 1)}
+}
+
+// SetMasterGain 整体增益（0~1），软件音量用
+func (x *audioMixer) SetMasterGain(gain float64) {
+	x.mu.Lock()
+	x.target = math.Max(0, math.Min(1, gain))
+	x.mu.Unlock()
+}
+
+// Open 新建一个源：sampleRate 为写入数据的采样率，ahead 为最多缓冲多长（超过时 Write 阻塞）
+func (x *audioMixer) Open(kind string, sampleRate int, ahead time.Duration) *mixerSource {
+	s := &mixerSource{
+		kind:      kind,
+		resampler: newLinearResampler(sampleRate, x.rate),
+		capacity:  int(int64(ahead) * int64(x.rate) / int64(time.Second)),
+		gain:      1,
+		target:    1,
+		done:      make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	x.mu.Lock()
+	x.sources = append(x.sources, s)
+	x.mu.Unlock()
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+	return s
+}
+
+func (x *audioMixer) remove(s *mixerSource) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, v := range x.sources {
+		if v == s {
+			x.sources = append(x.sources[:i], x.sources[i+1:]...)
+			return
+		}
+	}
+}
+
+func (x *audioMixer) idle() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.sources) == 0
+}
+
+// mixBlock 把所有源的下一块混到 out（PCM16 字节）
+func (x *audioMixer) mixBlock(acc []float64, out []byte) {
+	clear(acc)
+	x.mu.Lock()
+	sources := append([]*mixerSource(nil), x.sources...)
+	from, to := x.master, x.target
+	x.master = to
+	x.mu.Unlock()
+	for _, s := range sources {
+		if s.mixInto(acc, x.rate) {
+			x.remove(s)
+		}
+	}
+	for i, v := range acc {
+		g := from + (to-from)*float64(i)/float64(len(acc))
+		sample := clampInt16(v * g)
+		out[2*i] = byte(sample)
+		out[2*i+1] = byte(uint16(sample) >> 8)
+	}
+}
+
+func (x *audioMixer) run() {
+	frames := int(int64(x.rate) * int64(mixerBlock) / int64(time.Second))
+	acc := make([]float64, frames)
+	buf := make([]byte, frames*2)
+
+	var (
+		out       audioOutput
+		lastOpen  time.Time
+		paceStart time.Time
+		paced     int64 // paceStart 之后混出的样本数
+	)
+	for {
+		if out == nil && time.Since(lastOpen) >= mixerReopenDelay {
+			lastOpen = time.Now()
+			o, err := x.open()
+			if err != nil {
+				log.Printf("❌ [混音] 打开输出失败: %v", err)
+			} else {
+				log.Printf("🔊 [混音] 输出已打开: %s（%d Hz）", audioOutKind(), x.rate)
+				out = o
+			}
+			paceStart, paced = time.Time{}, 0
+		}
+
+		if out == nil || !out.Realtime() {
+			// 非实时输出空闲时不空转，有源加入再重新计时
+			if out != nil && x.idle() {
+				select {
+				case <-x.wake:
+				case <-time.After(time.Second):
+				}
+				paceStart, paced = time.Time{}, 0
+			}
+			if paceStart.IsZero() {
+				paceStart = time.Now()
+			}
+			ahead := time.Duration(paced)*time.Second/time.Duration(x.rate) - time.Since(paceStart)
+			if ahead > mixerPacedLead {
+				time.Sleep(ahead - mixerPacedLead)
+			}
+			paced += int64(frames)
+		}
+
+		x.mixBlock(acc, buf)
+		if out == nil {
+			continue
+		}
+		if _, err := out.Write(buf); err != nil {
+			log.Printf("❌ [混音] 输出中断: %v", err)
+			out.Close()
+			out = nil
+		}
+	}
+}
+
+// mixerSource 混音器里的一路声音，实现 pcmSink
+type mixerSource struct {
+	kind      string
+	resampler *linearResampler
+	capacity  int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []int16 // 已重采样到混音器采样率、还没混出去的样本
+	carry   []byte  // 上次 Write 剩下的半个样本
+	gain    float64 // 当前增益，按 target 平滑逼近
+	target  float64
+	started bool // 已经混出过声音；之前设的增益直接生效，不做过渡
+	closing bool // Close 之后：播完缓冲就移除
+	killed  bool
+	done    chan struct{} // 从混音器移除时关闭
+	tap     *wavFileSink  // 回放模式下另存一份写入的原始 PCM
+}
+
+func (s *mixerSource) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(s.carry) > 0 {
+		p = append(s.carry, p...)
+		s.carry = nil
+	}
+	if len(p)%2 == 1 {
+		s.carry = []byte{p[len(p)-1]}
+		p = p[:len(p)-1]
+	}
+	samples := s.resampler.Process(pcmBytesToInt16(p))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.buf) >= s.capacity && !s.killed && !s.closing {
+		s.cond.Wait()
+	}
+	if s.killed || s.closing {
+		return 0, os.ErrClosed
+	}
+	if s.tap != nil {
+		if _, err := s.tap.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	s.buf = append(s.buf, samples...)
+	return n, nil
+}
+
+// Unplayed 已写入但还没混出去的字节数（按写入时的采样率折算）
+func (s *mixerSource) Unplayed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(int64(len(s.buf))*int64(s.resampler.from)/int64(s.resampler.to)) * 2
+}
+
+// Close 写完收尾，等缓冲里的声音播完
+func (s *mixerSource) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	<-s.done
+	return s.closeTap()
+}
+
+// Kill 丢掉没播的部分，立即静音
+func (s *mixerSource) Kill() {
+	s.mu.Lock()
+	s.killed = true
+	s.buf = nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.closeTap()
+}
+
+// SetGain 设置这一路的增益（0~1），平滑过渡：压低快、恢复慢
+func (s *mixerSource) SetGain(gain float64) {
+	s.mu.Lock()
+	s.target = math.Max(0, math.Min(1, gain))
+	if !s.started {
+		s.gain = s.target
+	}
+	s.mu.Unlock()
+}
+
+func (s *mixerSource) closeTap() error {
+	s.mu.Lock()
+	tap := s.tap
+	s.tap = nil
+	s.mu.Unlock()
+	if tap == nil {
+		return nil
+	}
+	return tap.Close()
+}
+
+// mixInto 把下一块叠加到 acc；返回 true 表示这一路已结束，应从混音器移除
+func (s *mixerSource) mixInto(acc []float64, rate int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.killed || (s.closing && len(s.buf) == 0) {
+		select {
+		case <-s.done:
+		default:
+			close(s.done)
+		}
+		return true
+	}
+
+	from := s.gain
+	if s.target != s.gain {
+		tau := gainRiseTau
+		if s.target < s.gain {
+			tau = gainFallTau
+		}
+		dt := float64(len(acc)) / float64(rate)
+		s.gain += (s.target - s.gain) * (1 - math.Exp(-dt/tau.Seconds()))
+	}
+	n := min(len(s.buf), len(acc))
+	if n > 0 {
+		s.started = true
+	}
+	for i := 0; i < n; i++ {
+		g := from + (s.gain-from)*float64(i)/float64(len(acc))
+		acc[i] += float64(s.buf[i]) * g
+	}
+	s.buf = append(s.buf[:0], s.buf[n:]...)
+	s.cond.Broadcast()
+	return false
+}
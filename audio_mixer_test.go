@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureOutput 记下混音器写出的全部样本（非实时，由混音器按墙钟控速）
+type captureOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *captureOutput) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *captureOutput) Close() error   { return nil }
+func (c *captureOutput) Realtime() bool { return false }
+
+func (c *captureOutput) samples() []int16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return pcmBytesToInt16(c.buf.Bytes())
+}
+
+func constantPCM(v int16, n int) []byte {
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = v
+	}
+	return pcmInt16ToBytes(s)
+}
+
+func TestAudioMixer(t *testing.T) {
+	x := newAudioMixer(8000, nil)
+
+	// 两路同时放：16k 的源重采样到 8k，另一路按 0.5 的增益叠加。
+	// 先把两路都写满再直接驱动混音，不依赖两个写协程在墙钟上刚好重叠
+	a := x.Open("a", 16000, 300*time.Millisecond)
+	b := x.Open("b", 8000, 300*time.Millisecond)
+	b.SetGain(0.5)
+	a.Write(constantPCM(1000, 3200)) // 200ms
+	b.Write(constantPCM(2000, 800))  // 100ms
+	closed := make(chan struct{})
+	go func() {
+		a.Close()
+		b.Close()
+		close(closed)
+	}()
+	acc := make([]float64, 80)
+	block := make([]byte, 160)
+	var out []int16
+	// Close 要等缓冲播完才返回，所以一直混到它返回为止
+	deadline := time.Now().Add(5 * time.Second)
+	for drained := false; !drained; {
+		select {
+		case <-closed:
+			drained = true
+		default:
+			if time.Now().After(deadline) {
+				t.Fatal("Close 一直没等到缓冲播完")
+			}
+			x.mixBlock(acc, block)
+			out = append(out, pcmBytesToInt16(block)...)
+		}
+	}
+	if !x.idle() {
+		t.Fatal("Close 之后源应从混音器移除")
+	}
+	var mixed, single int
+	for _, v := range out {
+		switch {
+		case v >= 1990 && v <= 2010:
+			mixed++
+		case v >= 990 && v <= 1010:
+			single++
+		}
+	}
+	if mixed < 700 || single < 700 {
+		t.Fatalf("混音结果不对: mixed=%d single=%d", mixed, single)
+	}
+
+	// Kill：没播的部分直接丢掉，阻塞中的 Write 返回错误
+	capture := &captureOutput{}
+	x = newAudioMixer(8000, func() (audioOutput, error) { return capture, nil })
+	go x.run()
+	k := x.Open("k", 8000, 50*time.Millisecond)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := k.Write(constantPCM(3000, 8000)) // 1 秒，远超缓冲
+		if err == nil {
+			_, err = k.Write(constantPCM(3000, 8000))
+		}
+		errCh <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	k.Kill()
+	if err := <-errCh; err == nil {
+		t.Fatal("Kill 后写入应失败")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !x.idle() || time.Since(start) > 500*time.Millisecond {
+		t.Fatal("Kill 应立即移除这一路")
+	}
+}
+
+func TestMixerSourceUnplayed(t *testing.T) {
+	x := newAudioMixer(16000, nil)
+	s := x.Open("tts", 8000, time.Second)
+	s.Write(constantPCM(1000, 1600)) // 0.2s，按写入的 8kHz 折算
+	if got := s.Unplayed(); got < 3190 || got > 3200 {
+		t.Fatalf("未播字节=%d，应约为 3200", got)
+	}
+	x.mixBlock(make([]float64, 1600), make([]byte, 3200)) // 混出 0.1s
+	if got := s.Unplayed(); got < 1590 || got > 1600 {
+		t.Fatalf("混出一半后未播字节=%d，应约为 1600", got)
+	}
+}
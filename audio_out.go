@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================= 音频输出 =================
+// 说明：
+// - 所有声音（TTS 播报 / 音乐）都是混音器（audio_mixer.go）的一个源，混好后写到唯一一个输出设备；
+// - 输出设备由 AI_BOX_AUDIO_OUT 选择：alsa（常驻 aplay）| wav（写文件）| null（丢弃）| stdout（裸 PCM 写到标准输出）；
+// - 配置 AI_BOX_REPLAY_OUTPUT_DIR 后，每个源写入的原始 PCM 另存为 <kind>-<序号>.wav（回放/测试用），此时默认不出声。
+
+// pcmSink 一路 PCM16 单声道输出
+type pcmSink interface {
+	io.Writer
+	// Close 写完收尾，等待播放结束
+	Close() error
+	// Kill 立即中止（打断用）
+	Kill()
+	// SetGain 这一路的音量（0~1），平滑过渡
+	SetGain(gain float64)
+}
+
+// audioOutput 混音器的输出设备，写入 mixerSampleRate 的 PCM16 单声道
+type audioOutput interface {
+	io.WriteCloser
+	// Realtime 写入是否按设备播放节奏阻塞；否则由混音器按墙钟控速
+	Realtime() bool
+}
+
+var sinkSeq atomic.Int64
+
+// openPCMSink 在混音器里打开一路输出；ahead 为最多缓冲多长，缓冲满时 Write 阻塞
+func openPCMSink(kind string, sampleRate int, ahead time.Duration) (pcmSink, error) {
+	s := currentMixer().Open(kind, sampleRate, ahead)
+	if replayOutputDir != "" {
+		name := fmt.Sprintf("%s-%03d.wav", kind, sinkSeq.Add(1))
+		tap, err := newWAVFileSink(filepath.Join(replayOutputDir, name), sampleRate)
+		if err != nil {
+			s.Kill()
+			return nil, err
+		}
+		s.tap = tap
+	}
+	return s, nil
+}
+
+// audioOutKind 实际使用的输出：没配置 AI_BOX_AUDIO_OUT 时，设置了回放输出目录就不出声，否则 alsa
+func audioOutKind() string {
+	if audioOut != "" {
+		return audioOut
+	}
+	if replayOutputDir != "" {
+		return "null"
+	}
+	return "alsa"
+}
+
+// openAudioOutput 按 AI_BOX_AUDIO_OUT 打开混音器的输出设备
+func openAudioOutput() (audioOutput, error) {
+	switch kind := audioOutKind(); kind {
+	case "alsa":
+		return newAplayOutput(aplayDevice, mixerSampleRate, mixerBufferUs)
+	case "wav":
+		sink, err := newWAVFileSink(audioOutPath, mixerSampleRate)
+		if err != nil {
+			return nil, err
+		}
+		return wavOutput{sink}, nil
+	case "null":
+		return nullOutput{}, nil
+	case "stdout":
+		return stdoutOutput{}, nil
+	default:
+		return nil, fmt.Errorf("未知的输出设备 %q（可选 alsa/wav/null/stdout）", kind)
+	}
+}
+
+// ---------- alsa：常驻 aplay ----------
+
+type aplayOutput struct {
+	cmd *exec.Cmd
+	w   *os.File
+}
+
+func newAplayOutput(device string, sampleRate, bufferUs int) (*aplayOutput, error) {
+	cmd := exec.Command("aplay", "-D", device, "-q", "-t", "raw", "-r", strconv.Itoa(sampleRate), "-f", "S16_LE", "-c", "1", "-B", strconv.Itoa(bufferUs))
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	// 管道默认 64KB（22050Hz 下约 1.5 秒），会让 Duck/打断滞后；尽量缩小，由 aplay 的缓冲抗抖动
+	setPipeSize(w, 4096)
+	cmd.Stdin = r
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	r.Close()
+	return &aplayOutput{cmd: cmd, w: w}, nil
+}
+
+func (o *aplayOutput) Write(p []byte) (int, error) { return o.w.Write(p) }
+func (o *aplayOutput) Realtime() bool              { return true }
+
+func (o *aplayOutput) Close() error {
+	o.w.Close()
+	if o.cmd.Process != nil {
+		o.cmd.Process.Kill()
+	}
+	return o.cmd.Wait()
+}
+
+// ---------- wav / null / stdout ----------
+
+// wavOutput 混音结果写成 WAV；每次写入后回填头部长度，进程被直接杀掉文件也能正常打开
+type wavOutput struct{ *wavFileSink }
+
+func (o wavOutput) Write(p []byte) (int, error) {
+	n, err := o.wavFileSink.Write(p)
+	if err == nil {
+		err = o.wavFileSink.updateHeader()
+	}
+	return n, err
+}
+
+func (wavOutput) Realtime() bool { return false }
+
+type nullOutput struct{}
+
+func (nullOutput) Write(p []byte) (int, error) { return len(p), nil }
+func (nullOutput) Close() error                { return nil }
+func (nullOutput) Realtime() bool              { return false }
+
+// stdoutOutput 裸 PCM 写到标准输出（例如 ai_box | aplay -t raw -r 22050 -f S16_LE -c 1）；
+// 标准输出只放音频，日志和 LLM 流式回显都写标准错误，其他地方不要往标准输出打印
+type stdoutOutput struct{}
+
+func (stdoutOutput) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdoutOutput) Close() error                { return nil }
+func (stdoutOutput) Realtime() bool              { return false }
+
+// wavFileSink 写 PCM16 单声道 WAV，Close 时回填头部长度
+type wavFileSink struct {
+	mu         sync.Mutex
+	f          *os.File
+	sampleRate int
+	n          int64
+}
+
+func newWAVFileSink(path string, sampleRate int) (*wavFileSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &wavFileSink{f: f, sampleRate: sampleRate}
+	if _, err := f.Write(wavHeader(sampleRate, 1, 0)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *wavFileSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := s.f.Write(p)
+	s.n += int64(n)
+	return n, err
+}
+
+// updateHeader 按已写入的长度回填头部
+func (s *wavFileSink) updateHeader() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	_, err := s.f.WriteAt(wavHeader(s.sampleRate, 1, s.n), 0)
+	return err
+}
+
+func (s *wavFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	if _, err := s.f.WriteAt(wavHeader(s.sampleRate, 1, s.n), 0); err != nil {
+		s.f.Close()
+		s.f = nil
+		return err
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *wavFileSink) Kill() { s.Close() }
+
+// wavHeader 生成 44 字节 PCM16 WAV 头
+func wavHeader(sampleRate, channels int, dataLen int64) []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], uint32(36+dataLen))
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1)
+	binary.LittleEndian.PutUint16(h[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(sampleRate*channels*2))
+	binary.LittleEndian.PutUint16(h[32:], uint16(channels*2))
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], uint32(dataLen))
+	return h
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAudioOutputKinds(t *testing.T) {
+	oldOut, oldPath, oldDir := audioOut, audioOutPath, replayOutputDir
+	defer func() { audioOut, audioOutPath, replayOutputDir = oldOut, oldPath, oldDir }()
+
+	audioOut, replayOutputDir = "", ""
+	if audioOutKind() != "alsa" {
+		t.Fatal("默认应走 ALSA")
+	}
+	replayOutputDir = t.TempDir()
+	if audioOutKind() != "null" {
+		t.Fatal("回放输出目录非空时默认不出声")
+	}
+	audioOut = "speaker"
+	if _, err := openAudioOutput(); err == nil {
+		t.Fatal("未知输出应报错")
+	}
+
+	// wav：每次写入都回填头部，不 Close 也能读出已写的内容
+	audioOut = "wav"
+	audioOutPath = filepath.Join(t.TempDir(), "out.wav")
+	out, err := openAudioOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if out.Realtime() {
+		t.Fatal("写文件不是实时输出")
+	}
+	out.Write(pcmInt16ToBytes([]int16{1, 2, 3}))
+	f, err := os.Open(audioOutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rate, channels, samples, err := readWAV(f)
+	if err != nil || rate != mixerSampleRate || channels != 1 || len(samples) != 3 {
+		t.Fatalf("rate=%d channels=%d samples=%v err=%v", rate, channels, samples, err)
+	}
+}
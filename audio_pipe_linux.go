@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// setPipeSize 调整管道缓冲大小（F_SETPIPE_SZ），失败时保持默认
+func setPipeSize(f *os.File, size int) {
+	const fSetPipeSz = 1031
+	syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), fSetPipeSz, uintptr(size))
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// setPipeSize 只有 Linux 能调管道大小，其他平台保持默认
+func setPipeSize(f *os.File, size int) {}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ================= 曲库索引 =================
+// 说明：
+// - 启动时读入磁盘上的索引，后台协程启动时和之后每隔一段时间增量重扫：只对新增/大小或修改时间变化的文件重新读标签，删掉的文件移出索引；
+// - 查询只读当前索引，不等扫描（首次开机曲库很大时，扫完之前新加的歌暂时查不到）；
+// - 索引以 JSON 存在 AI_BOX_HOME 下（AI_BOX_MUSIC_CATALOG 可改路径），曲库目录变了整份作废；
+// - 查询按歌名、歌手、专辑、流派分别打分排序，歌手/专辑命中时把同一类结果整体交给 MusicManager 排队播放；
+// - 汉字对不上时再按拼音模糊匹配（见 music_fuzzy.go），模糊命中且前几名分数接近时交给用户确认。
+
+// musicRescanInterval 后台两次增量重扫的间隔
+const musicRescanInterval = 30 * time.Second
+
+// Track 曲库中的一首歌
+type Track struct {
+	Path     string        `json:"path"`
+	Title    string        `json:"title"`
+	Artist   string        `json:"artist,omitempty"`
+	Album    string        `json:"album,omitempty"`
+	Genre    string        `json:"genre,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Size     int64         `json:"size"`
+	ModTime  int64         `json:"mtime"`
+	// Fingerprint 内容指纹（文件大小 + 开头 64KB 的哈希），改名/挪目录后不变，收藏等按它认歌
+	Fingerprint string `json:"fp,omitempty"`
+}
+
+// TrackMatch 查询结果：Field 为命中的字段（title/artist/album/genre），Fuzzy 表示是按拼音模糊命中的
+type TrackMatch struct {
+	Track
+	Score int
+	Field string
+	Fuzzy bool
+}
+
+// musicAmbiguityMargin 模糊命中时前几名的分差在此之内，视为拿不准该放哪首
+const musicAmbiguityMargin = 2
+
+// musicCatalog 曲库索引，并发安全
+type musicCatalog struct {
+	dir       string
+	indexPath string
+
+	scanMu sync.Mutex // 同一时间只跑一次扫描；扫描期间不持有 mu，查询不受影响
+	mu     sync.Mutex
+	tracks map[string]*Track // key: 绝对路径；扫描完整体替换，里面的 *Track 不再修改
+}
+
+type catalogFile struct {
+	Dir    string   `json:"dir"`
+	Tracks []*Track `json:"tracks"`
+}
+
+func newMusicCatalog(dir, indexPath string) *musicCatalog {
+	return &musicCatalog{dir: dir, indexPath: indexPath, tracks: map[string]*Track{}}
+}
+
+var (
+	musicLib   *musicCatalog
+	musicLibMu sync.Mutex
+)
+
+// currentCatalog 当前曲库目录对应的索引（目录配置变化时重建并读入磁盘索引）；不扫描，见 catalogScanLoop
+func currentCatalog() *musicCatalog {
+	musicLibMu.Lock()
+	if musicLib == nil || musicLib.dir != musicDir || musicLib.indexPath != musicCatalogPath {
+		musicLib = newMusicCatalog(musicDir, musicCatalogPath)
+		if err := musicLib.Load(); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ [曲库] 读取索引失败: %v", err)
+		}
+	}
+	c := musicLib
+	musicLibMu.Unlock()
+	return c
+}
+
+// catalogScanLoop 后台增量重扫：启动时扫一次，之后每隔 musicRescanInterval 扫一次
+func catalogScanLoop() {
+	for {
+		currentCatalog().Refresh()
+		time.Sleep(musicRescanInterval)
+	}
+}
+
+// Load 读入磁盘索引；曲库目录不一致时丢弃
+func (c *musicCatalog) Load() error {
+	if c.indexPath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(c.indexPath)
+	if err != nil {
+		return err
+	}
+	var file catalogFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return err
+	}
+	if file.Dir != c.dir {
+		return nil
+	}
+	tracks := make(map[string]*Track, len(file.Tracks))
+	for _, t := range file.Tracks {
+		if t != nil && t.Path != "" {
+			tracks[t.Path] = t
+		}
+	}
+	c.mu.Lock()
+	c.tracks = tracks
+	c.mu.Unlock()
+	return nil
+}
+
+// save 调用方持有 c.scanMu
+func (c *musicCatalog) save(tracks []*Track) error {
+	if c.indexPath == "" {
+		return nil
+	}
+	file := catalogFile{Dir: c.dir, Tracks: tracks}
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.indexPath, b)
+}
+
+// Refresh 增量重扫：遍历目录、读标签都在锁外进行，扫完整体替换索引
+func (c *musicCatalog) Refresh() {
+	c.scanMu.Lock()
+	defer c.scanMu.Unlock()
+	c.mu.Lock()
+	prev := c.tracks
+	c.mu.Unlock()
+
+	next := make(map[string]*Track, len(prev))
+	added, updated := 0, 0
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == c.dir {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != c.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isMusicFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		old := prev[path]
+		if old != nil && old.Size == info.Size() && old.ModTime == info.ModTime().UnixNano() && old.Fingerprint != "" {
+			next[path] = old
+			return nil
+		}
+		next[path] = buildTrack(path, info)
+		if old == nil {
+			added++
+		} else {
+			updated++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("⚠️ [曲库] 扫描 %s 失败: %v", c.dir, err)
+		return
+	}
+	removed := 0
+	for path := range prev {
+		if next[path] == nil {
+			removed++
+		}
+	}
+	if added+updated+removed == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.tracks = next
+	sorted := c.sortedLocked()
+	c.mu.Unlock()
+	log.Printf("🎼 [曲库] 共 %d 首（新增 %d，更新 %d，移除 %d）", len(next), added, updated, removed)
+	if err := c.save(sorted); err != nil {
+		log.Printf("⚠️ [曲库] 保存索引失败: %v", err)
+	}
+}
+
+// buildTrack 读标签，缺的字段用文件名补齐
+func buildTrack(path string, info fs.FileInfo) *Track {
+	tags := readTrackTags(path)
+	artist, title := parseTrackFilename(path)
+	t := &Track{
+		Path:     path,
+		Title:    tags.Title,
+		Artist:   tags.Artist,
+		Album:    tags.Album,
+		Genre:    tags.Genre,
+		Duration: tags.Duration,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+
+		Fingerprint: trackFingerprint(path, info.Size()),
+	}
+	if t.Title == "" {
+		t.Title = title
+	}
+	if t.Artist == "" {
+		t.Artist = artist
+	}
+	return t
+}
+
+// trackFingerprint 文件大小 + 开头 64KB 的 SHA-1；读不了时返回空
+func trackFingerprint(path string, size int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha1.New()
+	fmt.Fprintf(h, "%d:", size)
+	if _, err := io.CopyN(h, f, 64*1024); err != nil && err != io.EOF {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (c *musicCatalog) sortedLocked() []*Track {
+	out := make([]*Track, 0, len(c.tracks))
+	for _, t := range c.tracks {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// Tracks 全部曲目（按路径排序）
+func (c *musicCatalog) Tracks() []Track {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sorted := c.sortedLocked()
+	out := make([]Track, len(sorted))
+	for i, t := range sorted {
+		out[i] = *t
+	}
+	return out
+}
+
+// Lookup 按路径取曲目信息
+func (c *musicCatalog) Lookup(path string) (Track, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tracks[path]
+	if !ok {
+		return Track{}, false
+	}
+	return *t, true
+}
+
+// Random 随机一首，尽量避开 exclude
+func (c *musicCatalog) Random(exclude string) (Track, bool) {
+	tracks := c.Tracks()
+	paths := make([]string, len(tracks))
+	for i, t := range tracks {
+		paths[i] = t.Path
+	}
+	path, ok := pickRandomExcluding(paths, exclude)
+	if !ok {
+		return Track{}, false
+	}
+	return c.Lookup(path)
+}
+
+// QueueFrom 以 path 开头、按曲库顺序轮转的整库队列：点一首歌后，播完按播放模式接着放库里的其他歌
+func (c *musicCatalog) QueueFrom(path string) []string {
+	tracks := c.Tracks()
+	start := -1
+	for i, t := range tracks {
+		if t.Path == path {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return []string{path}
+	}
+	out := make([]string, 0, len(tracks))
+	for i := range tracks {
+		out = append(out, tracks[(start+i)%len(tracks)].Path)
+	}
+	return out
+}
+
+// Search 按相关度从高到低返回命中的曲目
+func (c *musicCatalog) Search(query string) []TrackMatch {
+	q := normalizeCatalogText(query)
+	if q == "" {
+		return nil
+	}
+	var out []TrackMatch
+	for _, t := range c.Tracks() {
+		if m, ok := scoreTrack(t, q); ok {
+			out = append(out, m)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// ambiguousMatches 最佳结果是拼音模糊命中、且分数相近的还有别的歌名时，返回这几首候选（最多 3 首）让用户确认；
+// 不含糊时返回 nil
+func ambiguousMatches(matches []TrackMatch) []TrackMatch {
+	if len(matches) < 2 || !matches[0].Fuzzy {
+		return nil
+	}
+	var out []TrackMatch
+	seen := map[string]bool{}
+	for _, m := range matches {
+		if m.Score < matches[0].Score-musicAmbiguityMargin || len(out) == 3 {
+			break
+		}
+		key := normalizeCatalogText(m.Title)
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, m)
+		}
+	}
+	if len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+// QueueFor 查询对应的播放队列：命中歌名只放最佳的一首，命中歌手/专辑/流派时放同一类的全部结果
+func (c *musicCatalog) QueueFor(query string) []TrackMatch {
+	return queueForMatches(c.Search(query))
+}
+
+func queueForMatches(matches []TrackMatch) []TrackMatch {
+	if len(matches) == 0 {
+		return nil
+	}
+	best := matches[0]
+	if best.Field == "title" {
+		return matches[:1]
+	}
+	queue := make([]TrackMatch, 0, len(matches))
+	for _, m := range matches {
+		if m.Field == best.Field {
+			queue = append(queue, m)
+		}
+	}
+	return queue
+}
+
+func normalizeCatalogText(s string) string {
+	return strings.ToLower(normalizeIntentText(s))
+}
+
+// matchScore 单个字段的相关度：完全一致 > 查询包含字段（“周杰伦的歌”）> 字段包含查询 ≈ 拼音模糊命中；base 区分字段权重
+func matchScore(field, q string, base int) (int, bool) {
+	f := normalizeCatalogText(field)
+	if f == "" {
+		return 0, false
+	}
+	switch {
+	case f == q:
+		return base + 30, false
+	case utf8.RuneCountInString(f) >= 2 && strings.Contains(q, f):
+		// 查询里多出来的字越少越相关
+		return base + 20 - min(10, utf8.RuneCountInString(q)-utf8.RuneCountInString(f)), false
+	case strings.Contains(f, q):
+		return base + 10 - min(10, utf8.RuneCountInString(f)-utf8.RuneCountInString(q)), false
+	}
+	if sim, coverage := pinyinSimilarity(f, q); sim >= musicFuzzyThreshold {
+		return base + int(10*sim*coverage), true
+	}
+	return 0, false
+}
+
+func scoreTrack(t Track, q string) (TrackMatch, bool) {
+	m := TrackMatch{Track: t}
+	for _, field := range []struct {
+		name, value string
+		base        int
+	}{
+		{"title", t.Title, 70},
+		{"artist", t.Artist, 50},
+		{"album", t.Album, 40},
+		{"genre", t.Genre, 20},
+	} {
+		if s, fuzzy := matchScore(field.value, q, field.base); s > m.Score {
+			m.Score, m.Field, m.Fuzzy = s, field.name, fuzzy
+		}
+	}
+	if m.Score == 0 {
+		return m, false
+	}
+	// “陈楚生的庙堂之外”：歌名命中且查询里还带着歌手，排到同名歌曲前面
+	if m.Field == "title" && t.Artist != "" {
+		if a := normalizeCatalogText(t.Artist); utf8.RuneCountInString(a) >= 2 && strings.Contains(q, a) {
+			m.Score += 15
+		}
+	}
+	return m, true
+}
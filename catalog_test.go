@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// writeTestFLAC 写一段 8kHz 单声道静音 FLAC，带 Vorbis Comment
+func writeTestFLAC(t *testing.T, path string, seconds int, tags [][2]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	const rate, block = 8000, 4000
+	info := &meta.StreamInfo{BlockSizeMin: block, BlockSizeMax: block, SampleRate: rate, NChannels: 1, BitsPerSample: 16}
+	vc := &meta.Block{Header: meta.Header{Type: meta.TypeVorbisComment, Length: 1}, Body: &meta.VorbisComment{Vendor: "ai_box", Tags: tags}}
+	enc, err := flac.NewEncoder(f, info, vc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < seconds*rate/block; i++ {
+		fr := &frame.Frame{
+			Header:    frame.Header{HasFixedBlockSize: true, BlockSize: block, SampleRate: rate, Channels: frame.ChannelsMono, BitsPerSample: 16},
+			Subframes: []*frame.Subframe{{SubHeader: frame.SubHeader{Pred: frame.PredVerbatim}, Samples: make([]int32, block), NSamples: block}},
+		}
+		if err := enc.WriteFrame(fr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// id3Frame 拼一个 ID3v2.3 文本帧
+func id3Frame(id string, enc byte, text []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	binary.Write(&b, binary.BigEndian, uint32(len(text)+1))
+	b.Write([]byte{0, 0, enc})
+	b.Write(text)
+	return b.Bytes()
+}
+
+func id3Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	n := len(body)
+	return append([]byte{'I', 'D', '3', version, 0, 0, byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}, body...)
+}
+
+func utf16LE(s string) []byte {
+	out := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return out
+}
+
+func TestReadID3v2Tags(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte("魔杰座"))
+	tag := id3Tag(3,
+		id3Frame("TIT2", 1, utf16LE("稻香")),
+		id3Frame("TPE1", 3, []byte("周杰伦")),
+		id3Frame("TALB", 0, gbk),
+		id3Frame("TCON", 0, []byte("(13)Pop")),
+		id3Frame("TLEN", 0, []byte("223000")),
+	)
+	got := readID3v2(bytes.NewReader(tag))
+	want := trackTags{Title: "稻香", Artist: "周杰伦", Album: "魔杰座", Genre: "Pop", Duration: 223 * time.Second}
+	if got != want {
+		t.Fatalf("ID3v2 解析异常: %+v", got)
+	}
+}
+
+func TestParseTrackFilename(t *testing.T) {
+	cases := []struct{ name, artist, title string }{
+		{"陈楚生《庙堂之外》2025-7-17.wav", "陈楚生", "庙堂之外"},
+		{"周杰伦 - 稻香.mp3", "周杰伦", "稻香"},
+		{"夏日游记 (Remastered).flac", "", "夏日游记 (Remastered)"},
+		{"2025-7-17.wav", "", "2025-7-17"},
+	}
+	for _, c := range cases {
+		if artist, title := parseTrackFilename(c.name); artist != c.artist || title != c.title {
+			t.Fatalf("%s: artist=%q title=%q", c.name, artist, title)
+		}
+	}
+}
+
+func TestMusicCatalogSearchAndRescan(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(t.TempDir(), "catalog.json")
+	writeTestWAV(t, filepath.Join(dir, "陈楚生《庙堂之外》2025-7-17.wav"), 16000, 1, make([]int16, 16000))
+	writeTestFLAC(t, filepath.Join(dir, "track01.flac"), 2, [][2]string{{"TITLE", "稻香"}, {"ARTIST", "周杰伦"}, {"ALBUM", "魔杰座"}})
+	os.Mkdir(filepath.Join(dir, "周杰伦"), 0o755)
+	writeTestFLAC(t, filepath.Join(dir, "周杰伦", "track02.flac"), 1, [][2]string{{"title", "晴天"}, {"artist", "周杰伦"}, {"album", "叶惠美"}, {"genre", "流行"}})
+	os.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("jpg"), 0o644)
+
+	c := newMusicCatalog(dir, index)
+	c.Refresh()
+	tracks := c.Tracks()
+	if len(tracks) != 3 {
+		t.Fatalf("应索引 3 首歌，got %+v", tracks)
+	}
+	if tr, _ := c.Lookup(filepath.Join(dir, "track01.flac")); tr.Title != "稻香" || tr.Album != "魔杰座" || tr.Duration != 2*time.Second {
+		t.Fatalf("FLAC 标签/时长异常: %+v", tr)
+	}
+	if tr, _ := c.Lookup(filepath.Join(dir, "陈楚生《庙堂之外》2025-7-17.wav")); tr.Artist != "陈楚生" || tr.Title != "庙堂之外" || tr.Duration != time.Second {
+		t.Fatalf("文件名解析异常: %+v", tr)
+	}
+
+	// 按歌手查：两首都排进队列；按歌名查：只放一首
+	queue := c.QueueFor("周杰伦的歌")
+	if len(queue) != 2 || queue[0].Field != "artist" {
+		t.Fatalf("歌手查询异常: %+v", queue)
+	}
+	if queue = c.QueueFor("晴天"); len(queue) != 1 || queue[0].Title != "晴天" {
+		t.Fatalf("歌名查询异常: %+v", queue)
+	}
+	if queue = c.QueueFor("叶惠美"); len(queue) != 1 || queue[0].Field != "album" {
+		t.Fatalf("专辑查询异常: %+v", queue)
+	}
+	if m := c.Search("陈楚生的庙堂之外"); len(m) == 0 || m[0].Title != "庙堂之外" {
+		t.Fatalf("歌手+歌名查询异常: %+v", m)
+	}
+	if m := c.Search("稻香"); len(m) != 1 {
+		t.Fatalf("不相关的歌不应命中: %+v", m)
+	}
+
+	// 重启后先用磁盘索引；未改动的文件不重新读标签（这里改掉索引里的标题来验证）
+	b, _ := os.ReadFile(index)
+	b = bytes.Replace(b, []byte(`"晴天"`), []byte(`"晴天（索引）"`), 1)
+	os.WriteFile(index, b, 0o644)
+	c = newMusicCatalog(dir, index)
+	if err := c.Load(); err != nil || len(c.Tracks()) != 3 {
+		t.Fatalf("读取索引失败: %v", err)
+	}
+	os.Remove(filepath.Join(dir, "track01.flac"))
+	writeTestWAV(t, filepath.Join(dir, "周杰伦 - 七里香.wav"), 16000, 1, make([]int16, 1600))
+	c.Refresh()
+	titles := map[string]string{}
+	for _, tr := range c.Tracks() {
+		titles[tr.Title] = tr.Artist
+	}
+	if len(titles) != 3 || titles["七里香"] != "周杰伦" || titles["晴天（索引）"] != "周杰伦" || titles["庙堂之外"] != "陈楚生" {
+		t.Fatalf("增量重扫结果异常: %v", titles)
+	}
+
+	var file catalogFile
+	b, _ = os.ReadFile(index)
+	if err := json.Unmarshal(b, &file); err != nil || file.Dir != dir || len(file.Tracks) != 3 {
+		t.Fatalf("索引未落盘: %v %+v", err, file)
+	}
+
+	// 曲库目录换了，旧索引作废
+	c = newMusicCatalog(t.TempDir(), index)
+	if c.Load(); len(c.Tracks()) != 0 {
+		t.Fatal("目录不一致时不应沿用旧索引")
+	}
+}
+
+func TestMusicManagerPlayQueue(t *testing.T) {
+	oldOutDir := replayOutputDir
+	defer func() { replayOutputDir = oldOutDir }()
+	replayOutputDir = t.TempDir()
+
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.wav", "bad.wav", "b.wav"} {
+		p := filepath.Join(dir, name)
+		paths = append(paths, p)
+		if name == "bad.wav" {
+			os.WriteFile(p, []byte("not a wav"), 0o644)
+			continue
+		}
+		writeTestWAV(t, p, 16000, 1, make([]int16, 3200))
+	}
+	m := NewMusicManager()
+	if err := m.PlayQueue(paths); err != nil {
+		t.Fatal(err)
+	}
+	if m.QueueLen() != 2 {
+		t.Fatalf("队列长度=%d", m.QueueLen())
+	}
+	// 第一首播完自动跳过坏文件接着放第二首
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out, _ := filepath.Glob(filepath.Join(replayOutputDir, "music-*.wav"))
+		if len(out) == 2 && !m.IsPlaying() {
+			break
+		}
+		if len(out) > 2 || time.Now().After(deadline) {
+			t.Fatalf("应输出两段音乐，got %v", out)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if m.QueueLen() != 0 {
+		t.Fatalf("播完后队列应为空，got %d", m.QueueLen())
+	}
+}
+
+func TestCatalogLookupDoesNotWaitForScan(t *testing.T) {
+	dir := t.TempDir()
+	writeTestWAV(t, filepath.Join(dir, "陈楚生《庙堂之外》.wav"), 16000, 1, make([]int16, 160))
+	c := newMusicCatalog(dir, "")
+	c.Refresh()
+
+	// 扫描进行中（持有 scanMu）时查询照常返回当前索引
+	c.scanMu.Lock()
+	done := make(chan []TrackMatch)
+	go func() { done <- c.Search("庙堂之外") }()
+	select {
+	case m := <-done:
+		if len(m) != 1 {
+			t.Fatalf("查询结果异常: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("查询不应等待扫描")
+	}
+	c.scanMu.Unlock()
+}
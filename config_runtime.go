@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +27,10 @@ var (
 	llmURL   = LLM_URL
 	asrWsURL = WS_AS_URL
 
+	// LLM 后端：dashscope（原生 SSE）| openai（OpenAI 兼容 /v1/chat/completions）
+	llmProvider = "dashscope"
+	llmAPIKey   string
+
 	// 模型配置
 	llmModelFast   = "qwen-turbo-latest"
 	llmModelSearch = "qwen-max"
@@ -36,8 +41,29 @@ var (
 	asrModel       = "paraformer-realtime-v2"
 	asrSampleRate  = 16000
 
-	// 路径
-	musicDir = MUSIC_DIR
+	// TTS 后端：dashscope | local；fallback=local 时云端建连失败自动改用本地合成
+	ttsProvider           = "dashscope"
+	ttsFallback           = "local"
+	ttsLocalModelDir      = "models/tts"
+	ttsLocalAcousticModel = ""
+	ttsLocalVocoder       = ""
+	ttsLocalDictDir       = ""
+	ttsLocalRuleFsts      = ""
+	ttsLocalSpeakerID     = 0
+	ttsLocalSpeed         = 1.0
+
+	// ASR 后端：dashscope | local；fallback=local 时云端建连失败自动改用本地识别
+	asrProvider        = "dashscope"
+	asrFallback        = "local"
+	asrLocalModelDir   = "models/asr"
+	asrLocalNumThreads = 2
+
+	// 路径：AI_BOX_HOME 下存放运行时生成的数据（曲库索引等）
+	aiBoxHome        = "/userdata/AI_BOX"
+	musicDir         = MUSIC_DIR
+	musicCatalogPath = ""
+	settingsPath     = ""
+	favoritesPath    = ""
 
 	// 录音参数（默认与现有逻辑一致）
 	arecordDevice     = "hw:2,0"
@@ -46,9 +72,63 @@ var (
 	arecordPeriodSize = 256
 	arecordBufferSize = 16384
 
+	// 音频设备：输入 alsa | wav | stdin，输出 alsa | wav | null | stdout；留空时按是否配置了离线回放自动选择
+	audioIn      = ""
+	audioOut     = ""
+	audioOutPath = ""
+	aplayDevice  = "default"
+
+	// 提示音：文件名相对 soundsDir（也可写绝对路径），off 表示关闭；音量 0~100
+	soundsDir   = ""
+	earconFiles = map[earcon]string{
+		earconWake:         "wake.wav",
+		earconEndOfSpeech:  "end_of_speech.wav",
+		earconThinking:     "thinking.wav",
+		earconError:        "error.wav",
+		earconVolumeChange: "volume_change.wav",
+	}
+	earconVolume        = 60
+	earconThinkingDelay = 800 * time.Millisecond
+
+	// 设备音量：backend = auto | amixer | software；语音调节的步长与上下限（0~100）；确认方式 speech | earcon
+	volumeBackend = "auto"
+	volumeCard    = "1"
+	volumeControl = "aw_dev_0_rx_volume,0"
+	volumeStep    = 10
+	volumeMin     = 10
+	volumeMax     = 100
+	volumeConfirm = "speech"
+
+	// 声源方向：排除扇区（度，如 60-120，可多个），方向落在其中的语音段丢弃
+	doaExcludeSectors []doaSector
+
+	// 播放：所有声音在进程内混音后写到一个输出设备；aplay 的 -B 缓冲越大越抗抖动，但 Duck/打断越滞后
+	mixerSampleRate = 22050
+	mixerBufferUs   = 100000
+
 	// 伪唤醒参数
 	wakeIdleTimeout = WAKE_IDLE_TIMEOUT
 	wakeAckText     = WAKE_ACK_TEXT
+	llmErrorText    = LLM_ERROR_TEXT
+
+	// 多轮对话记忆（仅限一次唤醒期间）：最多保留的轮数与总字数，轮数为 0 表示不带上下文
+	chatMaxTurns = 6
+	chatMaxChars = 1200
+
+	// 本地唤醒（sherpa-onnx KWS）：启用且模型加载成功时，休眠态音频不再上云
+	kwsEnable       = true
+	kwsModelDir     = "models"
+	kwsKeywordsFile = ""
+	kwsThreshold    = 0.25
+	kwsScore        = 1.0
+	kwsNumThreads   = 1
+
+	// 离线回放：AI_BOX_REPLAY_INPUT 为 WAV 文件或目录时代替 arecord；输出目录非空时 TTS/音乐各另存一份 WAV（默认不出声）
+	replayInput     = ""
+	replayOutputDir = ""
+	replaySpeed     = 1.0
+	// 事件记录（JSON Lines），- 表示标准输出
+	eventLogPath = ""
 )
 
 func initRuntimeConfig() {
@@ -77,25 +157,96 @@ func initRuntimeConfig() {
 
 	llmModelFast = getEnv("AI_BOX_LLM_MODEL_FAST", llmModelFast)
 	llmModelSearch = getEnv("AI_BOX_LLM_MODEL_SEARCH", llmModelSearch)
+	llmProvider = strings.ToLower(getEnv("AI_BOX_LLM_PROVIDER", llmProvider))
+	// 未单独配置时沿用 DashScope Key；本地 llama.cpp/vLLM 无鉴权时可设为 none
+	llmAPIKey = getEnv("AI_BOX_LLM_API_KEY", dashAPIKey)
+	if strings.EqualFold(llmAPIKey, "none") {
+		llmAPIKey = ""
+	}
 
 	ttsModel = getEnv("AI_BOX_TTS_MODEL", ttsModel)
 	ttsVoice = getEnv("AI_BOX_TTS_VOICE", ttsVoice)
 	ttsSampleRate = getEnvInt("AI_BOX_TTS_SAMPLE_RATE", ttsSampleRate)
 	ttsVolume = getEnvInt("AI_BOX_TTS_VOLUME", ttsVolume)
+	ttsProvider = strings.ToLower(getEnv("AI_BOX_TTS_PROVIDER", ttsProvider))
+	ttsFallback = strings.ToLower(getEnv("AI_BOX_TTS_FALLBACK", ttsFallback))
+	ttsLocalModelDir = getEnv("AI_BOX_TTS_LOCAL_MODEL_DIR", ttsLocalModelDir)
+	ttsLocalAcousticModel = getEnv("AI_BOX_TTS_LOCAL_ACOUSTIC_MODEL", ttsLocalAcousticModel)
+	ttsLocalVocoder = getEnv("AI_BOX_TTS_LOCAL_VOCODER", ttsLocalVocoder)
+	ttsLocalDictDir = getEnv("AI_BOX_TTS_LOCAL_DICT_DIR", ttsLocalDictDir)
+	ttsLocalRuleFsts = getEnv("AI_BOX_TTS_LOCAL_RULE_FSTS", ttsLocalRuleFsts)
+	ttsLocalSpeakerID = getEnvInt("AI_BOX_TTS_LOCAL_SPEAKER_ID", ttsLocalSpeakerID)
+	ttsLocalSpeed = getEnvFloat("AI_BOX_TTS_LOCAL_SPEED", ttsLocalSpeed)
 
 	asrModel = getEnv("AI_BOX_ASR_MODEL", asrModel)
 	asrSampleRate = getEnvInt("AI_BOX_ASR_SAMPLE_RATE", asrSampleRate)
+	asrProvider = strings.ToLower(getEnv("AI_BOX_ASR_PROVIDER", asrProvider))
+	asrFallback = strings.ToLower(getEnv("AI_BOX_ASR_FALLBACK", asrFallback))
+	asrLocalModelDir = getEnv("AI_BOX_ASR_LOCAL_MODEL_DIR", asrLocalModelDir)
+	asrLocalNumThreads = getEnvInt("AI_BOX_ASR_LOCAL_NUM_THREADS", asrLocalNumThreads)
 
+	aiBoxHome = getEnv("AI_BOX_HOME", aiBoxHome)
 	musicDir = getEnv("AI_BOX_MUSIC_DIR", musicDir)
+	musicCatalogPath = getEnv("AI_BOX_MUSIC_CATALOG", filepath.Join(aiBoxHome, "music_catalog.json"))
+	musicFuzzyThreshold = getEnvFloat("AI_BOX_MUSIC_FUZZY_THRESHOLD", musicFuzzyThreshold)
+	settingsPath = getEnv("AI_BOX_SETTINGS", filepath.Join(aiBoxHome, "settings.json"))
+	favoritesPath = getEnv("AI_BOX_FAVORITES", filepath.Join(aiBoxHome, "favorites.json"))
 
 	arecordDevice = getEnv("AI_BOX_ARECORD_DEVICE", arecordDevice)
 	arecordChannels = getEnvInt("AI_BOX_ARECORD_CHANNELS", arecordChannels)
 	arecordRate = getEnvInt("AI_BOX_ARECORD_RATE", arecordRate)
 	arecordPeriodSize = getEnvInt("AI_BOX_ARECORD_PERIOD_SIZE", arecordPeriodSize)
 	arecordBufferSize = getEnvInt("AI_BOX_ARECORD_BUFFER_SIZE", arecordBufferSize)
+	audioIn = strings.ToLower(getEnv("AI_BOX_AUDIO_IN", audioIn))
+	audioOut = strings.ToLower(getEnv("AI_BOX_AUDIO_OUT", audioOut))
+	audioOutPath = getEnv("AI_BOX_AUDIO_OUT_FILE", filepath.Join(aiBoxHome, "audio_out.wav"))
+	aplayDevice = getEnv("AI_BOX_APLAY_DEVICE", aplayDevice)
+	mixerSampleRate = getEnvInt("AI_BOX_MIXER_SAMPLE_RATE", mixerSampleRate)
+	soundsDir = getEnv("AI_BOX_SOUNDS_DIR", filepath.Join(aiBoxHome, "sounds"))
+	for e, name := range earconFiles {
+		earconFiles[e] = getEnv("AI_BOX_EARCON_"+strings.ToUpper(string(e)), name)
+	}
+	earconVolume = getEnvInt("AI_BOX_EARCON_VOLUME", earconVolume)
+	volumeBackend = strings.ToLower(getEnv("AI_BOX_VOLUME_BACKEND", volumeBackend))
+	volumeCard = getEnv("AI_BOX_VOLUME_CARD", volumeCard)
+	volumeControl = getEnv("AI_BOX_VOLUME_CONTROL", volumeControl)
+	volumeStep = getEnvInt("AI_BOX_VOLUME_STEP", volumeStep)
+	volumeMin = getEnvInt("AI_BOX_VOLUME_MIN", volumeMin)
+	volumeMax = getEnvInt("AI_BOX_VOLUME_MAX", volumeMax)
+	volumeConfirm = strings.ToLower(getEnv("AI_BOX_VOLUME_CONFIRM", volumeConfirm))
+	earconThinkingDelay = getEnvDuration("AI_BOX_EARCON_THINKING_DELAY", earconThinkingDelay)
+	mixerBufferUs = getEnvInt("AI_BOX_MIXER_BUFFER_US", mixerBufferUs)
+	if sectors, err := parseDOASectors(getEnv("AI_BOX_DOA_EXCLUDE", "")); err != nil {
+		log.Printf("⚠️ [配置] AI_BOX_DOA_EXCLUDE 无效，不做方向排除: %v", err)
+	} else {
+		doaExcludeSectors = sectors
+	}
 
 	wakeAckText = getEnv("AI_BOX_WAKE_ACK_TEXT", wakeAckText)
+	llmErrorText = getEnv("AI_BOX_LLM_ERROR_TEXT", llmErrorText)
 	wakeIdleTimeout = getEnvDuration("AI_BOX_WAKE_IDLE_TIMEOUT", wakeIdleTimeout)
+	chatMaxTurns = getEnvInt("AI_BOX_CHAT_MAX_TURNS", chatMaxTurns)
+	chatMaxChars = getEnvInt("AI_BOX_CHAT_MAX_CHARS", chatMaxChars)
+
+	kwsEnable = getEnvBool("AI_BOX_KWS_ENABLE", kwsEnable)
+	kwsModelDir = getEnv("AI_BOX_KWS_MODEL_DIR", kwsModelDir)
+	kwsKeywordsFile = getEnv("AI_BOX_KWS_KEYWORDS_FILE", kwsKeywordsFile)
+	kwsThreshold = getEnvFloat("AI_BOX_KWS_THRESHOLD", kwsThreshold)
+	kwsScore = getEnvFloat("AI_BOX_KWS_SCORE", kwsScore)
+	kwsNumThreads = getEnvInt("AI_BOX_KWS_NUM_THREADS", kwsNumThreads)
+
+	replayInput = getEnv("AI_BOX_REPLAY_INPUT", replayInput)
+	replayOutputDir = getEnv("AI_BOX_REPLAY_OUTPUT_DIR", replayOutputDir)
+	replaySpeed = getEnvFloat("AI_BOX_REPLAY_SPEED", replaySpeed)
+	eventLogPath = getEnv("AI_BOX_EVENT_LOG", eventLogPath)
+	if eventLogPath == "-" && audioOutKind() == "stdout" {
+		log.Printf("⚠️ [配置] 音频输出和事件记录都写到了标准输出，会混在一起")
+	}
+	if replayOutputDir != "" {
+		if err := os.MkdirAll(replayOutputDir, 0o755); err != nil {
+			log.Printf("⚠️ [配置] 创建回放输出目录失败: %v", err)
+		}
+	}
 
 	if s := strings.TrimSpace(os.Getenv("AI_BOX_WAKE_WORDS")); s != "" {
 		words := splitList(s)
@@ -104,8 +255,8 @@ func initRuntimeConfig() {
 		}
 	}
 
-	log.Printf("🔧 [配置] LLM(fast=%s search=%s) | ASR(model=%s) | TTS(model=%s voice=%s) | musicDir=%s | wakeIdle=%s",
-		llmModelFast, llmModelSearch, asrModel, ttsModel, ttsVoice, musicDir, wakeIdleTimeout)
+	log.Printf("🔧 [配置] LLM(%s fast=%s search=%s) | ASR(%s model=%s fallback=%s) | TTS(%s model=%s voice=%s fallback=%s) | musicDir=%s | wakeIdle=%s | chat(turns=%d chars=%d) | kws=%v(%s)",
+		llmProvider, llmModelFast, llmModelSearch, asrProvider, asrModel, asrFallback, ttsProvider, ttsModel, ttsVoice, ttsFallback, musicDir, wakeIdleTimeout, chatMaxTurns, chatMaxChars, kwsEnable, kwsModelDir)
 }
 
 func loadEnvFileFromCandidates() (string, error) {
@@ -201,6 +352,30 @@ func getEnvInt(key string, def int) int {
 	return n
 }
 
+func getEnvFloat(key string, def float64) float64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+func getEnvBool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
 func getEnvDuration(key string, def time.Duration) time.Duration {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
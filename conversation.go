@@ -0,0 +1,230 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ================= 多轮对话记忆 =================
+// 说明：
+// - 只在一次唤醒期间保留上下文，wakeIdleMonitor 回到休眠时清空；
+// - 按轮数 + 总字数双重预算裁剪，优先丢弃最早的轮次；
+// - assistant 一侧只记录“真正播出去”的文本：控制标记过滤后的播报内容；
+//   播报中途被打断时，按已播放音频占已合成音频的比例截断，并以“……”标明没说完；
+// - BeginReply 返回本轮的句柄，文本、合成/播放进度和写入都只记到这个句柄上；
+//   被新一轮取代的旧句柄（迟到的 CommitReply、旧播报的进度）直接忽略，不会记到新一轮头上。
+
+type chatTurn struct {
+	User      string
+	Assistant string
+}
+
+// pendingReply 正在进行的一轮回复（LLM 生成 + TTS 播放），也是 BeginReply 返回的句柄
+type pendingReply struct {
+	user        string
+	text        strings.Builder
+	received    int64 // 已合成的 PCM 字节
+	played      int64 // 已写入播放器的 PCM 字节
+	interrupted bool
+	committed   bool // 已写入 turns（播放可能仍在继续）
+	finished    bool // 播放已自然结束
+}
+
+func (p *pendingReply) spoken() string {
+	text := strings.TrimSpace(p.text.String())
+	if !p.interrupted {
+		return text
+	}
+	if p.received <= 0 {
+		return ""
+	}
+	return truncateSpoken(text, float64(p.played)/float64(p.received))
+}
+
+type conversationMemory struct {
+	mu       sync.Mutex
+	maxTurns int
+	maxChars int
+	turns    []chatTurn
+	pending  *pendingReply
+}
+
+func newConversationMemory(maxTurns, maxChars int) *conversationMemory {
+	return &conversationMemory{maxTurns: maxTurns, maxChars: maxChars}
+}
+
+var chatMemory = newConversationMemory(6, 1200)
+
+// Messages 返回历史轮次（不含 system 与本轮 user）
+func (m *conversationMemory) Messages() []ChatMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs := make([]ChatMessage, 0, len(m.turns)*2)
+	for _, t := range m.turns {
+		msgs = append(msgs,
+			ChatMessage{Role: "user", Content: t.User},
+			ChatMessage{Role: "assistant", Content: t.Assistant},
+		)
+	}
+	return msgs
+}
+
+// BeginReply 开始一轮新回复，返回本轮的句柄。
+// 上一轮若已被打断但还没写入（LLM 流程尚未返回），按已播出的部分先写入；仍在播放的上一轮视为已结束
+func (m *conversationMemory) BeginReply(user string) *pendingReply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.pending; p != nil && p.interrupted && !p.committed {
+		m.commitLocked(p)
+	}
+	m.pending = &pendingReply{user: user}
+	return m.pending
+}
+
+// active 句柄是否仍是当前这一轮（锁内调用）
+func (m *conversationMemory) active(r *pendingReply) bool {
+	return r != nil && r == m.pending
+}
+
+// ReplyText 记录送去播报的文本
+func (m *conversationMemory) ReplyText(r *pendingReply, text string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.active(r) || r.interrupted {
+		return
+	}
+	r.text.WriteString(text)
+}
+
+// ReplyAudio 记录本轮已合成的音频字节数
+func (m *conversationMemory) ReplyAudio(r *pendingReply, n int) {
+	m.mu.Lock()
+	if m.active(r) && !r.interrupted {
+		r.received += int64(n)
+	}
+	m.mu.Unlock()
+}
+
+// ReplyPlayed 记录本轮已送入播放器的音频字节数
+func (m *conversationMemory) ReplyPlayed(r *pendingReply, n int) {
+	m.mu.Lock()
+	if m.active(r) && !r.interrupted {
+		r.played += int64(n)
+	}
+	m.mu.Unlock()
+}
+
+// ReplyUnplayed 打断前扣掉播放器里还没播出去的 n 字节：送入播放器只代表进了缓冲，不代表用户听到了
+func (m *conversationMemory) ReplyUnplayed(n int) {
+	m.mu.Lock()
+	if p := m.pending; p != nil && !p.finished && !p.interrupted {
+		p.played = max(0, p.played-int64(n))
+	}
+	m.mu.Unlock()
+}
+
+// PlaybackDone 本轮播报自然结束
+func (m *conversationMemory) PlaybackDone(r *pendingReply) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.active(r) {
+		return
+	}
+	r.finished = true
+	if r.committed {
+		m.pending = nil
+	}
+}
+
+// CommitReply LLM 流程结束时写入本轮；没有任何内容播出时整轮丢弃
+func (m *conversationMemory) CommitReply(r *pendingReply) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.active(r) || r.committed {
+		return
+	}
+	m.commitLocked(r)
+}
+
+func (m *conversationMemory) commitLocked(p *pendingReply) {
+	p.committed = true
+	if spoken := p.spoken(); spoken != "" {
+		m.turns = append(m.turns, chatTurn{User: p.user, Assistant: spoken})
+		m.trim()
+	}
+	if p.finished || p.interrupted {
+		m.pending = nil
+	}
+}
+
+// Interrupt 用户打断：未播完的回复按实际播出的部分截断
+func (m *conversationMemory) Interrupt() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.pending
+	if p == nil || p.finished || p.interrupted {
+		return
+	}
+	p.interrupted = true
+	if !p.committed {
+		// 由 CommitReply 按截断后的文本写入
+		return
+	}
+	m.pending = nil
+	if n := len(m.turns); n > 0 && m.turns[n-1].User == p.user {
+		if spoken := p.spoken(); spoken != "" {
+			m.turns[n-1].Assistant = spoken
+		} else {
+			m.turns = m.turns[:n-1]
+		}
+	}
+}
+
+// Clear 回到休眠时清空上下文
+func (m *conversationMemory) Clear() {
+	m.mu.Lock()
+	m.turns = nil
+	m.pending = nil
+	m.mu.Unlock()
+}
+
+func (m *conversationMemory) trim() {
+	if m.maxTurns <= 0 {
+		m.turns = nil
+		return
+	}
+	if len(m.turns) > m.maxTurns {
+		m.turns = append([]chatTurn(nil), m.turns[len(m.turns)-m.maxTurns:]...)
+	}
+	if m.maxChars <= 0 {
+		return
+	}
+	total := 0
+	for _, t := range m.turns {
+		total += utf8.RuneCountInString(t.User) + utf8.RuneCountInString(t.Assistant)
+	}
+	for len(m.turns) > 1 && total > m.maxChars {
+		total -= utf8.RuneCountInString(m.turns[0].User) + utf8.RuneCountInString(m.turns[0].Assistant)
+		m.turns = m.turns[1:]
+	}
+}
+
+// truncateSpoken 按播放比例截断文本，尽量停在句读处
+func truncateSpoken(text string, ratio float64) string {
+	if ratio >= 1 {
+		return text
+	}
+	runes := []rune(text)
+	cut := int(float64(len(runes)) * ratio)
+	if cut <= 0 {
+		return ""
+	}
+	for i := cut; i > 0; i-- {
+		if strings.ContainsRune("，。！？；,.!?;\n", runes[i-1]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimSpace(string(runes[:cut])) + "……"
+}
@@ -10,6 +10,7 @@ set -eu
 #   2) 写入 ai_box.env（供程序自动读取）
 #   3) 可选：配置/拉起 WiFi
 #   4) 可选：安装自启动（systemd 或 /etc/init.d）
+# - 只覆盖程序、库、模型和 env；AI_BOX_HOME 下的用户数据（settings.json、favorites.json、曲库索引、自己换的提示音）原样保留
 
 log() { echo "[install] $*"; }
 
@@ -107,6 +108,29 @@ if [ -n "$LUXSO_SRC" ]; then
   fi
 fi
 
+# 本地唤醒模型（可选）：AI_BOX_MODELS_SRC 或与二进制同级的 models/
+MODELS_SRC="${AI_BOX_MODELS_SRC:-$SCRIPT_DIR/../models}"
+if [ -d "$MODELS_SRC" ]; then
+  MODELS_DST="$AI_BOX_HOME/models"
+  if [ "$(abs_path "$MODELS_SRC")" = "$(abs_path "$MODELS_DST")" ]; then
+    log "models 已在目标目录，跳过复制：$MODELS_DST"
+  else
+    mkdir -p "$MODELS_DST"
+    cp -r "$MODELS_SRC"/. "$MODELS_DST"/
+  fi
+fi
+
+# 提示音（可选）：AI_BOX_SOUNDS_SRC 或与二进制同级的 sounds/（gen_sound.py 生成）；已有的同名文件不覆盖，方便自己换音效
+SOUNDS_SRC="${AI_BOX_SOUNDS_SRC:-$SCRIPT_DIR/../sounds}"
+SOUNDS_DST="${AI_BOX_SOUNDS_DIR:-$AI_BOX_HOME/sounds}"
+if [ -d "$SOUNDS_SRC" ] && [ "$(abs_path "$SOUNDS_SRC")" != "$(abs_path "$SOUNDS_DST")" ]; then
+  mkdir -p "$SOUNDS_DST"
+  for f in "$SOUNDS_SRC"/*.wav; do
+    [ -f "$f" ] || continue
+    [ -e "$SOUNDS_DST/$(basename "$f")" ] || cp "$f" "$SOUNDS_DST"/
+  done
+fi
+
 # 写入 env（供程序自动读取）
 if [ "$(abs_path "$ENV_FILE")" = "$(abs_path "$ENV_TARGET")" ]; then
   log "配置文件已在目标位置，跳过复制：$ENV_TARGET"
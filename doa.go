@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ai_box/aec"
+)
+
+// ================= 声源方向（DOA） =================
+// 说明：
+// - 多麦阵列下 AEC 每块顺带给出一个到达方向（0~359°），这里把一个语音段内的取值汇总成一个方向（按圆周取中位数，0° 与 359° 视为相邻）；
+// - 方向随语音段结束事件、ASR 结果一起记录；单声道输入（回放、stdin）没有方向信息；
+// - AI_BOX_DOA_EXCLUDE 配置排除扇区（如电视所在的方向）：录音过程中按目前为止的方向估计压住 Duck、识别会话和本地唤醒，
+//   估计移出扇区后再放行；语音段结束时方向仍在扇区内则整段丢弃，不识别。
+
+// doaUnknown 没有方向信息（与 AEC 的约定一致）
+const doaUnknown = aec.NoDOA
+
+// doaSector 顺时针从 From 到 To 的扇区（含两端），From > To 表示跨过 0°
+type doaSector struct {
+	From, To int
+}
+
+func (s doaSector) Contains(angle int) bool {
+	if s.From <= s.To {
+		return angle >= s.From && angle <= s.To
+	}
+	return angle >= s.From || angle <= s.To
+}
+
+func (s doaSector) String() string { return fmt.Sprintf("%d-%d", s.From, s.To) }
+
+// parseDOASectors 解析 “60-120,300-20” 形式的扇区列表
+func parseDOASectors(s string) ([]doaSector, error) {
+	var out []doaSector
+	for _, part := range splitList(s) {
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("扇区格式应为 起始-结束: %q", part)
+		}
+		a, err1 := strconv.Atoi(strings.TrimSpace(from))
+		b, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || a < 0 || a >= 360 || b < 0 || b >= 360 {
+			return nil, fmt.Errorf("扇区角度应为 0~359: %q", part)
+		}
+		out = append(out, doaSector{From: a, To: b})
+	}
+	return out, nil
+}
+
+// doaExcluded 方向是否落在排除扇区内；没有方向信息时不排除
+func doaExcluded(angle int) (doaSector, bool) {
+	if angle == doaUnknown {
+		return doaSector{}, false
+	}
+	for _, s := range doaExcludeSectors {
+		if s.Contains(angle) {
+			return s, true
+		}
+	}
+	return doaSector{}, false
+}
+
+// wakeExcluded 本地唤醒词的方向估计落在排除扇区内（如电视里说出唤醒词）时忽略这次唤醒
+func wakeExcluded(keyword string, doas *doaTracker) bool {
+	doa, sector, excluded := doas.Excluded()
+	if excluded {
+		log.Printf("🧭 [DOA] 唤醒词方向 %d° 在排除扇区 %s 内，忽略", doa, sector)
+		emitEventDOA(EventWake, "excluded", keyword, doa)
+	}
+	return excluded
+}
+
+// doaTracker 收集当前这段语音（从起声到语音段结束）每帧的方向
+type doaTracker struct {
+	samples []int
+}
+
+// Add 记录一帧的方向；超出 0~359 的值（算法未给出方向）忽略
+func (t *doaTracker) Add(angle int) {
+	if angle < 0 || angle >= 360 {
+		return
+	}
+	t.samples = append(t.samples, angle)
+}
+
+func (t *doaTracker) Reset() { t.samples = t.samples[:0] }
+
+// Median 语音段的方向；没有样本时为 doaUnknown
+func (t *doaTracker) Median() int { return circularMedian(t.samples) }
+
+// Excluded 目前为止的方向估计及其是否落在排除扇区内；录音过程中随时可调
+func (t *doaTracker) Excluded() (int, doaSector, bool) {
+	doa := t.Median()
+	sector, excluded := doaExcluded(doa)
+	return doa, sector, excluded
+}
+
+// circularMedian 圆周上的中位数：以平均方向为参考展开到 ±180°，取中位数后再折回 0~359
+func circularMedian(angles []int) int {
+	if len(angles) == 0 {
+		return doaUnknown
+	}
+	var sin, cos float64
+	for _, a := range angles {
+		r := float64(a) * math.Pi / 180
+		sin += math.Sin(r)
+		cos += math.Cos(r)
+	}
+	ref := math.Atan2(sin, cos) * 180 / math.Pi
+	offsets := make([]float64, len(angles))
+	for i, a := range angles {
+		offsets[i] = math.Remainder(float64(a)-ref, 360)
+	}
+	sort.Float64s(offsets)
+	mid := offsets[len(offsets)/2]
+	if len(offsets)%2 == 0 {
+		mid = (offsets[len(offsets)/2-1] + mid) / 2
+	}
+	return (int(math.Round(ref+mid))%360 + 360) % 360
+}
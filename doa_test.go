@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestCircularMedian(t *testing.T) {
+	cases := []struct {
+		angles []int
+		want   int
+	}{
+		{nil, doaUnknown},
+		{[]int{90}, 90},
+		{[]int{80, 90, 100, 95, 200}, 95}, // 偶尔的反射不影响结果
+		{[]int{350, 355, 5, 10, 0}, 0},    // 跨过 0°
+		{[]int{358, 359, 1, 3, 170}, 1},
+		{[]int{270, 280, 290, 286}, 283}, // 偶数个：取中间两个的平均
+	}
+	for _, c := range cases {
+		if got := circularMedian(c.angles); got != c.want {
+			t.Errorf("%v: got %d, want %d", c.angles, got, c.want)
+		}
+	}
+
+	var tr doaTracker
+	for _, a := range []int{-1, 120, 360, 130, 125} {
+		tr.Add(a)
+	}
+	if got := tr.Median(); got != 125 {
+		t.Fatalf("无效方向应忽略: %d", got)
+	}
+	tr.Reset()
+	if got := tr.Median(); got != doaUnknown {
+		t.Fatalf("Reset 后应没有方向: %d", got)
+	}
+}
+
+func TestDOAExclusion(t *testing.T) {
+	old := doaExcludeSectors
+	defer func() { doaExcludeSectors = old }()
+
+	if _, err := parseDOASectors("60-120,abc"); err == nil {
+		t.Fatal("格式错误应报错")
+	}
+	if _, err := parseDOASectors("60-360"); err == nil {
+		t.Fatal("角度超范围应报错")
+	}
+	sectors, err := parseDOASectors("60-120， 330-20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doaExcludeSectors = sectors
+	for angle, want := range map[int]bool{60: true, 90: true, 120: true, 121: false, 200: false, 330: true, 0: true, 20: true, 25: false, doaUnknown: false} {
+		if _, got := doaExcluded(angle); got != want {
+			t.Errorf("%d°: excluded=%v, want %v", angle, got, want)
+		}
+	}
+	if s, _ := doaExcluded(10); s.String() != "330-20" {
+		t.Fatalf("命中的扇区: %s", s)
+	}
+
+	// 录音过程中的估计：起声来自排除扇区时压住唤醒，之后的样本占多数再放行
+	ch := make(chan Event, 4)
+	defer events.Subscribe(ch)()
+	var tr doaTracker
+	for i := 0; i < 3; i++ {
+		tr.Add(90)
+	}
+	if !wakeExcluded("你好小瑞", &tr) {
+		t.Fatal("起声在排除扇区内应忽略唤醒")
+	}
+	if ev := <-ch; ev.Type != EventWake || ev.Detail != "excluded" || ev.DOA == nil || *ev.DOA != 90 {
+		t.Fatalf("应记录被忽略的唤醒: %+v", ev)
+	}
+	for i := 0; i < 5; i++ {
+		tr.Add(200)
+	}
+	if doa, _, held := tr.Excluded(); held || doa != 200 {
+		t.Fatalf("估计移出扇区后应放行: doa=%d held=%v", doa, held)
+	}
+}
+
+func TestEmitDOA(t *testing.T) {
+	ch := make(chan Event, 4)
+	defer events.Subscribe(ch)()
+	emitEventDOA(EventSegmentEnd, "asr", "", 0)
+	emitEventDOA(EventSegmentEnd, "asr", "", doaUnknown)
+	if ev := <-ch; ev.DOA == nil || *ev.DOA != 0 {
+		t.Fatalf("应带上方向: %+v", ev)
+	}
+	if ev := <-ch; ev.DOA != nil {
+		t.Fatalf("没有方向时应省略: %+v", ev)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 提示音 =================
+// 说明：
+// - 本地短提示音，不依赖云端：唤醒、说完话（开始识别）、等 LLM 首字、出错、调音量时各一个；
+// - 文件放在 AI_BOX_HOME/sounds（AI_BOX_SOUNDS_DIR 可改），任意采样率/声道的 WAV，gen_sound.py 可生成一套默认的；
+// - 每个提示音可用 AI_BOX_EARCON_* 换文件或填 off 关闭；文件不存在时等同关闭；
+// - 作为混音器里单独的一路播放，盖在压低的音乐上，不打断播报。
+
+type earcon string
+
+const (
+	earconWake        earcon = "wake"
+	earconEndOfSpeech earcon = "end_of_speech"
+	earconThinking    earcon = "thinking"
+	earconError       earcon = "error"
+	// earconVolumeChange 调音量的确认音（播报中，或 AI_BOX_VOLUME_CONFIRM=earcon 时）
+	earconVolumeChange earcon = "volume_change"
+)
+
+// thinkingEarconInterval 等待首字期间提示音的重复间隔
+const thinkingEarconInterval = 2 * time.Second
+
+type earconClip struct {
+	rate    int
+	samples []int16
+}
+
+var (
+	earconMu    sync.Mutex
+	earconCache = map[string]*earconClip{} // 按文件路径缓存；nil 表示读不到，不再重试
+)
+
+// earconPath 提示音文件路径；关闭时返回空
+func earconPath(e earcon) string {
+	name := earconFiles[e]
+	switch strings.ToLower(name) {
+	case "", "off", "none", "0":
+		return ""
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(soundsDir, name)
+}
+
+func loadEarcon(path string) *earconClip {
+	earconMu.Lock()
+	defer earconMu.Unlock()
+	if clip, ok := earconCache[path]; ok {
+		return clip
+	}
+	var clip *earconClip
+	f, err := os.Open(path)
+	if err == nil {
+		var rate, channels int
+		var samples []int16
+		rate, channels, samples, err = readWAV(f)
+		f.Close()
+		if err == nil {
+			clip = &earconClip{rate: rate, samples: downmixInt16(samples, channels)}
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️ [提示音] 读取 %s 失败: %v", path, err)
+	}
+	earconCache[path] = clip
+	return clip
+}
+
+// playEarcon 播放一个提示音，不等播完；返回的 sink 可用来提前打断（Close 等它播完），没有播放时为 nil
+func playEarcon(e earcon) pcmSink {
+	path := earconPath(e)
+	if path == "" || assistant.Muted() {
+		return nil
+	}
+	clip := loadEarcon(path)
+	if clip == nil || len(clip.samples) == 0 {
+		return nil
+	}
+	sink, err := openPCMSink("earcon", clip.rate, time.Duration(len(clip.samples))*time.Second/time.Duration(clip.rate))
+	if err != nil {
+		log.Printf("⚠️ [提示音] 打开输出失败: %v", err)
+		return nil
+	}
+	sink.SetGain(float64(earconVolume) / 100)
+	emitEvent(EventEarcon, string(e), "")
+	// 缓冲按整段开的，一次写完不会阻塞
+	if _, err := sink.Write(pcmInt16ToBytes(clip.samples)); err != nil {
+		return nil
+	}
+	go sink.Close()
+	return sink
+}
+
+// startThinkingEarcon 等 LLM 首字：超过 earconThinkingDelay 还没出字就开始响，之后每隔一段时间再响一次；
+// 调用返回的函数停止（首字到达、出错或会话取消时）
+func startThinkingEarcon(ctx context.Context) (stop func()) {
+	if earconPath(earconThinking) == "" {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var current pcmSink
+		defer func() {
+			if current != nil {
+				current.Kill()
+			}
+		}()
+		wait := earconThinkingDelay
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			if current != nil {
+				current.Kill()
+			}
+			current = playEarcon(earconThinking)
+			if current == nil {
+				return
+			}
+			wait = thinkingEarconInterval
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEarcons(t *testing.T) {
+	oldDir, oldOut, oldWake, oldThinking, oldDelay := soundsDir, replayOutputDir, earconFiles[earconWake], earconFiles[earconThinking], earconThinkingDelay
+	defer func() {
+		soundsDir, replayOutputDir, earconThinkingDelay = oldDir, oldOut, oldDelay
+		earconFiles[earconWake], earconFiles[earconThinking] = oldWake, oldThinking
+	}()
+	soundsDir, replayOutputDir = t.TempDir(), t.TempDir()
+	earconFiles[earconWake], earconFiles[earconThinking] = "wake.wav", "thinking.wav"
+	clip := make([]int16, 800)
+	for i := range clip {
+		clip[i] = int16(i)
+	}
+	writeTestWAV(t, filepath.Join(soundsDir, "wake.wav"), 16000, 1, clip)
+	writeTestWAV(t, filepath.Join(soundsDir, "thinking.wav"), 16000, 1, clip)
+
+	outputs := func() []string {
+		out, _ := filepath.Glob(filepath.Join(replayOutputDir, "earcon-*.wav"))
+		return out
+	}
+
+	if playEarcon(earconError) != nil {
+		t.Fatal("文件不存在时不应播放")
+	}
+	earconFiles[earconWake] = "off"
+	if playEarcon(earconWake) != nil {
+		t.Fatal("关闭后不应播放")
+	}
+	earconFiles[earconWake] = "wake.wav"
+	sink := playEarcon(earconWake)
+	if sink == nil {
+		t.Fatal("应播放唤醒提示音")
+	}
+	sink.Close()
+	out := outputs()
+	if len(out) != 1 {
+		t.Fatalf("应输出一段提示音: %v", out)
+	}
+	f, err := os.Open(out[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, got, err := readWAV(f)
+	f.Close()
+	if err != nil || len(got) != len(clip) || got[len(got)-1] != clip[len(clip)-1] {
+		t.Fatalf("提示音内容不对: %d err=%v", len(got), err)
+	}
+
+	// 首字在延迟内到达：不响
+	earconThinkingDelay = 200 * time.Millisecond
+	stop := startThinkingEarcon(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	if n := len(outputs()); n != 1 {
+		t.Fatalf("首字及时到达不应响提示音，got %d", n)
+	}
+	// 等得久了才响
+	earconThinkingDelay = 10 * time.Millisecond
+	stop = startThinkingEarcon(context.Background())
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	if n := len(outputs()); n != 2 {
+		t.Fatalf("等首字时应响提示音，got %d", n)
+	}
+}
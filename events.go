@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================= 事件记录 =================
+// 说明：
+// - 关键节点（唤醒、语音段起止、ASR 文本、意图判定、TTS 文本、音乐动作）输出一行 JSON，便于回放对比与自动化测试；
+// - AI_BOX_EVENT_LOG 指定输出文件（- 表示标准输出）；未配置时只在内存里分发给订阅者（测试用）；
+// - 时间 t：回放模式为输入音频的位置（秒），实时模式为启动以来的墙钟秒数。
+
+// 事件类型
+const (
+	EventWake         = "wake"          // detail: kws / asr / excluded（唤醒词来自排除扇区，已忽略）
+	EventSleep        = "sleep"         //
+	EventSegmentStart = "segment_start" //
+	EventSegmentEnd   = "segment_end"   // detail: asr / noise / dropped / excluded，doa: 语音段方向
+	EventASR          = "asr"           // text: 最终识别文本，doa: 语音段方向
+	EventIntent       = "intent"        // detail: 判定结果，text: 参与判定的文本
+	EventTTS          = "tts"           // text: 送去合成的文本
+	EventMusic        = "music"         // detail: play / stop / volume，text: 曲目路径或音量
+	EventTool         = "tool"          // detail: 工具名，text: 参数
+	EventState        = "state"         // detail: 迁移后的状态，text: 触发迁移的事件
+	EventEarcon       = "earcon"        // detail: wake / end_of_speech / thinking / error / volume_change
+	EventVolume       = "volume"        // detail: amixer / software，text: 设备音量
+	EventReplayEnd    = "replay_end"    // 回放输入读完且链路空闲
+)
+
+// Event 一条事件
+type Event struct {
+	T      float64 `json:"t"`
+	Type   string  `json:"type"`
+	Detail string  `json:"detail,omitempty"`
+	Text   string  `json:"text,omitempty"`
+	DOA    *int    `json:"doa,omitempty"` // 声源方向（度），没有方向信息时省略
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	out    *json.Encoder
+	file   *os.File
+	subs   map[int]chan<- Event
+	nextID int
+	start  time.Time
+	// clock 非空时用于计算 t（回放模式按已读入的音频计时）
+	clock atomic.Pointer[func() time.Duration]
+}
+
+var events = &eventLog{start: time.Now(), subs: make(map[int]chan<- Event)}
+
+// Open 打开事件输出文件
+func (l *eventLog) Open(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if path == "-" {
+		l.out = json.NewEncoder(os.Stdout)
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	l.out = json.NewEncoder(f)
+	return nil
+}
+
+// Close 关闭事件输出文件
+func (l *eventLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	l.out = nil
+}
+
+// SetClock 设置事件时间来源；nil 恢复为墙钟
+func (l *eventLog) SetClock(clock func() time.Duration) {
+	if clock == nil {
+		l.clock.Store(nil)
+		return
+	}
+	l.clock.Store(&clock)
+}
+
+// Subscribe 订阅事件（满了直接丢弃，不阻塞主流程），返回取消函数
+func (l *eventLog) Subscribe(ch chan<- Event) func() {
+	l.mu.Lock()
+	id := l.nextID
+	l.nextID++
+	l.subs[id] = ch
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		delete(l.subs, id)
+		l.mu.Unlock()
+	}
+}
+
+func (l *eventLog) Emit(typ, detail, text string) {
+	l.emit(Event{Type: typ, Detail: detail, Text: text})
+}
+
+// EmitDOA 带声源方向的事件；doaUnknown 时与 Emit 相同
+func (l *eventLog) EmitDOA(typ, detail, text string, doa int) {
+	ev := Event{Type: typ, Detail: detail, Text: text}
+	if doa != doaUnknown {
+		ev.DOA = &doa
+	}
+	l.emit(ev)
+}
+
+func (l *eventLog) emit(ev Event) {
+	var t time.Duration
+	if c := l.clock.Load(); c != nil {
+		t = (*c)()
+	} else {
+		t = time.Since(l.start)
+	}
+	ev.T = float64(t.Milliseconds()) / 1000
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out != nil {
+		_ = l.out.Encode(ev)
+	}
+	for _, ch := range l.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func emitEvent(typ, detail, text string) {
+	events.Emit(typ, detail, text)
+}
+
+// emitEventDOA 记录带声源方向的事件
+func emitEventDOA(typ, detail, text string, doa int) {
+	events.EmitDOA(typ, detail, text, doa)
+}
+
+// emitIntent 记录 processASR 的判定结果
+func emitIntent(intent asrIntent, text string) {
+	events.Emit(EventIntent, string(intent), text)
+}
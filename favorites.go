@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 收藏 =================
+// 说明：
+// - 收藏列表存成 JSON，默认在 AI_BOX_HOME/favorites.json（AI_BOX_FAVORITES 可改路径），重装不会覆盖；
+// - 每首按曲库里的内容指纹（Track.Fingerprint）认，文件改名/挪目录后重扫曲库就能找回，同时更新记录里的路径；
+// - 语音：“收藏这首歌”“取消收藏”“播放我喜欢的歌”（打乱顺序播放）“我收藏了哪些歌”。
+
+// favoriteEntry 一首收藏；Path/Title/Artist 是最后一次见到时的信息，文件找不到时用于播报
+type favoriteEntry struct {
+	Key    string `json:"key"`
+	Path   string `json:"path"`
+	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"`
+	Added  int64  `json:"added"`
+}
+
+// favoriteStore 收藏列表，并发安全
+type favoriteStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries []favoriteEntry
+}
+
+var (
+	favoriteLib   *favoriteStore
+	favoriteLibMu sync.Mutex
+)
+
+// currentFavorites 当前配置路径对应的收藏列表（路径变化时重新读取）
+func currentFavorites() *favoriteStore {
+	favoriteLibMu.Lock()
+	defer favoriteLibMu.Unlock()
+	if favoriteLib == nil || favoriteLib.path != favoritesPath {
+		favoriteLib = &favoriteStore{path: favoritesPath}
+		if err := favoriteLib.load(); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ [收藏] 读取 %s 失败: %v", favoritesPath, err)
+		}
+	}
+	return favoriteLib
+}
+
+func (s *favoriteStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &s.entries)
+}
+
+// saveLocked 调用方持有 s.mu
+func (s *favoriteStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// favoriteKey 收藏用的键：优先内容指纹，算不出来时退回路径
+func favoriteKey(t Track) string {
+	if t.Fingerprint != "" {
+		return t.Fingerprint
+	}
+	return "path:" + t.Path
+}
+
+func (s *favoriteStore) indexLocked(key string) int {
+	for i, e := range s.entries {
+		if e.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// trackIndexLocked 找 t 对应的收藏：按指纹找，也认曲库还没算出指纹时按路径存下的记录
+func (s *favoriteStore) trackIndexLocked(t Track) int {
+	if i := s.indexLocked(favoriteKey(t)); i >= 0 {
+		return i
+	}
+	return s.indexLocked("path:" + t.Path)
+}
+
+// upgradeKeyLocked 按路径存的收藏在曲库算出指纹后改用指纹作键；同一首已按指纹收藏过时删掉这条重复的，返回 true 表示删了
+func (s *favoriteStore) upgradeKeyLocked(i int, t Track) (removed bool) {
+	key := favoriteKey(t)
+	if s.entries[i].Key == key || strings.HasPrefix(key, "path:") {
+		return false
+	}
+	if s.indexLocked(key) >= 0 {
+		s.entries = append(s.entries[:i], s.entries[i+1:]...)
+		return true
+	}
+	s.entries[i].Key = key
+	return false
+}
+
+// Add 收藏一首；已经收藏过时返回 false
+func (s *favoriteStore) Add(t Track) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := favoriteKey(t)
+	if i := s.trackIndexLocked(t); i >= 0 {
+		if s.entries[i].Key != key {
+			s.upgradeKeyLocked(i, t)
+			return false, s.saveLocked()
+		}
+		return false, nil
+	}
+	s.entries = append(s.entries, favoriteEntry{Key: key, Path: t.Path, Title: t.Title, Artist: t.Artist, Added: time.Now().Unix()})
+	return true, s.saveLocked()
+}
+
+// Remove 取消收藏；本来就没收藏时返回 false
+func (s *favoriteStore) Remove(t Track) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.trackIndexLocked(t)
+	if i < 0 {
+		return false, nil
+	}
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+	return true, s.saveLocked()
+}
+
+// Entries 全部收藏（按收藏先后）
+func (s *favoriteStore) Entries() []favoriteEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]favoriteEntry(nil), s.entries...)
+}
+
+// Resolve 把收藏对到曲库里现在的曲目；改过名的顺便更新记录的路径，曲库里已经没有的跳过（记录保留，文件放回来还能找到）
+func (s *favoriteStore) Resolve(lib *musicCatalog) []Track {
+	tracks := lib.Tracks()
+	byPath := make(map[string]Track, len(tracks))
+	byFingerprint := make(map[string]Track, len(tracks))
+	for _, t := range tracks {
+		byPath[t.Path] = t
+		if t.Fingerprint != "" {
+			if _, dup := byFingerprint[t.Fingerprint]; !dup {
+				byFingerprint[t.Fingerprint] = t
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Track
+	changed := false
+	for i := 0; i < len(s.entries); i++ {
+		e := s.entries[i]
+		var (
+			t  Track
+			ok bool
+		)
+		if strings.HasPrefix(e.Key, "path:") {
+			if t, ok = byPath[e.Path]; ok {
+				// 收藏时曲库还没算出指纹：现在有了就改用指纹作键，之后改名也能找回
+				if s.upgradeKeyLocked(i, t) {
+					i--
+					changed = true
+					continue
+				}
+				changed = changed || s.entries[i].Key != e.Key
+			}
+		} else {
+			t, ok = byFingerprint[e.Key]
+		}
+		if !ok {
+			continue
+		}
+		if t.Path != e.Path {
+			log.Printf("⭐ [收藏] 《%s》已移动: %s -> %s", t.Title, e.Path, t.Path)
+			s.entries[i].Path = t.Path
+			changed = true
+		}
+		out = append(out, t)
+	}
+	if changed {
+		if err := s.saveLocked(); err != nil {
+			log.Printf("⚠️ [收藏] 保存失败: %v", err)
+		}
+	}
+	return out
+}
+
+// ---------- 语音指令 ----------
+
+// 收藏指令的动作
+const (
+	favoriteAdd    = "add"
+	favoriteRemove = "remove"
+	favoritePlay   = "play"
+	favoriteList   = "list"
+)
+
+// parseFavoriteCommand 识别收藏类指令，返回动作
+func parseFavoriteCommand(text string) (string, bool) {
+	cleaned := normalizeIntentText(text)
+	if cleaned == "" {
+		return "", false
+	}
+	hasAny := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(cleaned, w) {
+				return true
+			}
+		}
+		return false
+	}
+	favorites := hasAny("收藏", "我喜欢的", "喜欢的歌")
+	switch {
+	case favorites && hasAny("哪些", "什么歌", "几首", "列表", "有啥"):
+		return favoriteList, true
+	case favorites && !hasAny("这首") && hasAny("播放", "放", "听", "来点", "来几首"):
+		return favoritePlay, true
+	case hasAny("取消收藏", "不收藏", "移出收藏", "从收藏", "删除收藏", "删掉收藏", "不喜欢这首"):
+		return favoriteRemove, true
+	case hasAny("收藏这首", "收藏一下", "收藏它", "收藏起来", "加入收藏", "加到收藏", "添加到收藏", "喜欢这首", "收藏歌"):
+		return favoriteAdd, true
+	}
+	return "", false
+}
+
+// favoriteTarget 收藏/取消收藏针对的歌：正在放（或暂停）的，没有就是最近放过的
+func favoriteTarget() (Track, bool) {
+	path := musicMgr.CurrentSongPath()
+	if path == "" {
+		path = musicMgr.LastPlayed()
+	}
+	if path == "" {
+		return Track{}, false
+	}
+	return lookupTrack(path), true
+}
+
+// favoriteListText 播报收藏列表，最多念 5 首
+func favoriteListText(tracks []Track) string {
+	if len(tracks) == 0 {
+		return "收藏里还没有歌哦"
+	}
+	names := make([]string, 0, 5)
+	for i, t := range tracks {
+		if i == 5 {
+			break
+		}
+		names = append(names, "《"+t.Title+"》")
+	}
+	text := fmt.Sprintf("你收藏了%d首歌：%s", len(tracks), strings.Join(names, "、"))
+	if len(tracks) > len(names) {
+		text += "等"
+	}
+	return text
+}
+
+// handleFavorite 执行收藏指令并播报
+func handleFavorite(action string) {
+	store := currentFavorites()
+	switch action {
+	case favoriteAdd, favoriteRemove:
+		t, ok := favoriteTarget()
+		if !ok {
+			speakText("现在没有在放歌哦")
+			return
+		}
+		var changed bool
+		var err error
+		if action == favoriteAdd {
+			changed, err = store.Add(t)
+		} else {
+			changed, err = store.Remove(t)
+		}
+		if err != nil {
+			log.Printf("⚠️ [收藏] 保存失败: %v", err)
+		}
+		log.Printf("⭐ [收藏] %s 《%s》 changed=%v", action, t.Title, changed)
+		emitEvent(EventMusic, "favorite_"+action, t.Path)
+		switch {
+		case action == favoriteAdd && changed:
+			speakText("好的，已收藏《" + t.Title + "》")
+		case action == favoriteAdd:
+			speakText("《" + t.Title + "》已经在收藏里了")
+		case changed:
+			speakText("好的，已取消收藏《" + t.Title + "》")
+		default:
+			speakText("《" + t.Title + "》不在收藏里")
+		}
+	case favoriteList:
+		speakText(favoriteListText(store.Resolve(currentCatalog())))
+	case favoritePlay:
+		tracks := store.Resolve(currentCatalog())
+		if len(tracks) == 0 {
+			speakText("收藏里还没有歌哦")
+			return
+		}
+		paths := make([]string, len(tracks))
+		for i, t := range tracks {
+			paths[i] = t.Path
+		}
+		rand.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+		drainTTSDone()
+		speakText(fmt.Sprintf("好的，播放你收藏的%d首歌", len(paths)))
+		waitTTSDone(8 * time.Second)
+		if err := musicMgr.PlayQueue(paths); err != nil {
+			speakMusicError(lookupTrack(paths[0]).Title)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFavoritesSurviveRename(t *testing.T) {
+	oldDir, oldIndex, oldFav := musicDir, musicCatalogPath, favoritesPath
+	defer func() { musicDir, musicCatalogPath, favoritesPath = oldDir, oldIndex, oldFav }()
+	musicDir, musicCatalogPath = t.TempDir(), ""
+	favoritesPath = filepath.Join(t.TempDir(), "favorites.json")
+
+	a := filepath.Join(musicDir, "陈楚生《庙堂之外》.wav")
+	b := filepath.Join(musicDir, "周杰伦 - 晴天.wav")
+	writeTestWAV(t, a, 16000, 1, make([]int16, 160))
+	writeTestWAV(t, b, 16000, 1, []int16{1, 2, 3})
+
+	lib := currentCatalog()
+	lib.Refresh()
+	store := currentFavorites()
+	tr, _ := lib.Lookup(a)
+	if tr.Fingerprint == "" {
+		t.Fatal("曲库应算出内容指纹")
+	}
+	if added, err := store.Add(tr); !added || err != nil {
+		t.Fatalf("收藏失败: %v", err)
+	}
+	if added, _ := store.Add(tr); added {
+		t.Fatal("重复收藏应返回 false")
+	}
+
+	// 改名后重扫曲库，收藏仍然对得上，记录里的路径也跟着更新
+	renamed := filepath.Join(musicDir, "庙堂之外（现场版）.wav")
+	if err := os.Rename(a, renamed); err != nil {
+		t.Fatal(err)
+	}
+	lib.Refresh()
+	favoriteLib = nil // 模拟重启，从磁盘重新读
+	got := currentFavorites().Resolve(lib)
+	if len(got) != 1 || got[0].Path != renamed {
+		t.Fatalf("改名后应能找回收藏: %+v", got)
+	}
+	favoriteLib = nil
+	if e := currentFavorites().Entries(); len(e) != 1 || e[0].Path != renamed || e[0].Title != "庙堂之外" {
+		t.Fatalf("收藏记录应更新路径: %+v", e)
+	}
+
+	tr, _ = lib.Lookup(renamed)
+	if removed, _ := currentFavorites().Remove(tr); !removed || len(currentFavorites().Entries()) != 0 {
+		t.Fatal("取消收藏失败")
+	}
+}
+
+func TestFavoritesAddedBeforeFingerprint(t *testing.T) {
+	oldDir, oldIndex, oldFav := musicDir, musicCatalogPath, favoritesPath
+	defer func() { musicDir, musicCatalogPath, favoritesPath = oldDir, oldIndex, oldFav }()
+	musicDir, musicCatalogPath = t.TempDir(), ""
+	favoritesPath = filepath.Join(t.TempDir(), "favorites.json")
+	favoriteLib = nil
+
+	a := filepath.Join(musicDir, "陈楚生《庙堂之外》.wav")
+	writeTestWAV(t, a, 16000, 1, make([]int16, 160))
+	store := currentFavorites()
+	// 曲库还没扫到这首就收藏了：只能按路径记
+	if added, err := store.Add(Track{Path: a, Title: "庙堂之外"}); !added || err != nil {
+		t.Fatalf("收藏失败: %v", err)
+	}
+
+	lib := currentCatalog()
+	lib.Refresh()
+	tr, _ := lib.Lookup(a)
+	if removed, _ := store.Remove(tr); !removed || len(store.Entries()) != 0 {
+		t.Fatal("按路径收藏的歌算出指纹后应能取消收藏")
+	}
+
+	store.Add(Track{Path: a, Title: "庙堂之外"})
+	if added, _ := store.Add(tr); added {
+		t.Fatal("按路径收藏过的歌算出指纹后不应重复收藏")
+	}
+	if e := store.Entries(); len(e) != 1 || e[0].Key != tr.Fingerprint {
+		t.Fatalf("应改用指纹作键: %+v", e)
+	}
+	if removed, _ := store.Remove(tr); !removed || len(store.Entries()) != 0 {
+		t.Fatal("取消收藏失败")
+	}
+
+	// Resolve 时也顺带改键
+	store.Add(Track{Path: a, Title: "庙堂之外"})
+	if got := store.Resolve(lib); len(got) != 1 || store.Entries()[0].Key != tr.Fingerprint {
+		t.Fatalf("Resolve 应改用指纹作键: %+v %+v", got, store.Entries())
+	}
+	if removed, _ := store.Remove(tr); !removed {
+		t.Fatal("改键后应能取消收藏")
+	}
+}
+
+func TestParseFavoriteCommand(t *testing.T) {
+	cases := map[string]string{
+		"收藏这首歌":     favoriteAdd,
+		"我喜欢这首":     favoriteAdd,
+		"取消收藏":      favoriteRemove,
+		"把这首从收藏里删掉": favoriteRemove,
+		"播放我喜欢的歌":   favoritePlay,
+		"放我收藏的歌":    favoritePlay,
+		"我收藏了哪些歌":   favoriteList,
+		"讲个笑话":      "",
+		"播放庙堂之外":    "",
+	}
+	for text, want := range cases {
+		got, ok := parseFavoriteCommand(text)
+		if got != want || ok != (want != "") {
+			t.Fatalf("%s: got %q ok=%v", text, got, ok)
+		}
+	}
+}
+
+func TestFavoriteListText(t *testing.T) {
+	if got := favoriteListText(nil); got != "收藏里还没有歌哦" {
+		t.Fatalf("got %q", got)
+	}
+	tracks := []Track{{Title: "一"}, {Title: "二"}, {Title: "三"}, {Title: "四"}, {Title: "五"}, {Title: "六"}}
+	if got := favoriteListText(tracks[:2]); got != "你收藏了2首歌：《一》、《二》" {
+		t.Fatalf("got %q", got)
+	}
+	if got := favoriteListText(tracks); got != "你收藏了6首歌：《一》、《二》、《三》、《四》、《五》等" {
+		t.Fatalf("got %q", got)
+	}
+}
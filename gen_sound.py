@@ -1,37 +1,42 @@
+import os
 import wave
 import math
 import struct
 
-# 配置：16000Hz, 单声道, 16bit (完美适配 RK3308 aplay)
-filename = "thinking.wav"
+# 生成一套默认提示音到 sounds/（部署时拷到 AI_BOX_HOME/sounds，已有的同名文件不会被覆盖）
+# 配置：16000Hz, 单声道, 16bit；程序会按需重采样，换成别的 WAV 也可以
+out_dir = "sounds"
 sample_rate = 16000
-duration = 0.3  # 0.3秒
-frequency = 800.0 # 800Hz (清脆的提示音)
 
-print(f"正在生成 {filename} ...")
+# 每个提示音由若干段 (频率Hz, 时长秒) 组成，逐段淡出，听起来像 "叮" 而不是 "哔"
+sounds = {
+    "wake.wav": [(660.0, 0.08), (880.0, 0.12)],          # 唤醒：上扬两声
+    "end_of_speech.wav": [(880.0, 0.08)],                 # 说完话：短促一声
+    "thinking.wav": [(800.0, 0.3)],                       # 等待回答：清脆的叮
+    "error.wav": [(440.0, 0.12), (330.0, 0.2)],           # 出错：下行两声
+    "volume_change.wav": [(1000.0, 0.06)],                # 调音量：很短的一声
+}
 
-with wave.open(filename, 'w') as wav_file:
-    # 设置参数: (nchannels, sampwidth, framerate, nframes, comptype, compname)
-    wav_file.setparams((1, 2, sample_rate, int(sample_rate * duration), 'NONE', 'not compressed'))
 
-    # 生成波形数据
+def tone(frequency, duration):
+    frames = []
     for i in range(int(sample_rate * duration)):
-        # 计算时间点
         t = float(i) / sample_rate
-
-        # 生成正弦波
         sample = math.sin(2 * math.pi * frequency * t)
-
-        # 添加淡出效果 (Decay)，让声音听起来像 "叮" 而不是 "哔"
-        decay = 1.0 - (t / duration)
-        sample *= decay
-
-        # 转换为 16-bit 整数范围 (-32768 to 32767)
-        sample = int(sample * 32767.0)
-
-        # 写入数据 (Little Endian)
-        wav_file.writeframes(struct.pack('<h', sample))
-
-print("✅ 完成！文件已生成: thinking.wav")
-
-
+        # 淡出 (Decay)
+        sample *= 1.0 - (t / duration)
+        frames.append(struct.pack('<h', int(sample * 32767.0 * 0.8)))
+    return b"".join(frames)
+
+
+os.makedirs(out_dir, exist_ok=True)
+for filename, notes in sounds.items():
+    path = os.path.join(out_dir, filename)
+    print(f"正在生成 {path} ...")
+    with wave.open(path, 'w') as wav_file:
+        wav_file.setnchannels(1)
+        wav_file.setsampwidth(2)
+        wav_file.setframerate(sample_rate)
+        wav_file.writeframes(b"".join(tone(f, d) for f, d in notes))
+
+print(f"✅ 完成！提示音已生成到 {out_dir}/")
@@ -5,16 +5,21 @@ go 1.23.12
 replace github.com/maxhawkins/go-webrtc-vad => ./libs/go-webrtc-vad
 
 require (
+	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
+	github.com/hajimehoshi/go-mp3 v0.3.4
 	github.com/k2-fsa/sherpa-onnx-go v1.12.19
 	github.com/maxhawkins/go-webrtc-vad v0.0.0-00010101000000-000000000000
+	github.com/mewkiz/flac v1.0.14
+	github.com/mozillazg/go-pinyin v0.21.0
+	golang.org/x/text v0.21.0
 )
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gordonklaus/portaudio v0.0.0-20250206071425-98a94950218b // indirect
-	github.com/hajimehoshi/go-mp3 v0.3.4 // indirect
+	github.com/icza/bitio v1.1.0 // indirect
 	github.com/k2-fsa/sherpa-onnx-go-linux v1.12.20 // indirect
 	github.com/k2-fsa/sherpa-onnx-go-macos v1.12.20 // indirect
 	github.com/k2-fsa/sherpa-onnx-go-windows v1.12.20 // indirect
+	github.com/mewkiz/pkg v0.0.0-20250417130911-3f050ff8c56d // indirect
+	github.com/mewpkg/term v0.0.0-20241026122259-37a80af23985 // indirect
 )
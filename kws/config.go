@@ -0,0 +1,53 @@
+package kws
+
+import "path/filepath"
+
+// Config 唤醒词模型配置。路径为空时按 ModelDir 下的默认文件名补齐。
+type Config struct {
+	ModelDir     string
+	Encoder      string
+	Decoder      string
+	Joiner       string
+	Tokens       string
+	KeywordsFile string
+
+	SampleRate        int
+	NumThreads        int
+	KeywordsScore     float32
+	KeywordsThreshold float32
+}
+
+func (c Config) withDefaults() Config {
+	dir := c.ModelDir
+	if dir == "" {
+		dir = "models"
+	}
+	if c.Encoder == "" {
+		c.Encoder = filepath.Join(dir, "encoder-epoch-12-avg-2-chunk-16-left-64.int8.onnx")
+	}
+	if c.Decoder == "" {
+		c.Decoder = filepath.Join(dir, "decoder-epoch-12-avg-2-chunk-16-left-64.int8.onnx")
+	}
+	if c.Joiner == "" {
+		c.Joiner = filepath.Join(dir, "joiner-epoch-12-avg-2-chunk-16-left-64.int8.onnx")
+	}
+	if c.Tokens == "" {
+		c.Tokens = filepath.Join(dir, "tokens.txt")
+	}
+	if c.KeywordsFile == "" {
+		c.KeywordsFile = filepath.Join(dir, "keywords.txt")
+	}
+	if c.SampleRate <= 0 {
+		c.SampleRate = 16000
+	}
+	if c.NumThreads <= 0 {
+		c.NumThreads = 1
+	}
+	if c.KeywordsScore <= 0 {
+		c.KeywordsScore = 1.0
+	}
+	if c.KeywordsThreshold <= 0 {
+		c.KeywordsThreshold = 0.25
+	}
+	return c
+}
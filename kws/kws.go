@@ -0,0 +1,98 @@
+//go:build cgo
+
+package kws
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+// Spotter 本地唤醒词检测（sherpa-onnx zipformer KWS）。
+// 输入为 AEC 之后的 16k 单声道 PCM，命中关键词后内部自动 Reset，可直接继续喂数据。
+type Spotter struct {
+	cfg     Config
+	spotter *sherpa.KeywordSpotter
+	stream  *sherpa.OnlineStream
+	buf     []float32
+}
+
+func NewSpotter(cfg Config) (*Spotter, error) {
+	cfg = cfg.withDefaults()
+	for _, p := range []string{cfg.Encoder, cfg.Decoder, cfg.Joiner, cfg.Tokens, cfg.KeywordsFile} {
+		if _, err := os.Stat(p); err != nil {
+			return nil, fmt.Errorf("kws: 模型文件不可用: %w", err)
+		}
+	}
+
+	c := sherpa.KeywordSpotterConfig{}
+	c.FeatConfig.SampleRate = cfg.SampleRate
+	c.FeatConfig.FeatureDim = 80
+	c.ModelConfig.Transducer.Encoder = cfg.Encoder
+	c.ModelConfig.Transducer.Decoder = cfg.Decoder
+	c.ModelConfig.Transducer.Joiner = cfg.Joiner
+	c.ModelConfig.Tokens = cfg.Tokens
+	c.ModelConfig.NumThreads = cfg.NumThreads
+	c.ModelConfig.Provider = "cpu"
+	c.MaxActivePaths = 4
+	c.KeywordsFile = cfg.KeywordsFile
+	c.KeywordsScore = cfg.KeywordsScore
+	c.KeywordsThreshold = cfg.KeywordsThreshold
+
+	spotter := sherpa.NewKeywordSpotter(&c)
+	if spotter == nil {
+		return nil, errors.New("kws: 创建 KeywordSpotter 失败（检查模型与 keywords 文件格式）")
+	}
+	stream := sherpa.NewKeywordStream(spotter)
+	if stream == nil {
+		sherpa.DeleteKeywordSpotter(spotter)
+		return nil, errors.New("kws: 创建 KeywordStream 失败")
+	}
+	return &Spotter{cfg: cfg, spotter: spotter, stream: stream}, nil
+}
+
+// AcceptPCM 喂入一帧 PCM16 并解码；命中时返回关键词（keywords.txt 中 @ 后的显示文本）。
+func (s *Spotter) AcceptPCM(samples []int16) (string, bool) {
+	if s == nil || s.spotter == nil || len(samples) == 0 {
+		return "", false
+	}
+	if cap(s.buf) < len(samples) {
+		s.buf = make([]float32, len(samples))
+	}
+	buf := s.buf[:len(samples)]
+	for i, v := range samples {
+		buf[i] = float32(v) / 32768.0
+	}
+	s.stream.AcceptWaveform(s.cfg.SampleRate, buf)
+
+	hit := ""
+	for s.spotter.IsReady(s.stream) {
+		s.spotter.Decode(s.stream)
+		if kw := s.spotter.GetResult(s.stream).Keyword; kw != "" {
+			hit = kw
+			// 命中后必须立即 Reset，否则后续帧会重复触发
+			s.spotter.Reset(s.stream)
+		}
+	}
+	return hit, hit != ""
+}
+
+// Reset 丢弃已累积的解码状态（例如从唤醒态回到休眠态时）。
+func (s *Spotter) Reset() {
+	if s == nil || s.spotter == nil {
+		return
+	}
+	s.spotter.Reset(s.stream)
+}
+
+func (s *Spotter) Close() {
+	if s == nil || s.spotter == nil {
+		return
+	}
+	sherpa.DeleteOnlineStream(s.stream)
+	sherpa.DeleteKeywordSpotter(s.spotter)
+	s.stream = nil
+	s.spotter = nil
+}
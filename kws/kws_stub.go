@@ -0,0 +1,21 @@
+//go:build !cgo
+
+package kws
+
+import "errors"
+
+// 说明：
+// - 本文件用于未启用 CGO 的环境下编译通过（例如 CGO_ENABLED=0 交叉编译）。
+// - 此时无法加载 sherpa-onnx，调用方应回退到云端伪唤醒。
+
+type Spotter struct{}
+
+func NewSpotter(cfg Config) (*Spotter, error) {
+	return nil, errors.New("kws: 当前构建未启用 cgo，无法使用本地唤醒")
+}
+
+func (s *Spotter) AcceptPCM(samples []int16) (string, bool) { return "", false }
+
+func (s *Spotter) Reset() {}
+
+func (s *Spotter) Close() {}
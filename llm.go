@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ================= LLM 后端 =================
+// 说明：
+// - callAgentStream 只负责断句播报与工具调用循环，请求构造/流式解析/选模型/鉴权都交给 LLMClient；
+// - dashscope：原生 text-generation/generation SSE（output.text + incremental_output）；
+// - openai：任意 OpenAI 兼容的 /v1/chat/completions 流式接口（llama.cpp server、vLLM、DashScope compatible-mode 等）。
+
+// OPENAI_COMPAT_URL 选择 openai 后端且未配置 AI_BOX_LLM_URL 时使用 DashScope 的兼容模式
+const OPENAI_COMPAT_URL = "https://dashscope.aliyuncs.com/compatible-mode/v1/chat/completions"
+
+// ChatMessage 一条对话消息；assistant 发起工具调用时带 ToolCalls，工具结果以 role=tool 回传
+type ChatMessage struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+}
+
+// ChatRequest 一次对话请求；EnableSearch 表示需要时效性信息（联网搜索 + 慢而准的模型）
+type ChatRequest struct {
+	Messages     []ChatMessage
+	Tools        []map[string]interface{}
+	EnableSearch bool
+}
+
+// LLMDeltaFunc 增量文本回调
+type LLMDeltaFunc func(delta string)
+
+// LLMClient 流式对话后端。Stream 阻塞到流结束、出错或 ctx 取消，返回模型发起的工具调用（可能为空）。
+type LLMClient interface {
+	Name() string
+	Stream(ctx context.Context, req ChatRequest, onDelta LLMDeltaFunc) ([]ToolCall, error)
+}
+
+var llmClient LLMClient
+
+// llmModelFor 按是否联网选模型：联网搜索用 Max(准确但慢)，普通闲聊用 Turbo(极快)
+func llmModelFor(enableSearch bool) string {
+	if enableSearch {
+		return llmModelSearch
+	}
+	return llmModelFast
+}
+
+// postSSE 发起流式请求并逐条回调 data: 负载（[DONE] 视为结束）
+func postSSE(ctx context.Context, url, apiKey string, extraHeaders map[string]string, payload interface{}, onData func(data []byte)) error {
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	for k, v := range extraHeaders {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := insecureClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("llm: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return readSSE(ctx, resp.Body, onData)
+}
+
+// readSSE 解析 SSE 流，只关心 data: 行；DashScope 出错时以 event:error 下发错误体
+func readSSE(ctx context.Context, r io.Reader, onData func(data []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	event := ""
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := scanner.Text()
+		if line == "" {
+			event = ""
+			continue
+		}
+		if strings.HasPrefix(line, "event:") {
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return nil
+		}
+		if event == "error" {
+			return fmt.Errorf("llm: 流式返回错误: %s", data)
+		}
+		onData([]byte(data))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return scanner.Err()
+}
+
+// chatDelta 两种接口共用的增量结构：choices[].delta（OpenAI）/ output.choices[].message（DashScope）
+type chatDelta struct {
+	Content   string          `json:"content"`
+	ToolCalls []toolCallDelta `json:"tool_calls"`
+}
+
+// ---------- DashScope 原生 ----------
+
+type dashScopeLLM struct {
+	url    string
+	apiKey string
+}
+
+func (dashScopeLLM) Name() string { return "dashscope" }
+
+func (c dashScopeLLM) Stream(ctx context.Context, req ChatRequest, onDelta LLMDeltaFunc) ([]ToolCall, error) {
+	params := map[string]interface{}{
+		"result_format":      "message",
+		"incremental_output": true,
+		"enable_search":      req.EnableSearch, // 动态开关
+	}
+	if len(req.Tools) > 0 {
+		params["tools"] = req.Tools
+	}
+	payload := map[string]interface{}{
+		"model": llmModelFor(req.EnableSearch),
+		"input": map[string]interface{}{
+			"messages": req.Messages,
+		},
+		"parameters": params,
+	}
+	headers := map[string]string{"X-DashScope-SSE": "enable"}
+	acc := &toolCallAccumulator{}
+	err := postSSE(ctx, c.url, c.apiKey, headers, payload, func(data []byte) {
+		d := parseDashScopeChunk(data)
+		for _, tc := range d.ToolCalls {
+			acc.Add(tc)
+		}
+		if d.Content != "" {
+			onDelta(d.Content)
+		}
+	})
+	return acc.Calls(), err
+}
+
+func parseDashScopeChunk(data []byte) chatDelta {
+	var chunk struct {
+		Output struct {
+			Text    string `json:"text"`
+			Choices []struct {
+				Message chatDelta `json:"message"`
+			} `json:"choices"`
+		} `json:"output"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return chatDelta{}
+	}
+	// result_format=text 时只有 output.text
+	if chunk.Output.Text != "" {
+		return chatDelta{Content: chunk.Output.Text}
+	}
+	if len(chunk.Output.Choices) == 0 {
+		return chatDelta{}
+	}
+	return chunk.Output.Choices[0].Message
+}
+
+// ---------- OpenAI 兼容 ----------
+
+type openAILLM struct {
+	url    string
+	apiKey string
+}
+
+func (openAILLM) Name() string { return "openai" }
+
+func (c openAILLM) Stream(ctx context.Context, req ChatRequest, onDelta LLMDeltaFunc) ([]ToolCall, error) {
+	payload := map[string]interface{}{
+		"model":    llmModelFor(req.EnableSearch),
+		"messages": req.Messages,
+		"stream":   true,
+	}
+	if len(req.Tools) > 0 {
+		payload["tools"] = req.Tools
+	}
+	// enable_search 不是标准字段：DashScope 兼容模式识别，llama.cpp/vLLM 会忽略，因此只在需要时携带
+	if req.EnableSearch {
+		payload["enable_search"] = true
+	}
+	acc := &toolCallAccumulator{}
+	err := postSSE(ctx, c.url, c.apiKey, nil, payload, func(data []byte) {
+		d := parseOpenAIChunk(data)
+		for _, tc := range d.ToolCalls {
+			acc.Add(tc)
+		}
+		if d.Content != "" {
+			onDelta(d.Content)
+		}
+	})
+	return acc.Calls(), err
+}
+
+func parseOpenAIChunk(data []byte) chatDelta {
+	var chunk struct {
+		Choices []struct {
+			Delta chatDelta `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil || len(chunk.Choices) == 0 {
+		return chatDelta{}
+	}
+	return chunk.Choices[0].Delta
+}
+
+func initLLMClient() LLMClient {
+	switch llmProvider {
+	case "openai":
+		url := llmURL
+		if url == LLM_URL {
+			url = OPENAI_COMPAT_URL
+		}
+		return openAILLM{url: url, apiKey: llmAPIKey}
+	case "dashscope", "":
+	default:
+		log.Printf("⚠️ [LLM] 未知 AI_BOX_LLM_PROVIDER=%q，使用 dashscope", llmProvider)
+	}
+	return dashScopeLLM{url: llmURL, apiKey: llmAPIKey}
+}
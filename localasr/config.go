@@ -0,0 +1,46 @@
+package localasr
+
+import "path/filepath"
+
+// Config 本地流式识别模型配置（sherpa-onnx streaming zipformer transducer）。
+// 路径为空时按 ModelDir 下的默认文件名补齐。
+type Config struct {
+	ModelDir string
+	Encoder  string
+	Decoder  string
+	Joiner   string
+	Tokens   string
+
+	SampleRate     int
+	NumThreads     int
+	DecodingMethod string
+}
+
+func (c Config) withDefaults() Config {
+	dir := c.ModelDir
+	if dir == "" {
+		dir = "models/asr"
+	}
+	if c.Encoder == "" {
+		c.Encoder = filepath.Join(dir, "encoder.int8.onnx")
+	}
+	if c.Decoder == "" {
+		c.Decoder = filepath.Join(dir, "decoder.onnx")
+	}
+	if c.Joiner == "" {
+		c.Joiner = filepath.Join(dir, "joiner.int8.onnx")
+	}
+	if c.Tokens == "" {
+		c.Tokens = filepath.Join(dir, "tokens.txt")
+	}
+	if c.SampleRate <= 0 {
+		c.SampleRate = 16000
+	}
+	if c.NumThreads <= 0 {
+		c.NumThreads = 2
+	}
+	if c.DecodingMethod == "" {
+		c.DecodingMethod = "greedy_search"
+	}
+	return c
+}
@@ -0,0 +1,130 @@
+//go:build cgo
+
+package localasr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+// Recognizer 板端离线识别器，断网时兜底使用。
+// OnlineRecognizer 本身可被多个 stream 共享，这里加锁只是为了限制 RK3308 上的并发解码。
+type Recognizer struct {
+	cfg Config
+	mu  sync.Mutex
+	rec *sherpa.OnlineRecognizer
+}
+
+func NewRecognizer(cfg Config) (*Recognizer, error) {
+	cfg = cfg.withDefaults()
+	for _, p := range []string{cfg.Encoder, cfg.Decoder, cfg.Joiner, cfg.Tokens} {
+		if _, err := os.Stat(p); err != nil {
+			return nil, fmt.Errorf("localasr: 模型文件不可用: %w", err)
+		}
+	}
+
+	c := sherpa.OnlineRecognizerConfig{}
+	c.FeatConfig.SampleRate = cfg.SampleRate
+	c.FeatConfig.FeatureDim = 80
+	c.ModelConfig.Transducer.Encoder = cfg.Encoder
+	c.ModelConfig.Transducer.Decoder = cfg.Decoder
+	c.ModelConfig.Transducer.Joiner = cfg.Joiner
+	c.ModelConfig.Tokens = cfg.Tokens
+	c.ModelConfig.NumThreads = cfg.NumThreads
+	c.ModelConfig.Provider = "cpu"
+	c.DecodingMethod = cfg.DecodingMethod
+	c.MaxActivePaths = 4
+
+	rec := sherpa.NewOnlineRecognizer(&c)
+	if rec == nil {
+		return nil, errors.New("localasr: 创建 OnlineRecognizer 失败")
+	}
+	return &Recognizer{cfg: cfg, rec: rec}, nil
+}
+
+// Stream 一次流式识别会话：边说边喂，随时可取中间结果。
+type Stream struct {
+	r      *Recognizer
+	stream *sherpa.OnlineStream
+	buf    []float32
+}
+
+func (r *Recognizer) NewStream() *Stream {
+	if r == nil || r.rec == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Stream{r: r, stream: sherpa.NewOnlineStream(r.rec)}
+}
+
+// Accept 喂入一帧 PCM16 并解码，返回当前（中间）识别文本。
+func (s *Stream) Accept(samples []int16) string {
+	if s == nil || s.stream == nil {
+		return ""
+	}
+	if cap(s.buf) < len(samples) {
+		s.buf = make([]float32, len(samples))
+	}
+	buf := s.buf[:len(samples)]
+	for i, v := range samples {
+		buf[i] = float32(v) / 32768.0
+	}
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.stream.AcceptWaveform(s.r.cfg.SampleRate, buf)
+	for s.r.rec.IsReady(s.stream) {
+		s.r.rec.Decode(s.stream)
+	}
+	return strings.TrimSpace(s.r.rec.GetResult(s.stream).Text)
+}
+
+// Finish 结束输入并返回最终文本。
+func (s *Stream) Finish() string {
+	if s == nil || s.stream == nil {
+		return ""
+	}
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	// 尾部补 0.3s 静音，让 transducer 把最后几个字吐出来
+	s.stream.AcceptWaveform(s.r.cfg.SampleRate, make([]float32, s.r.cfg.SampleRate*3/10))
+	s.stream.InputFinished()
+	for s.r.rec.IsReady(s.stream) {
+		s.r.rec.Decode(s.stream)
+	}
+	return strings.TrimSpace(s.r.rec.GetResult(s.stream).Text)
+}
+
+func (s *Stream) Close() {
+	if s == nil || s.stream == nil {
+		return
+	}
+	sherpa.DeleteOnlineStream(s.stream)
+	s.stream = nil
+}
+
+// Recognize 识别一整段 PCM16 单声道音频，返回文本（可能为空）。
+func (r *Recognizer) Recognize(samples []int16) string {
+	s := r.NewStream()
+	if s == nil || len(samples) == 0 {
+		return ""
+	}
+	defer s.Close()
+	s.Accept(samples)
+	return s.Finish()
+}
+
+func (r *Recognizer) Close() {
+	if r == nil || r.rec == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sherpa.DeleteOnlineRecognizer(r.rec)
+	r.rec = nil
+}
@@ -0,0 +1,27 @@
+//go:build !cgo
+
+package localasr
+
+import "errors"
+
+// 说明：未启用 CGO 时无法加载 sherpa-onnx，调用方应只使用云端 ASR。
+
+type Recognizer struct{}
+
+func NewRecognizer(cfg Config) (*Recognizer, error) {
+	return nil, errors.New("localasr: 当前构建未启用 cgo，无法使用本地识别")
+}
+
+func (r *Recognizer) Recognize(samples []int16) string { return "" }
+
+func (r *Recognizer) NewStream() *Stream { return nil }
+
+func (r *Recognizer) Close() {}
+
+type Stream struct{}
+
+func (s *Stream) Accept(samples []int16) string { return "" }
+
+func (s *Stream) Finish() string { return "" }
+
+func (s *Stream) Close() {}
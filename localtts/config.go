@@ -0,0 +1,50 @@
+package localtts
+
+import "path/filepath"
+
+// Config 本地离线 TTS 模型配置（sherpa-onnx VITS 或 Matcha）。
+// 设置了 AcousticModel 时按 Matcha 加载（需同时提供 Vocoder），否则按 VITS 加载 Model。
+// 路径为空时按 ModelDir 下的默认文件名补齐。
+type Config struct {
+	ModelDir string
+
+	// VITS
+	Model string
+
+	// Matcha
+	AcousticModel string
+	Vocoder       string
+
+	Lexicon  string
+	Tokens   string
+	DataDir  string
+	DictDir  string
+	RuleFsts string
+
+	NumThreads int
+	SpeakerID  int
+	Speed      float32
+}
+
+func (c Config) withDefaults() Config {
+	dir := c.ModelDir
+	if dir == "" {
+		dir = "models/tts"
+	}
+	if c.AcousticModel == "" && c.Model == "" {
+		c.Model = filepath.Join(dir, "model.onnx")
+	}
+	if c.Lexicon == "" {
+		c.Lexicon = filepath.Join(dir, "lexicon.txt")
+	}
+	if c.Tokens == "" {
+		c.Tokens = filepath.Join(dir, "tokens.txt")
+	}
+	if c.NumThreads <= 0 {
+		c.NumThreads = 2
+	}
+	if c.Speed <= 0 {
+		c.Speed = 1.0
+	}
+	return c
+}
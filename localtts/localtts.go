@@ -0,0 +1,103 @@
+//go:build cgo
+
+package localtts
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+// Synthesizer 板端离线语音合成，云端 TTS 不可用时兜底播报（唤醒应答、报错、播放确认等短句）。
+type Synthesizer struct {
+	cfg Config
+	mu  sync.Mutex
+	tts *sherpa.OfflineTts
+}
+
+func NewSynthesizer(cfg Config) (*Synthesizer, error) {
+	cfg = cfg.withDefaults()
+	required := []string{cfg.Tokens}
+	if cfg.AcousticModel != "" {
+		required = append(required, cfg.AcousticModel, cfg.Vocoder)
+	} else {
+		required = append(required, cfg.Model)
+	}
+	for _, p := range required {
+		if _, err := os.Stat(p); err != nil {
+			return nil, fmt.Errorf("localtts: 模型文件不可用: %w", err)
+		}
+	}
+	// lexicon 对部分模型可选（例如纯字符建模），不存在时不传
+	if _, err := os.Stat(cfg.Lexicon); err != nil {
+		cfg.Lexicon = ""
+	}
+
+	c := sherpa.OfflineTtsConfig{}
+	if cfg.AcousticModel != "" {
+		c.Model.Matcha.AcousticModel = cfg.AcousticModel
+		c.Model.Matcha.Vocoder = cfg.Vocoder
+		c.Model.Matcha.Lexicon = cfg.Lexicon
+		c.Model.Matcha.Tokens = cfg.Tokens
+		c.Model.Matcha.DataDir = cfg.DataDir
+		c.Model.Matcha.DictDir = cfg.DictDir
+		c.Model.Matcha.NoiseScale = 0.667
+		c.Model.Matcha.LengthScale = 1.0
+	} else {
+		c.Model.Vits.Model = cfg.Model
+		c.Model.Vits.Lexicon = cfg.Lexicon
+		c.Model.Vits.Tokens = cfg.Tokens
+		c.Model.Vits.DataDir = cfg.DataDir
+		c.Model.Vits.DictDir = cfg.DictDir
+		c.Model.Vits.NoiseScale = 0.667
+		c.Model.Vits.NoiseScaleW = 0.8
+		c.Model.Vits.LengthScale = 1.0
+	}
+	c.Model.NumThreads = cfg.NumThreads
+	c.Model.Provider = "cpu"
+	c.RuleFsts = cfg.RuleFsts
+	c.MaxNumSentences = 1
+
+	tts := sherpa.NewOfflineTts(&c)
+	if tts == nil {
+		return nil, errors.New("localtts: 创建 OfflineTts 失败")
+	}
+	return &Synthesizer{cfg: cfg, tts: tts}, nil
+}
+
+// Generate 合成一段文本，返回 PCM16 单声道与其采样率。
+func (s *Synthesizer) Generate(text string) ([]int16, int) {
+	if s == nil || s.tts == nil || text == "" {
+		return nil, 0
+	}
+	s.mu.Lock()
+	audio := s.tts.Generate(text, s.cfg.SpeakerID, s.cfg.Speed)
+	s.mu.Unlock()
+	if audio == nil || len(audio.Samples) == 0 {
+		return nil, 0
+	}
+	out := make([]int16, len(audio.Samples))
+	for i, v := range audio.Samples {
+		f := v * 32767
+		if f > 32767 {
+			f = 32767
+		} else if f < -32768 {
+			f = -32768
+		}
+		out[i] = int16(f)
+	}
+	return out, audio.SampleRate
+}
+
+func (s *Synthesizer) Close() {
+	if s == nil || s.tts == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sherpa.DeleteOfflineTts(s.tts)
+	s.tts = nil
+}
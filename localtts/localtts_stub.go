@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package localtts
+
+import "errors"
+
+// 说明：未启用 CGO 时无法加载 sherpa-onnx，调用方应只使用云端 TTS。
+
+type Synthesizer struct{}
+
+func NewSynthesizer(cfg Config) (*Synthesizer, error) {
+	return nil, errors.New("localtts: 当前构建未启用 cgo，无法使用本地合成")
+}
+
+func (s *Synthesizer) Generate(text string) ([]int16, int) { return nil, 0 }
+
+func (s *Synthesizer) Close() {}
@@ -1,22 +1,17 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -26,11 +21,10 @@ import (
 	"time"
 	"unicode"
 
-	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	vado "github.com/maxhawkins/go-webrtc-vad"
 
 	"ai_box/aec"
+	"ai_box/kws"
 )
 
 // ================= 1. 常量配置 =================
@@ -48,9 +42,17 @@ const MUSIC_DIR = "/userdata/AI_BOX/music"
 // - “伪唤醒”指：仍使用云端 ASR 做文本识别，但在业务层加一层门控状态机；
 // - 休眠态只响应唤醒词，其余任何指令（包含 EXIT/INTERRUPT）都忽略；
 // - 唤醒后进入 AWAKE 态，超过一定时间无交互且无播放占用时回到休眠态。
+// - 若本地 KWS（sherpa-onnx）可用，则休眠态改由板端检测唤醒词，休眠时音频不再上传云端；
+// - KWS 不可用（未启用/模型缺失/未开 cgo）时回退到上述云端伪唤醒。
 const WAKE_IDLE_TIMEOUT = 90 * time.Second
 const WAKE_ACK_TEXT = "我在"
 
+// 云端对话失败时的兜底播报
+const LLM_ERROR_TEXT = "网络好像不太好，请稍后再试"
+
+// 一轮对话中工具调用最多往返几次，防止模型反复调用
+const MAX_TOOL_ROUNDS = 3
+
 // ================= 2. 双级打断词库 =================
 var EXIT_WORDS = []string{
 	"关闭系统", "关机", "退出程序", "再见", "退下",
@@ -62,41 +64,47 @@ var INTERRUPT_WORDS = []string{
 	"别唱了", "等一下", "不要说了",
 }
 
+// RESUME_WORDS 继续播放被打断词暂停的音乐
+var RESUME_WORDS = []string{
+	"继续播放", "继续放", "接着放", "接着播", "恢复播放", "继续听", "接着听", "继续唱", "接着唱",
+}
+
 // ================= 2.5 云端伪唤醒词库 =================
 // 注意：这里放一些常见同音/误识别变体，尽量提高“唤醒命中率”。
 var WAKE_WORDS = []string{
 	"你好小瑞", "你好小睿", "你好晓瑞", "你好小蕊",
 }
 
-// ================= 3. 并发控制与状态变量 =================
-var (
-	sessionCtx    context.Context
-	sessionCancel context.CancelFunc
-	ctxMutex      sync.Mutex
+// ttsMessage 送去合成的一段文本（"[[END]]" 表示本段播报结束）；reply 为所属的那轮 LLM 回复，其它播报为 nil
+type ttsMessage struct {
+	text  string
+	reply *pendingReply
+}
 
-	currentSessionID string
-	sessionIDMutex   sync.Mutex
+// ttsAudio 合成好的一段音频，pcm 为空表示本段播报结束；reply 同 ttsMessage
+type ttsAudio struct {
+	pcm   []byte
+	reply *pendingReply
+}
 
+// ================= 3. 并发控制与状态变量 =================
+// 唤醒/会话/占用状态见 assistant.go
+var (
 	insecureClient *http.Client
 
-	ttsManagerChan chan string
-	audioPcmChan   chan []byte
+	ttsManagerChan chan ttsMessage
+	audioPcmChan   chan ttsAudio
 	ttsDoneChan    chan struct{}
-	ttsConn        *websocket.Conn
-	ttsConnMu      sync.Mutex
-	ttsMuted       atomic.Bool
 
-	playerStdin io.WriteCloser
-	playerCmd   *exec.Cmd
+	playerSink  pcmSink // TTS 播报输出；非空表示正在播报
 	playerMutex sync.Mutex
 
 	emojiRegex *regexp.Regexp
 	musicPunct = regexp.MustCompile(`[，。！？,.!?\s；;：:“”"'《》()（）【】\[\]、]`)
 	musicMgr   *MusicManager
 
-	// 云端伪唤醒状态：默认休眠，命中唤醒词后进入唤醒态
-	awakeFlag          atomic.Bool
-	lastActiveUnixNano atomic.Int64
+	// 本地唤醒词检测器；为 nil 表示回退到云端伪唤醒
+	kwsSpotter *kws.Spotter
 )
 
 // ================= 4. 性能监控辅助变量 =================
@@ -125,23 +133,30 @@ func main() {
 
 	// 一键部署配置加载（环境变量优先，其次读取 env 文件）
 	initRuntimeConfig()
+	chatMemory = newConversationMemory(chatMaxTurns, chatMaxChars)
+	if eventLogPath != "" {
+		if err := events.Open(eventLogPath); err != nil {
+			log.Printf("⚠️ [事件] 打开事件记录失败: %v", err)
+		}
+	}
 
-	ttsManagerChan = make(chan string, 500)
-	audioPcmChan = make(chan []byte, 4000)
-	ttsDoneChan = make(chan struct{}, 10)
-
-	sessionCtx, sessionCancel = context.WithCancel(context.Background())
-	currentSessionID = uuid.New().String()
-
-	musicMgr = NewMusicManager()
-
-	awakeFlag.Store(false)
-	lastActiveUnixNano.Store(0)
 	log.Println("😴 [伪唤醒] 初始为休眠态，仅响应唤醒词（例如：你好小瑞）")
 
-	go audioPlayer()
-	go ttsManagerLoop()
-	go wakeIdleMonitor()
+	kwsSpotter = initKeywordSpotter()
+	asrEngine = initASRProvider()
+	log.Printf("🔧 [ASR] 识别后端: %s", asrEngine.Name())
+	ttsEngine = initSpeechSynthesizer()
+	log.Printf("🔧 [TTS] 合成后端: %s", ttsEngine.Name())
+	llmClient = initLLMClient()
+	log.Printf("🔧 [LLM] 对话后端: %s", llmClient.Name())
+
+	startPipeline()
+	// 曲库索引：先用磁盘上的结果，后台增量重扫新增/改动的文件
+	if audioInKind() == "wav" {
+		// 离线回放要求结果可复现：先扫完再开始
+		currentCatalog().Refresh()
+	}
+	go catalogScanLoop()
 
 	aecProc := aec.NewProcessor()
 	vadEng, err := vado.New()
@@ -150,50 +165,68 @@ func main() {
 	}
 	vadEng.SetMode(3)
 
-	go audioLoop(aecProc, vadEng)
+	// 离线回放：用 WAV 代替麦克风跑完整链路，结束后退出
+	if audioInKind() == "wav" {
+		src, err := newWAVReplaySource(replayInput, replaySpeed)
+		if err != nil {
+			log.Fatal("❌ [回放] 读取输入失败:", err)
+		}
+		log.Printf("▶️ [回放] 输入=%s 输出=%s 速度=%v", replayInput, replayOutputDir, replaySpeed)
+		runReplay(src, aecProc, vadEng)
+		events.Close()
+		log.Println("✅ [回放] 结束")
+		return
+	}
+
+	src, err := openCaptureSource()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("🎤 麦克风已开启（%s）...", audioInKind())
+	if audioInKind() == "stdin" {
+		// 管道输入读完后等链路处理完再退出
+		audioLoop(src, aecProc, vadEng)
+		waitPipelineIdle(60 * time.Second)
+		events.Close()
+		return
+	}
+	go audioLoop(src, aecProc, vadEng)
 
 	select {}
 }
 
-func cleanText(text string) string { return strings.TrimSpace(emojiRegex.ReplaceAllString(text, "")) }
+// startPipeline 初始化播报/会话状态并启动后台协程（播放器、TTS 管理、空闲休眠）
+func startPipeline() {
+	ttsManagerChan = make(chan ttsMessage, 500)
+	audioPcmChan = make(chan ttsAudio, 4000)
+	ttsDoneChan = make(chan struct{}, 10)
+
+	assistant.NewSession()
+	musicMgr = NewMusicManager()
+	deviceVolume = initVolumeController()
+	log.Printf("🔧 [音量] 音量控制: %s", deviceVolume.Name())
+	applySettings(loadSettings())
 
-// 控制标记过滤器：跨分片丢弃方括号内的指令文本（如 [PLAY:xxx]）
-type controlTagFilter struct {
-	inBracket bool
-	sawTag    bool
+	go audioPlayer()
+	go ttsManagerLoop()
+	go wakeIdleMonitor()
 }
 
-func (f *controlTagFilter) Filter(text string) string {
-	if text == "" {
-		return ""
-	}
-	if f.sawTag {
-		return ""
-	}
-	var out strings.Builder
-	for _, r := range text {
-		if f.sawTag {
-			break
-		}
-		if f.inBracket {
-			if r == ']' {
-				f.inBracket = false
-				f.sawTag = true
-			}
-			continue
-		}
-		if r == '[' {
-			f.inBracket = true
-			continue
+func cleanText(text string) string { return strings.TrimSpace(emojiRegex.ReplaceAllString(text, "")) }
+
+func isExit(text string) bool {
+	cleaned := normalizeIntentText(text)
+	for _, w := range EXIT_WORDS {
+		if strings.Contains(cleaned, w) {
+			return true
 		}
-		out.WriteRune(r)
 	}
-	return out.String()
+	return false
 }
 
-func isExit(text string) bool {
+func isResume(text string) bool {
 	cleaned := normalizeIntentText(text)
-	for _, w := range EXIT_WORDS {
+	for _, w := range RESUME_WORDS {
 		if strings.Contains(cleaned, w) {
 			return true
 		}
@@ -211,10 +244,6 @@ func isInterrupt(text string) bool {
 	return false
 }
 
-func touchActive() {
-	lastActiveUnixNano.Store(time.Now().UnixNano())
-}
-
 func normalizeWakeText(text string) string {
 	// 去掉标点/空白，便于匹配“你好，小瑞”等变体
 	s := strings.ToLower(strings.TrimSpace(text))
@@ -259,62 +288,91 @@ func stripWakeAndGetTail(text string) (tail string, hit bool, pureWake bool) {
 func speakWakeAck() {
 	// 仅唤醒词时不走 LLM，直接云端 TTS 播报一句“我在”
 	flushChannel(ttsManagerChan)
-	ttsManagerChan <- wakeAckText
-	ttsManagerChan <- "[[END]]"
-}
-
-func isPhysicalBusy() bool {
-	playerMutex.Lock()
-	isTtsBusy := playerCmd != nil && playerCmd.Process != nil
-	playerMutex.Unlock()
-	isMusicBusy := false
-	if musicMgr != nil {
-		isMusicBusy = musicMgr.IsPlaying()
-	}
-	return isTtsBusy || isMusicBusy
+	speakText(wakeAckText)
 }
 
 func wakeIdleMonitor() {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if !awakeFlag.Load() {
-			continue
-		}
-		// 正在播报/放歌时不进入休眠，避免“音乐无人能停”的体验
-		if isPhysicalBusy() {
-			continue
-		}
+	for now := range ticker.C {
+		assistant.Dispatch(EvIdleCheck{Now: now})
+	}
+}
 
-		last := time.Unix(0, lastActiveUnixNano.Load())
-		if last.IsZero() {
-			continue
-		}
-		if time.Since(last) <= wakeIdleTimeout {
-			continue
-		}
+func initKeywordSpotter() *kws.Spotter {
+	if !kwsEnable {
+		log.Println("[KWS] 本地唤醒已关闭，使用云端伪唤醒")
+		return nil
+	}
+	sp, err := kws.NewSpotter(kws.Config{
+		ModelDir:          kwsModelDir,
+		KeywordsFile:      kwsKeywordsFile,
+		NumThreads:        kwsNumThreads,
+		KeywordsScore:     float32(kwsScore),
+		KeywordsThreshold: float32(kwsThreshold),
+	})
+	if err != nil {
+		log.Printf("⚠️ [KWS] 本地唤醒初始化失败，回退云端伪唤醒: %v", err)
+		return nil
+	}
+	log.Printf("🔑 [KWS] 本地唤醒已启用（modelDir=%s threshold=%.2f），休眠态音频不再上云", kwsModelDir, kwsThreshold)
+	return sp
+}
 
-		awakeFlag.Store(false)
-		log.Println("😴 [伪唤醒] 长时间无交互，进入休眠态，等待唤醒词...")
+// onLocalWake 本地 KWS 命中：直接进入唤醒态。
+// 当前语音段结束后仍会送 ASR，由 processASR 判断是“纯唤醒”还是“唤醒词+指令”。
+func onLocalWake(keyword string) {
+	if !assistant.Awake() {
+		playEarcon(earconWake)
 	}
+	assistant.Dispatch(EvKeywordSpotted{Keyword: keyword})
 }
 
 // ================= 🎵 音乐管理器 =================
 type MusicManager struct {
-	isPlaying     bool
-	mu            sync.Mutex
-	cmd           *exec.Cmd
-	stdin         io.WriteCloser
-	stopChan      chan struct{}
-	targetVolume  float64
-	currentVolume float64
-	volMutex      sync.Mutex
-	currentPath   string
+	isPlaying    bool
+	paused       bool // 暂停中：输出已关闭，解码器和读取位置保留在播放协程里
+	mu           sync.Mutex
+	sink         pcmSink
+	stopChan     chan struct{}
+	pauseChan    chan struct{}
+	resumeChan   chan pcmSink
+	seekChan     chan seekRequest
+	position     *atomic.Int64 // 当前曲目已送出的样本数，由播放协程更新
+	targetVolume float64       // Duck 系数：1 正常，压低时 0.2
+	volMutex     sync.Mutex
+	volume       int // 用户音量 0~100，与 Duck 叠加
+	currentPath  string
+	queue        *playQueue // 当前播放队列，播完按 mode 自动前进
+	mode         PlayMode
+	lastPlayed   string   // 最近开始播放的曲目，停止后仍保留
+	history      []string // lastPlayed 之前放过的曲目（旧 → 新），见 music_history.go
 }
 
 func NewMusicManager() *MusicManager {
-	return &MusicManager{targetVolume: 1.0, currentVolume: 1.0}
+	return &MusicManager{targetVolume: 1.0, volume: 100, mode: PlaySequential}
+}
+
+// PlayMode 当前播放模式
+func (m *MusicManager) PlayMode() PlayMode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mode
+}
+
+// SetPlayMode 切换播放模式；正在放的队列立即按新模式排序，当前曲目不受影响
+func (m *MusicManager) SetPlayMode(mode PlayMode) PlayMode {
+	mode = validPlayMode(mode)
+	m.mu.Lock()
+	m.mode = mode
+	if m.queue != nil {
+		m.queue.SetMode(mode)
+	}
+	m.mu.Unlock()
+	log.Printf("🔁 [MUSIC] 播放模式: %s", mode.Label())
+	emitEvent(EventMusic, "mode", string(mode))
+	return mode
 }
 
 func (m *MusicManager) IsPlaying() bool {
@@ -323,28 +381,73 @@ func (m *MusicManager) IsPlaying() bool {
 	return m.isPlaying
 }
 
+// IsPaused 是否有暂停中的曲目
+func (m *MusicManager) IsPaused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
 func (m *MusicManager) CurrentSongPath() string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	return m.currentPath
 }
 
+// musicAhead 音乐源在混音器里最多缓冲多长：太短 CPU 抖动时会断续，太长 Duck/切歌跟手变差
+const musicAhead = 150 * time.Millisecond
+
 func (m *MusicManager) setTargetVolume(vol float64) {
 	m.volMutex.Lock()
 	m.targetVolume = vol
 	m.volMutex.Unlock()
+	m.applyGain()
+}
+
+// gain Duck 与用户音量叠加后的增益
+func (m *MusicManager) gain() float64 {
+	m.volMutex.Lock()
+	defer m.volMutex.Unlock()
+	return m.targetVolume * float64(m.volume) / 100
+}
+
+// applyGain 把当前增益设到混音器里这一路上，由混音器平滑过渡
+func (m *MusicManager) applyGain() {
+	m.mu.Lock()
+	sink := m.sink
+	m.mu.Unlock()
+	if sink != nil {
+		sink.SetGain(m.gain())
+	}
+}
+
+// Volume 当前用户音量（0~100）
+func (m *MusicManager) Volume() int {
+	m.volMutex.Lock()
+	defer m.volMutex.Unlock()
+	return m.volume
+}
+
+// SetVolume 设置用户音量并返回裁剪后的值，播放中实时生效
+func (m *MusicManager) SetVolume(level int) int {
+	if level < 0 {
+		level = 0
+	} else if level > 100 {
+		level = 100
+	}
+	m.volMutex.Lock()
+	m.volume = level
+	m.volMutex.Unlock()
+	m.applyGain()
+	log.Printf("🔊 [MUSIC] 音量: %d", level)
+	emitEvent(EventMusic, "volume", strconv.Itoa(level))
+	return level
 }
 
 func (m *MusicManager) Duck() {
 	if m.IsPlaying() {
-		// Duck 要“明显且快速”：先把目标压到 20%，并把当前音量立即拉到一个较低上限，
-		// 避免因为缓慢平滑导致用户听感“没有降音量”。
-		m.volMutex.Lock()
-		m.targetVolume = 0.2
-		if m.currentVolume > 0.35 {
-			m.currentVolume = 0.35
-		}
-		m.volMutex.Unlock()
+		// 压到 20%；混音器里压低的过渡很短，听感上立刻生效
+		m.setTargetVolume(0.2)
 	}
 }
 
@@ -356,182 +459,278 @@ func (m *MusicManager) Unduck() {
 
 func (m *MusicManager) Stop() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	if m.isPlaying {
+	stopped := m.isPlaying || m.paused
+	if stopped {
 		log.Println("🛑 [MUSIC] 停止播放")
 		select {
 		case m.stopChan <- struct{}{}:
 		default:
 		}
-		if m.stdin != nil {
-			m.stdin.Close()
-		}
-		if m.cmd != nil && m.cmd.Process != nil {
-			m.cmd.Process.Kill()
-			m.cmd.Wait()
+		if m.sink != nil {
+			m.sink.Kill()
 		}
 		m.isPlaying = false
+		m.paused = false
 		m.currentPath = ""
+		emitEvent(EventMusic, "stop", "")
+	}
+	m.queue = nil
+	m.mu.Unlock()
+	// 状态机回调放在锁外，避免与 Assistant 的锁交叉
+	if stopped {
+		assistant.Dispatch(EvMusicStopped{})
 	}
 }
 
-func (m *MusicManager) PlayFile(path string) {
-	m.Stop()
-	time.Sleep(200 * time.Millisecond)
-
+// Pause 暂停当前曲目：关掉输出，解码器和读取位置原样保留，Resume 后从原处接着放。没在放歌时返回 false
+func (m *MusicManager) Pause() bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if !m.isPlaying {
+		return false
+	}
+	log.Printf("⏸️ [MUSIC] 暂停: %s", filepath.Base(m.currentPath))
+	m.isPlaying = false
+	m.paused = true
+	select {
+	case m.pauseChan <- struct{}{}:
+	default:
+	}
+	if m.sink != nil {
+		m.sink.Kill()
+	}
+	m.sink = nil
+	emitEvent(EventMusic, "pause", m.currentPath)
+	return true
+}
 
-	file, err := os.Open(path)
+// Resume 继续播放暂停的曲目；没有暂停的曲目或输出打不开时返回 false
+func (m *MusicManager) Resume() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.paused {
+		return false
+	}
+	sink, err := openPCMSink("music", musicSampleRate, musicAhead)
 	if err != nil {
-		return
+		log.Printf("❌ [MUSIC] 恢复播放失败: %v", err)
+		return false
+	}
+	log.Printf("▶️ [MUSIC] 继续播放: %s", filepath.Base(m.currentPath))
+	m.sink = sink
+	m.isPlaying = true
+	m.paused = false
+	m.volMutex.Lock()
+	m.targetVolume = 1.0
+	m.volMutex.Unlock()
+	sink.SetGain(m.gain())
+	m.resumeChan <- sink
+	emitEvent(EventMusic, "resume", m.currentPath)
+	return true
+}
+
+// PlayFile 停掉当前曲目并只播放 path（单曲循环时会重复它）；文件无法解码时返回错误，不会出声
+func (m *MusicManager) PlayFile(path string) error {
+	return m.PlayQueue([]string{path})
+}
+
+// PlayQueue 以 paths 为播放队列、paths[0] 为第一首，按当前模式播放；全部无法解码时返回最后一个错误
+func (m *MusicManager) PlayQueue(paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("播放队列为空")
 	}
+	return m.playFrom(newPlayQueue(paths, m.PlayMode()))
+}
 
-	// -B 是缓冲时间(us)：太小会在 CPU 抖动时 underrun（卡顿），太大会导致 Duck/切歌响应滞后。
-	// 这里取一个折中值，配合下游“前置缓冲”控制，保证不卡顿且仍可及时 Duck。
-	cmd := exec.Command("aplay", "-D", "default", "-q", "-t", "raw", "-r", "16000", "-c", "1", "-f", "S16_LE", "-B", "80000")
-	stdin, err := cmd.StdinPipe()
+// playFrom 从 q 的当前曲目开始放，放不了的跳过；最多把整个队列试一遍，避免列表循环里全是坏文件时空转
+func (m *MusicManager) playFrom(q *playQueue) error {
+	var err error
+	for tries := 0; tries < len(q.items); tries++ {
+		if err = m.play(q.Current(), q); err == nil {
+			return nil
+		}
+		if _, ok := q.Next(false); !ok {
+			break
+		}
+	}
+	return err
+}
+
+// QueueLen 按当前顺序，本轮在当前曲目之后还排着几首
+func (m *MusicManager) QueueLen() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queue.Remaining()
+}
+
+func (m *MusicManager) play(path string, queue *playQueue) error {
+	if m.IsPlaying() || m.IsPaused() {
+		m.Stop()
+	}
+
+	m.mu.Lock()
+	defer func() {
+		m.mu.Unlock()
+		if m.IsPlaying() {
+			assistant.Dispatch(EvMusicStarted{Path: path})
+		}
+	}()
+
+	file, err := openMusicStream(path)
 	if err != nil {
-		file.Close()
-		return
+		log.Printf("❌ [MUSIC] 打开失败: %v", err)
+		emitEvent(EventMusic, "error", err.Error())
+		return err
 	}
-	if err := cmd.Start(); err != nil {
+
+	sink, err := openPCMSink("music", musicSampleRate, musicAhead)
+	if err != nil {
 		file.Close()
-		return
+		return err
 	}
 
-	m.cmd = cmd
-	m.stdin = stdin
+	m.sink = sink
 	m.isPlaying = true
 	m.currentPath = path
+	m.queue = queue
+	m.recordPlayedLocked(path)
 	m.stopChan = make(chan struct{}, 1)
+	m.pauseChan = make(chan struct{}, 1)
+	m.resumeChan = make(chan pcmSink, 1)
+	m.seekChan = make(chan seekRequest, 1)
+	m.position = new(atomic.Int64)
+	m.volMutex.Lock()
 	m.targetVolume = 1.0
-	m.currentVolume = 1.0
+	m.volMutex.Unlock()
+	sink.SetGain(m.gain())
 
 	log.Printf("🎵 [MUSIC] 开始播放: %s", filepath.Base(path))
+	emitEvent(EventMusic, "play", path)
 
-	go func(f *os.File, pipe io.WriteCloser, myCmd *exec.Cmd, stopCh chan struct{}) {
-		defer f.Close()
-		defer pipe.Close()
-		f.Seek(44, 0)
-		// 关键：
-		// - 不能“严格实时”地喂数据（每 20ms sleep 一次），否则在 RK3308 上只要调度抖动就会 underrun（听感卡顿）。
-		// - 也不能一次性喂太快/太多，否则 Duck 的听感会滞后（因为旧音量的音频已经预灌进 aplay/管道）。
-		//
-		// 策略：维护一个小的“前置缓冲”（例如 120~180ms），既抗抖动又保证 Duck 仍然足够跟手。
-		const (
-			musicSampleRate = 16000
-			chunkSamples    = 640 // 40ms：降低调度开销，同时仍有较好音量跟随
-			targetAhead     = 120 * time.Millisecond
-			maxAhead        = 180 * time.Millisecond
-		)
+	go func(f *musicStream, out pcmSink, stopCh, pauseCh chan struct{}, resumeCh chan pcmSink, seekCh chan seekRequest, position *atomic.Int64) {
+		defer func() { f.Close() }()
+		// 混音器缓冲满时 Write 会阻塞，按播放节奏读文件即可，不用自己控速；音量由混音器按这一路的增益处理
+		const chunkSamples = 640 // 40ms
 		buf := make([]byte, chunkSamples*2)
 
-		var (
-			startWall    time.Time
-			wroteSamples int64
-			lastStepAt   time.Time
-		)
+		var wroteSamples int64 // 已送出的样本数，即当前曲目的播放位置
+		// seekTo 在当前曲目内跳转：往后直接丢掉中间的样本，往前则重新打开文件再跳过去。
+		// 跳过结尾不用特殊处理，下一次 Read 读到 EOF 自然按播完处理
+		seekTo := func(req seekRequest) {
+			target := req.target(wroteSamples)
+			if target < wroteSamples {
+				nf, err := openMusicStream(path)
+				if err != nil {
+					log.Printf("❌ [MUSIC] 跳转失败: %v", err)
+					req.done <- samplesDuration(wroteSamples)
+					return
+				}
+				f.Close()
+				f, wroteSamples = nf, 0
+			}
+			skipped, _ := discardSamples(f, target-wroteSamples)
+			wroteSamples += skipped
+			position.Store(wroteSamples)
+			log.Printf("⏩ [MUSIC] 跳转到 %s", samplesDuration(wroteSamples))
+			req.done <- samplesDuration(wroteSamples)
+		}
+		// waitResume 暂停期间阻塞，返回 Resume 换上的新输出；期间被 Stop 时返回 nil。暂停时也可以跳转
+		waitResume := func() pcmSink {
+			for {
+				select {
+				case <-stopCh:
+					return nil
+				case req := <-seekCh:
+					seekTo(req)
+				case s := <-resumeCh:
+					return s
+				}
+			}
+		}
 		for {
 			select {
 			case <-stopCh:
 				return
+			case <-pauseCh:
+				if out = waitResume(); out == nil {
+					return
+				}
+			case req := <-seekCh:
+				seekTo(req)
 			default:
 			}
 			n, err := f.Read(buf)
 			if n > 0 {
-				if startWall.IsZero() {
-					startWall = time.Now()
-					lastStepAt = startWall
-				}
-
-				// 读取当前目标音量，并按 dt 做平滑逼近（Duck 快、Unduck 慢）
-				now := time.Now()
-				dt := now.Sub(lastStepAt)
-				lastStepAt = now
-
-				m.volMutex.Lock()
-				target := m.targetVolume
-				current := m.currentVolume
-				m.volMutex.Unlock()
-
-				if target < 0 {
-					target = 0
-				} else if target > 1 {
-					target = 1
-				}
-				if current < 0 {
-					current = 0
-				} else if current > 1 {
-					current = 1
-				}
-
-				if dt <= 0 {
-					current = target
-				} else if current != target {
-					var tau time.Duration
-					if target < current {
-						tau = 120 * time.Millisecond
-					} else {
-						tau = 900 * time.Millisecond
+				if _, werr := out.Write(buf[:n]); werr != nil {
+					// 暂停会关掉输出：这一块等恢复后写到新输出上，其余写失败视为被停止
+					select {
+					case <-pauseCh:
+					default:
+						return
 					}
-					alpha := 1 - math.Exp(-float64(dt)/float64(tau))
-					if alpha < 0 {
-						alpha = 0
-					} else if alpha > 1 {
-						alpha = 1
+					if out = waitResume(); out == nil {
+						return
 					}
-					current = current + (target-current)*alpha
-				}
-
-				m.volMutex.Lock()
-				m.currentVolume = current
-				m.volMutex.Unlock()
-
-				// PCM16 振幅缩放 + 饱和裁剪
-				for i := 0; i+1 < n; i += 2 {
-					sample := int16(binary.LittleEndian.Uint16(buf[i : i+2]))
-					v := int(float64(sample) * current)
-					if v > 32767 {
-						v = 32767
-					} else if v < -32768 {
-						v = -32768
+					if _, werr := out.Write(buf[:n]); werr != nil {
+						return
 					}
-					binary.LittleEndian.PutUint16(buf[i:i+2], uint16(int16(v)))
 				}
-
-				if _, werr := pipe.Write(buf[:n]); werr != nil {
-					return
-				}
-
-				// 维护“前置缓冲”：若已写入的音频时长领先于墙钟太多，则主动 sleep 让播放追上来。
 				wroteSamples += int64(n / 2)
-				audioDur := time.Duration(wroteSamples) * time.Second / musicSampleRate
-				ahead := audioDur - time.Since(startWall)
-				if ahead > maxAhead {
-					sleepDur := ahead - targetAhead
-					if sleepDur > 0 {
-						select {
-						case <-stopCh:
-							return
-						case <-time.After(sleepDur):
-						}
-					}
-				}
+				position.Store(wroteSamples)
 			}
 
 			if err != nil {
 				break
 			}
 		}
-		m.mu.Lock()
-		if m.isPlaying && m.cmd == myCmd {
-			m.isPlaying = false
-			m.currentPath = ""
-			go myCmd.Wait()
+		q, end := m.trackEnded(out, pauseCh)
+		switch end {
+		case trackSuperseded:
+			return
+		case trackPausedAtEnd:
+			// 用户说了暂停：停在这里，不自动接下一首
+			assistant.Dispatch(EvMusicStopped{})
+			return
+		}
+		// 等这一首的尾巴播完再接下一首，两首不会叠在一起
+		out.Close()
+		// 按播放模式接着放下一首，中间不回到“没在放歌”的状态
+		if _, ok := q.Next(true); ok && m.playFrom(q) == nil {
+			return
 		}
-		m.mu.Unlock()
-	}(file, stdin, cmd, m.stopChan)
+		assistant.Dispatch(EvMusicStopped{})
+	}(file, sink, m.stopChan, m.pauseChan, m.resumeChan, m.seekChan, m.position)
+	return nil
+}
+
+// trackEnd 播放协程读到曲目结尾时的情形
+type trackEnd int
+
+const (
+	trackSuperseded  trackEnd = iota // 已被停止或换了别的歌，与这个协程无关了
+	trackFinished                    // 正常播完，按播放模式接着放
+	trackPausedAtEnd                 // 刚读到结尾就被暂停：没有可恢复的内容，也不自动切歌
+)
+
+// trackEnded 读到结尾时结算播放状态；正常播完时返回队列，由调用方接着放
+func (m *MusicManager) trackEnded(out pcmSink, pauseCh chan struct{}) (*playQueue, trackEnd) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.isPlaying && m.sink == out:
+		m.isPlaying = false
+		m.currentPath = ""
+		q := m.queue
+		m.queue = nil
+		return q, trackFinished
+	case m.paused && m.pauseChan == pauseCh:
+		log.Printf("⏹️ [MUSIC] 暂停时已播到结尾，不再自动切歌: %s", filepath.Base(m.currentPath))
+		m.paused = false
+		m.currentPath = ""
+		m.queue = nil
+		return nil, trackPausedAtEnd
+	}
+	return nil, trackSuperseded
 }
 
 func pickRandomExcluding(candidates []string, exclude string) (string, bool) {
@@ -570,40 +769,18 @@ func extractTitleFromPath(path string, fallback string) string {
 	return strings.TrimSpace(fallback)
 }
 
+// selectSong 从曲库索引选歌：RANDOM 随机（尽量避开 excludePath），否则取相关度最高的一首
 func selectSong(query string, excludePath string) (string, string, bool) {
-	files, err := ioutil.ReadDir(musicDir)
-	if err != nil {
-		return "", "", false
-	}
-	var candidates []string
-	for _, f := range files {
-		if !f.IsDir() && strings.HasSuffix(strings.ToLower(f.Name()), ".wav") {
-			candidates = append(candidates, filepath.Join(musicDir, f.Name()))
-		}
-	}
-	if len(candidates) == 0 {
-		return "", "", false
-	}
-	target := ""
+	lib := currentCatalog()
 	if query == "RANDOM" {
-		target, _ = pickRandomExcluding(candidates, excludePath)
-	} else {
-		q := strings.ToLower(query)
-		for _, path := range candidates {
-			if strings.Contains(strings.ToLower(filepath.Base(path)), q) {
-				target = path
-				break
-			}
-		}
-		if target == "" {
-			return "", "", false
-		}
+		t, ok := lib.Random(excludePath)
+		return t.Path, t.Title, ok
 	}
-	display := ""
-	if query != "RANDOM" {
-		display = query
+	matches := lib.Search(query)
+	if len(matches) == 0 {
+		return "", "", false
 	}
-	return target, extractTitleFromPath(target, display), true
+	return matches[0].Path, matches[0].Title, true
 }
 
 func (m *MusicManager) SearchAndPlayExclude(query string, excludePath string) (string, bool) {
@@ -611,7 +788,9 @@ func (m *MusicManager) SearchAndPlayExclude(query string, excludePath string) (s
 	if !ok {
 		return "", false
 	}
-	m.PlayFile(path)
+	if err := m.PlayFile(path); err != nil {
+		return "", false
+	}
 	return title, true
 }
 
@@ -623,399 +802,357 @@ func (m *MusicManager) SearchAndPlay(query string) (string, bool) {
 	return m.SearchAndPlayExclude(query, exclude)
 }
 
-func audioPlayer() {
-	doStart := func() (*exec.Cmd, io.WriteCloser) {
-		log.Println("🔍 [Audio-Link] 启动 aplay 物理进程...")
-		c := exec.Command("aplay", "-D", "default", "-t", "raw", "-r", "22050", "-f", "S16_LE", "-c", "1", "-B", "20000")
-		s, err := c.StdinPipe()
-		if err != nil {
-			return nil, nil
-		}
-		if err := c.Start(); err != nil {
-			return nil, nil
-		}
-		playerMutex.Lock()
-		playerCmd = c
-		playerStdin = s
-		playerMutex.Unlock()
-		return c, s
-	}
+// ttsAhead 播报源在混音器里最多缓冲多长；打断时 Kill 会直接丢掉，不影响打断速度
+const ttsAhead = 500 * time.Millisecond
 
-	for pcmData := range audioPcmChan {
-		if ttsMuted.Load() {
+func audioPlayer() {
+	for audio := range audioPcmChan {
+		pcmData := audio.pcm
+		if assistant.Muted() {
 			continue
 		}
 		if len(pcmData) == 0 {
-			log.Println("[Audio-Link] 收到数据结束标志，执行物理保活...")
-			time.Sleep(500 * time.Millisecond)
-			if playerStdin != nil {
-				playerStdin.Close()
-			}
-			if playerCmd != nil {
-				go func(c *exec.Cmd) {
-					if c != nil {
-						_ = c.Wait()
-					}
+			chatMemory.PlaybackDone(audio.reply)
+			playerMutex.Lock()
+			sink := playerSink
+			playerMutex.Unlock()
+			if sink != nil {
+				// 等混音器把这一路剩下的播完；输出设备一直开着，不用保活
+				go func(out pcmSink) {
+					_ = out.Close()
 					playerMutex.Lock()
-					playerCmd = nil
-					playerStdin = nil
+					current := playerSink == out
+					if current {
+						playerSink = nil
+					}
 					playerMutex.Unlock()
-					log.Println("[Audio-Link] 物理播报完成，系统解锁")
-				}(playerCmd)
+					if current {
+						assistant.Dispatch(EvSpeakingDone{})
+					}
+					log.Println("[Audio-Link] 播报完成")
+				}(sink)
 			}
 			continue
 		}
 
-		if playerStdin == nil {
-			doStart()
-		}
-		if playerStdin != nil {
-			_, err := playerStdin.Write(pcmData)
+		playerMutex.Lock()
+		sink := playerSink
+		playerMutex.Unlock()
+		if sink == nil {
+			log.Println("🔍 [Audio-Link] 打开播报输出...")
+			out, err := openPCMSink("tts", ttsSampleRate, ttsAhead)
 			if err != nil {
-				playerMutex.Lock()
-				playerCmd = nil
-				playerStdin = nil
-				playerMutex.Unlock()
+				log.Printf("❌ [Audio-Link] 打开播报输出失败: %v", err)
+				continue
+			}
+			playerMutex.Lock()
+			playerSink = out
+			playerMutex.Unlock()
+			sink = out
+			assistant.Dispatch(EvSpeakingStarted{})
+		}
+		if _, err := sink.Write(pcmData); err != nil {
+			sink.Kill()
+			playerMutex.Lock()
+			current := playerSink == sink
+			if current {
+				playerSink = nil
 			}
+			playerMutex.Unlock()
+			if current {
+				assistant.Dispatch(EvSpeakingDone{})
+			}
+		} else {
+			chatMemory.ReplyPlayed(audio.reply, len(pcmData))
 		}
 	}
 }
 
-func setTTSConn(c *websocket.Conn) {
-	ttsConnMu.Lock()
-	ttsConn = c
-	ttsConnMu.Unlock()
-}
-
-func closeTTSConn() {
-	ttsConnMu.Lock()
-	if ttsConn != nil {
-		_ = ttsConn.Close()
-		ttsConn = nil
-	}
-	ttsConnMu.Unlock()
-}
-
 func ttsManagerLoop() {
-	var conn *websocket.Conn
-	var wg sync.WaitGroup
-	var currentTaskID string
+	var sess SynthesisSession
 	var localSessionID string
-	taskStartedSignal := make(chan struct{}, 1)
 	var firstPacketReceived bool
+	var resampler *linearResampler
+	// failed 本轮回复的合成会话中途出错：余下文本直接丢弃，等 [[END]] 再统一收尾，
+	// 避免后半句另起会话、waitTTSDone 被提前唤醒
+	var failed bool
 
-	getSessionCtx := func() context.Context {
-		ctxMutex.Lock()
-		defer ctxMutex.Unlock()
-		return sessionCtx
+	closeSession := func() {
+		if sess != nil {
+			sess.Close()
+			sess = nil
+			setActiveTTS(nil)
+		}
 	}
 
-	receiveLoop := func(c *websocket.Conn, ctx context.Context) {
-		defer wg.Done()
-		defer func() {
-			if ctx.Err() == nil {
-				audioPcmChan <- []byte{}
-				select {
-				case ttsDoneChan <- struct{}{}:
-				default:
-				}
-			}
-		}()
-		for {
-			if ctx.Err() != nil {
-				return
-			}
-			msgType, msg, err := c.ReadMessage()
-			if err != nil {
-				return
-			}
-			if msgType == websocket.BinaryMessage {
-				if !firstPacketReceived {
-					tsFirstAudio = time.Now()
-					firstPacketReceived = true
-					log.Printf("TTS 首包: %v", tsFirstAudio.Sub(tsTtsStart))
-				}
-				if ctx.Err() == nil && !ttsMuted.Load() {
-					audioPcmChan <- msg
-				}
-				continue
-			}
-			var resp map[string]interface{}
-			if err := json.Unmarshal(msg, &resp); err != nil {
-				continue
-			}
-			header, _ := resp["header"].(map[string]interface{})
-			event := header["event"].(string)
-			if event == "task-started" {
-				select {
-				case taskStartedSignal <- struct{}{}:
-				default:
-				}
-			}
-			if event == "task-finished" || event == "task-failed" {
-				return
+	// endSession 正常结束（或合成中途失败）：通知播放器收尾，并唤醒 waitTTSDone
+	endSession := func(ctx context.Context, reply *pendingReply) {
+		closeSession()
+		if ctx.Err() == nil {
+			audioPcmChan <- ttsAudio{reply: reply}
+			select {
+			case ttsDoneChan <- struct{}{}:
+			default:
 			}
 		}
 	}
 
 	for {
-		msg, ok := <-ttsManagerChan
+		m, ok := <-ttsManagerChan
 		if !ok {
 			return
 		}
+		msg := m.text
 
-		sessionIDMutex.Lock()
-		globalID := currentSessionID
-		sessionIDMutex.Unlock()
-
+		currentCtx, globalID := assistant.Session()
 		if localSessionID != globalID {
-			if conn != nil {
-				conn.Close()
-				conn = nil
-				setTTSConn(nil)
-			}
+			closeSession()
 			localSessionID = globalID
+			failed = false
 		}
 
-		currentCtx := getSessionCtx()
 		if currentCtx.Err() != nil {
-			if conn != nil {
-				conn.Close()
-				conn = nil
-				setTTSConn(nil)
-			}
+			closeSession()
 			continue
 		}
 
 		if msg == "[[END]]" {
-			if conn != nil {
-				conn.WriteJSON(map[string]interface{}{
-					"header":  map[string]interface{}{"task_id": currentTaskID, "action": "finish-task", "streaming": "duplex"},
-					"payload": map[string]interface{}{"input": map[string]interface{}{}},
-				})
-				wg.Wait()
-				conn.Close()
-				conn = nil
-				setTTSConn(nil)
+			if sess != nil {
+				if err := sess.Finish(); err != nil {
+					log.Printf("⚠️ [TTS] 合成结束异常: %v", err)
+				}
+				endSession(currentCtx, m.reply)
+			} else if failed {
+				endSession(currentCtx, m.reply)
 			}
+			failed = false
+			continue
+		}
+
+		if failed {
+			log.Printf("⚠️ [TTS] 本轮合成已中断，丢弃: %q", msg)
 			continue
 		}
 
-		// 兜底过滤控制标记，防止 [PLAY: ...] 被送入 TTS
-		msg = regexp.MustCompile(`\[.*?\]`).ReplaceAllString(msg, "")
 		if strings.TrimSpace(msg) == "" {
 			continue
 		}
 
-		if ttsMuted.Load() {
+		if assistant.Muted() {
 			continue
 		}
 
 		log.Printf("TTS发送: %q", msg)
-		if strings.TrimSpace(msg) != "" {
-			if conn == nil {
-				dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-				headers := http.Header{}
-				headers.Add("Authorization", "Bearer "+dashAPIKey)
-				c, _, err := dialer.Dial(ttsWsURL, headers)
-				if err != nil {
-					continue
+		emitEvent(EventTTS, "", msg)
+		if sess == nil {
+			ctx, reply := currentCtx, m.reply
+			firstPacketReceived = false
+			resampler = nil
+			tsTtsStart = time.Now()
+			s, err := ttsEngine.Start(ctx, func(pcm []byte, sampleRate int) {
+				if !firstPacketReceived {
+					tsFirstAudio = time.Now()
+					firstPacketReceived = true
+					log.Printf("TTS 首包: %v", tsFirstAudio.Sub(tsTtsStart))
 				}
-				conn = c
-				setTTSConn(conn)
-				currentTaskID = uuid.New().String()
-				firstPacketReceived = false
-				tsTtsStart = time.Now()
-				wg.Add(1)
-				go receiveLoop(conn, currentCtx)
-				conn.WriteJSON(map[string]interface{}{
-					"header": map[string]interface{}{"task_id": currentTaskID, "action": "run-task", "streaming": "duplex"},
-					"payload": map[string]interface{}{
-						"task_group": "audio", "task": "tts", "function": "SpeechSynthesizer",
-						"model":      ttsModel,
-						"parameters": map[string]interface{}{"text_type": "PlainText", "voice": ttsVoice, "format": "pcm", "sample_rate": ttsSampleRate, "volume": ttsVolume, "enable_ssml": false},
-						"input":      map[string]interface{}{},
-					},
-				})
-				select {
-				case <-taskStartedSignal:
-					time.Sleep(50 * time.Millisecond)
-				case <-time.After(5 * time.Second):
-					conn.Close()
-					conn = nil
-					continue
+				if ctx.Err() != nil || assistant.Muted() {
+					return
 				}
-			}
-			conn.WriteJSON(map[string]interface{}{
-				"header":  map[string]interface{}{"task_id": currentTaskID, "action": "continue-task", "streaming": "duplex"},
-				"payload": map[string]interface{}{"input": map[string]interface{}{"text": msg}},
+				// 播放器固定按 ttsSampleRate 打开，采样率不一致时在这里转换
+				if sampleRate != ttsSampleRate {
+					if resampler == nil || resampler.from != sampleRate {
+						resampler = newLinearResampler(sampleRate, ttsSampleRate)
+					}
+					pcm = pcmInt16ToBytes(resampler.Process(pcmBytesToInt16(pcm)))
+				}
+				chatMemory.ReplyAudio(reply, len(pcm))
+				audioPcmChan <- ttsAudio{pcm: pcm, reply: reply}
 			})
-			time.Sleep(50 * time.Millisecond)
+			if err != nil {
+				log.Printf("❌ [TTS] %s 建立会话失败: %v", ttsEngine.Name(), err)
+				continue
+			}
+			sess = s
+			setActiveTTS(sess)
+		}
+		if err := sess.Send(msg); err != nil {
+			log.Printf("❌ [TTS] 发送文本失败: %v", err)
+			closeSession()
+			failed = true
 		}
 	}
 }
 
-func callAgentStream(ctx context.Context, prompt string, enableSearch bool, suppressStreaming bool) {
+func callAgentStream(ctx context.Context, prompt string, enableSearch bool) {
 	flushChannel(ttsManagerChan)
+	drainTTSDone()
 	llmStart := time.Now()
-	tagFilter := &controlTagFilter{}
 
-	// 策略：联网搜索用 Max(准确但慢)，普通闲聊用 Turbo(极快)
-	modelName := llmModelFast
 	if enableSearch {
-		modelName = llmModelSearch
 		log.Println("LLM: 检测到时效性需求，已动态开启联网搜索...")
 	}
 
-	systemPrompt := "你是智能助手。仅在用户【明确要求播放音乐】（如“放首歌”、“听周杰伦”）时，才在回复末尾添加 [PLAY: 歌名]（随机播放用 [PLAY: RANDOM]）。" +
-		"如果用户要求停止，加上 [STOP]。" +
-		"回答天气、新闻、闲聊等普通问题时，【严禁】添加任何播放指令。"
-	payload := map[string]interface{}{
-		"model": modelName,
-		"input": map[string]interface{}{
-			"messages": []map[string]string{
-				{"role": "system", "content": systemPrompt},
-				{"role": "user", "content": prompt},
-			},
-		},
-		"parameters": map[string]interface{}{
-			"result_format":      "text",
-			"incremental_output": true,
-			"enable_search":      enableSearch, // 动态开关
-		},
-	}
-
-	jsonBody, _ := json.Marshal(payload)
-	req, _ := http.NewRequestWithContext(ctx, "POST", llmURL, bytes.NewReader(jsonBody))
-	req.Header.Set("Authorization", "Bearer "+dashAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-DashScope-SSE", "enable")
-
-	resp, err := insecureClient.Do(req)
-	if err != nil {
-		log.Printf("❌ LLM: 请求失败: %v", err)
-		musicMgr.Unduck()
-		return
-	}
-	defer resp.Body.Close()
+	systemPrompt := "你是智能音箱里的语音助手，回答会被直接朗读，请口语化、简洁。" +
+		"仅在用户【明确要求】放歌、停止音乐、调音量或设置提醒时调用对应工具，并根据工具结果用一句话确认；" +
+		"回答天气、新闻、闲聊等普通问题时【严禁】调用播放工具。"
+	// 带上本次唤醒期间的历史轮次，支持“她多大了”这类追问
+	messages := []ChatMessage{{Role: "system", Content: systemPrompt}}
+	messages = append(messages, chatMemory.Messages()...)
+	messages = append(messages, ChatMessage{Role: "user", Content: prompt})
+	req := ChatRequest{Messages: messages, Tools: assistantTools.Specs(), EnableSearch: enableSearch}
+
+	reply := chatMemory.BeginReply(prompt)
+	defer chatMemory.CommitReply(reply)
+
+	// 等首字期间响提示音；出字、出错或结束时停掉
+	var stopThinkingOnce sync.Once
+	stopThinking := startThinkingEarcon(ctx)
+	stopThinkingEarcon := func() { stopThinkingOnce.Do(stopThinking) }
+	defer stopThinkingEarcon()
 
-	scanner := bufio.NewScanner(resp.Body)
 	var fullTextBuilder strings.Builder
+	var roundText strings.Builder
 	var chunkBuffer strings.Builder
 	var firstChunkSent = false
+	var afters []func()
+	var fallbackSpeech []string
 
-	fmt.Print("LLM 推理: ")
-
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
+	onDelta := func(delta string) {
+		clean := cleanText(delta)
+		if clean == "" {
 			return
-		default:
-		}
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data:") {
-			continue
-		}
-		dataStr := strings.TrimPrefix(line, "data:")
-		if strings.TrimSpace(dataStr) == "[DONE]" {
-			break
 		}
+		stopThinkingEarcon()
+		// 流式文本回显到标准错误：标准输出可能是裸 PCM（AI_BOX_AUDIO_OUT=stdout）
+		fmt.Fprint(os.Stderr, clean)
+		fullTextBuilder.WriteString(clean)
+		roundText.WriteString(clean)
+		chunkBuffer.WriteString(clean)
 
-		var chunk struct {
-			Output struct {
-				Text string `json:"text"`
-			} `json:"output"`
+		// 动态调整首包断句阈值：联网搜索时降低阈值以减少用户焦虑
+		threshold := 30
+		if enableSearch {
+			threshold = 15 // 搜索时只要有15个字或标点就立刻播报
 		}
-		if err := json.Unmarshal([]byte(dataStr), &chunk); err == nil && chunk.Output.Text != "" {
-			clean := cleanText(chunk.Output.Text)
-			if clean == "" {
-				continue
-			}
-			fmt.Print(clean)
-			fullTextBuilder.WriteString(clean)
-			if suppressStreaming {
-				continue
+
+		if !firstChunkSent {
+			if strings.ContainsAny(clean, "，。！？,.!?\n") || chunkBuffer.Len() > threshold {
+				firstChunkSent = true
+				sendChunk(reply, &chunkBuffer)
 			}
-			speakable := tagFilter.Filter(clean)
-			if speakable == "" {
-				continue
+		} else {
+			if strings.ContainsAny(clean, "，。！？,.!?\n") || chunkBuffer.Len() > 80 {
+				sendChunk(reply, &chunkBuffer)
 			}
-			chunkBuffer.WriteString(speakable)
+		}
+	}
 
-			// 动态调整首包断句阈值：联网搜索时降低阈值以减少用户焦虑
-			threshold := 30
-			if enableSearch {
-				threshold = 15 // 搜索时只要有15个字或标点就立刻播报
+	fmt.Fprint(os.Stderr, "LLM 推理: ")
+	for round := 0; ; round++ {
+		roundText.Reset()
+		calls, err := llmClient.Stream(ctx, req, onDelta)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			log.Printf("❌ LLM: 请求失败(%s): %v", llmClient.Name(), err)
+			// 已经播报了一部分（或工具已执行）就不再插入报错，只把已有内容收尾
+			if fullTextBuilder.Len() == 0 && len(fallbackSpeech) == 0 {
+				speakErrorMessage()
+				assistant.Dispatch(EvUnduck{})
+				return
 			}
+			break
+		}
+		if len(calls) == 0 || len(req.Tools) == 0 {
+			break
+		}
 
-			if !firstChunkSent {
-				if strings.ContainsAny(speakable, "，。！？,.!?\n") || chunkBuffer.Len() > threshold {
-					firstChunkSent = true
-					sendChunk(&chunkBuffer)
-				}
-			} else {
-				if strings.ContainsAny(speakable, "，。！？,.!?\n") || chunkBuffer.Len() > 80 {
-					sendChunk(&chunkBuffer)
-				}
+		// 执行工具并把结果回传，让模型组织确认话术
+		req.Messages = append(req.Messages, ChatMessage{Role: "assistant", Content: roundText.String(), ToolCalls: calls})
+		for _, call := range calls {
+			res := assistantTools.Dispatch(ctx, call)
+			req.Messages = append(req.Messages, ChatMessage{Role: "tool", Content: res.Content, ToolCallID: call.ID, Name: call.Function.Name})
+			if res.After != nil {
+				afters = append(afters, res.After)
 			}
+			if res.Speech != "" {
+				fallbackSpeech = append(fallbackSpeech, res.Speech)
+			}
+		}
+		// 最后一轮不再提供工具，逼模型用文字收尾
+		if round+1 >= MAX_TOOL_ROUNDS {
+			req.Tools = nil
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(os.Stderr)
 	log.Printf("⏱LLM 推理结束，总耗时: %v", time.Since(llmStart))
+	stopThinkingEarcon()
 
-	// 处理剩余文本（仅非抑制流式时）
-	if !suppressStreaming {
-		sendChunk(&chunkBuffer)
-		ttsManagerChan <- "[[END]]"
+	// 模型执行完工具却没说话：用工具给的兜底话术确认
+	if fullTextBuilder.Len() == 0 && len(fallbackSpeech) > 0 {
+		chunkBuffer.WriteString(strings.Join(fallbackSpeech, "，"))
 	}
+	spoke := fullTextBuilder.Len() > 0 || chunkBuffer.Len() > 0
+	sendChunk(reply, &chunkBuffer)
+	ttsManagerChan <- ttsMessage{text: "[[END]]", reply: reply}
+	log.Printf("LLM汇总: tools=%d fullText=%q", len(fallbackSpeech), fullTextBuilder.String())
 
-	// 指令解析逻辑
-	fullText := fullTextBuilder.String()
-	log.Printf("LLM汇总: suppressStreaming=%v fullText=%q", suppressStreaming, fullText)
-	if strings.Contains(fullText, "[STOP]") {
-		musicMgr.Stop()
-	}
-	if matches := regexp.MustCompile(`(?i)\[PLAY:\s*(.*?)\]`).FindStringSubmatch(fullText); len(matches) > 1 {
-		query := strings.TrimSpace(matches[1])
-		exclude := ""
-		if query == "RANDOM" {
-			exclude = musicMgr.CurrentSongPath()
+	// 放歌等动作等确认播报结束后再执行，避免和播报抢声卡
+	if len(afters) > 0 {
+		if spoke {
+			waitTTSDone(15 * time.Second)
 		}
-		path, title, ok := selectSong(query, exclude)
-		log.Printf("播放解析: query=%q ok=%v title=%q path=%q", query, ok, title, path)
-		if ok {
-			speakPlayConfirmationAndWait(title)
-			musicMgr.PlayFile(path)
+		if ctx.Err() != nil {
+			return
 		}
-		return
-	}
-
-	// 未识别到播放指令但抑制了流式播报：补发一次完整回复
-	if suppressStreaming {
-		text := regexp.MustCompile(`\[.*?\]`).ReplaceAllString(fullText, "")
-		if strings.TrimSpace(text) != "" {
-			ttsManagerChan <- strings.TrimSpace(text)
-			ttsManagerChan <- "[[END]]"
+		for _, after := range afters {
+			after()
 		}
 	}
 }
 
-// 辅助函数：发送文本块到 TTS
-func sendChunk(buf *strings.Builder) {
-	text := regexp.MustCompile(`\[.*?\]`).ReplaceAllString(buf.String(), "")
-	if strings.TrimSpace(text) != "" {
-		ttsManagerChan <- strings.TrimSpace(text)
+// 辅助函数：发送文本块到 TTS，记到 reply 这一轮
+func sendChunk(reply *pendingReply, buf *strings.Builder) {
+	text := strings.TrimSpace(buf.String())
+	if text != "" {
+		chatMemory.ReplyText(reply, text)
+		ttsManagerChan <- ttsMessage{text: text, reply: reply}
 	}
 	buf.Reset()
 }
 
+// speakErrorMessage 云端对话失败时的兜底播报（TTS 云端不可用时会自动改用本地合成）
+func speakErrorMessage() {
+	playEarcon(earconError)
+	speakText(llmErrorText)
+}
+
+func playConfirmationText(title string) string {
+	return fmt.Sprintf("好的，正在为您播放《%s》", strings.TrimSpace(title))
+}
+
+func playModeText(mode PlayMode) string {
+	return "好的，已切换为" + mode.Label()
+}
+
+func musicErrorText(title string) string {
+	return fmt.Sprintf("抱歉，《%s》的音频格式不支持，没法播放", strings.TrimSpace(title))
+}
+
 func speakPlayConfirmation(title string) {
 	title = strings.TrimSpace(title)
 	if title == "" {
 		return
 	}
-	ttsManagerChan <- fmt.Sprintf("好的，正在为您播放《%s》", title)
-	ttsManagerChan <- "[[END]]"
+	speakText(playConfirmationText(title))
+}
+
+// speakMusicError 曲库文件无法解码时的播报，代替播放出一段噪声
+func speakMusicError(title string) {
+	playEarcon(earconError)
+	speakText(musicErrorText(title))
 }
 
 func drainTTSDone() {
@@ -1048,42 +1185,130 @@ func speakPlayConfirmationAndWait(title string) {
 }
 
 func resetSessionForTTS() {
-	ctxMutex.Lock()
-	if sessionCancel != nil {
-		sessionCancel()
-	}
-	sessionCtx, sessionCancel = context.WithCancel(context.Background())
-	ctxMutex.Unlock()
-
-	sessionIDMutex.Lock()
-	currentSessionID = uuid.New().String()
-	sessionIDMutex.Unlock()
+	assistant.NewSession()
 }
 
+// performStop 打断：取消会话并切断所有声音源
 func performStop() {
-	log.Println("物理清理: 强制切断所有声音源")
-	ctxMutex.Lock()
-	if sessionCancel != nil {
-		sessionCancel()
+	assistant.Dispatch(EvStop{})
+}
+
+// deviceEffects 状态机动作的线上实现
+type deviceEffects struct{}
+
+func (deviceEffects) SpeakWakeAck() { speakWakeAck() }
+func (deviceEffects) Duck()         { musicMgr.Duck() }
+func (deviceEffects) Unduck()       { musicMgr.Unduck() }
+
+func (deviceEffects) Silence() {
+	// 先冻结播放进度，记忆里只保留已经播出去的部分（播报缓冲里还没出声的不算）
+	playerMutex.Lock()
+	sink := playerSink
+	playerMutex.Unlock()
+	if u, ok := sink.(interface{ Unplayed() int }); ok {
+		chatMemory.ReplyUnplayed(u.Unplayed())
 	}
-	ctxMutex.Unlock()
-	closeTTSConn()
+	chatMemory.Interrupt()
+	abortActiveTTS()
 
 	flushChannel(ttsManagerChan)
 	flushChannel(audioPcmChan)
 
-	exec.Command("killall", "-9", "aplay").Run()
-	musicMgr.Stop()
+	// 打断词暂停的歌保留着，等“继续播放”
+	if !musicMgr.IsPaused() {
+		musicMgr.Stop()
+	}
 
 	playerMutex.Lock()
-	if playerStdin != nil {
-		playerStdin.Close()
+	if playerSink != nil {
+		playerSink.Kill()
 	}
-	playerCmd = nil
-	playerStdin = nil
+	playerSink = nil
 	playerMutex.Unlock()
 }
 
+func (deviceEffects) PauseMusic() bool  { return musicMgr.Pause() }
+func (deviceEffects) ResumeMusic() bool { return musicMgr.Resume() }
+
+func (deviceEffects) SongExists(query string) bool { return hasLocalSongMatch(query) }
+func (deviceEffects) CurrentSong() string          { return musicMgr.CurrentSongPath() }
+
+func (deviceEffects) PlayRandom(exclude string) {
+	path, title, ok := selectSong("RANDOM", exclude)
+	if !ok {
+		return
+	}
+	if err := checkMusicFile(path); err != nil {
+		log.Printf("❌ [MUSIC] 无法播放 %s: %v", filepath.Base(path), err)
+		speakMusicError(title)
+		return
+	}
+	speakPlayConfirmationAndWait(title)
+	musicMgr.PlayQueue(currentCatalog().QueueFrom(path))
+}
+
+func (deviceEffects) SavePlayMode(mode PlayMode) { savePlayMode(mode) }
+
+// savePlayMode 切换并保存播放模式，返回实际生效的模式
+func savePlayMode(mode PlayMode) PlayMode {
+	mode = musicMgr.SetPlayMode(mode)
+	if err := updateSettings(func(s *deviceSettings) { s.PlayMode = mode }); err != nil {
+		log.Printf("⚠️ [设置] 保存播放模式失败: %v", err)
+	}
+	return mode
+}
+
+func (deviceEffects) SetPlayMode(mode PlayMode) {
+	mode = savePlayMode(mode)
+	if musicMgr.IsPlaying() {
+		speakText(playModeText(mode))
+		return
+	}
+	// 没在放歌：说“随机播放/顺序播放”就是想听歌，按新模式把整个曲库放起来
+	lib := currentCatalog()
+	tracks := lib.Tracks()
+	if len(tracks) == 0 {
+		speakText(playModeText(mode))
+		return
+	}
+	first := tracks[0]
+	if mode == PlayShuffle {
+		first, _ = lib.Random("")
+	}
+	drainTTSDone()
+	speakText(playModeText(mode))
+	waitTTSDone(8 * time.Second)
+	if err := musicMgr.PlayQueue(lib.QueueFrom(first.Path)); err != nil {
+		speakMusicError(first.Title)
+	}
+}
+
+func (deviceEffects) PlayPrevious(musicOn bool)   { playPrevious(musicOn) }
+func (deviceEffects) Replay()                     { replaySong() }
+func (deviceEffects) Seek(cmd seekCommand)        { seekMusic(cmd) }
+func (deviceEffects) AnswerNowPlaying()           { answerNowPlaying() }
+func (deviceEffects) Favorite(action string)      { handleFavorite(action) }
+func (deviceEffects) AnswerLastSong(musicOn bool) { answerLastSong(musicOn) }
+
+func (deviceEffects) HasPrevious(musicOn bool) bool { return previousTrack(musicOn) != "" }
+
+func (deviceEffects) AnswerTime() { answerTimeQuery(time.Now()) }
+
+func (deviceEffects) AdjustVolume(cmd volumeCommand, speaking bool) { adjustVolume(cmd, speaking) }
+
+func (deviceEffects) Chat(ctx context.Context, session, text string, enableSearch bool) {
+	done := trackPipeline()
+	go func() {
+		defer done()
+		defer assistant.Dispatch(EvReplyDone{Session: session})
+		callAgentStream(ctx, text, enableSearch)
+	}()
+}
+
+func (deviceEffects) ClearMemory() { chatMemory.Clear() }
+
+func (deviceEffects) Exit() { os.Exit(0) }
+
 // 辅助判定：ASR 文本是否包含明确的点歌/换歌意图
 func hasMusicIntent(text string) bool {
 	// 包含这些动词通常意味着用户想操作音乐
@@ -1173,230 +1398,131 @@ func isQuickSwitchCommand(text string, isMusicBusy bool) bool {
 	return false
 }
 
-func processASR(pcm []int16) {
-	if float64(len(pcm))/16000.0 < 0.5 {
-		return
-	}
-
-	pcmBytes := make([]byte, len(pcm)*2)
-	for i, v := range pcm {
-		binary.LittleEndian.PutUint16(pcmBytes[i*2:], uint16(v))
-	}
-	text := callASRWebSocket(pcmBytes)
-	if text == "" {
-		musicMgr.Unduck()
-		return
-	}
-	if !isInterrupt(text) {
-		ttsMuted.Store(false)
+// isTimeQuery 判断是否为报时类问题（几点了/现在几点/现在时间）
+func isTimeQuery(text string) bool {
+	cleaned := normalizeIntentText(text)
+	if cleaned == "" {
+		return false
 	}
-
-	// ================= 伪唤醒门控（最小侵入） =================
-	tail, hitWake, pureWake := stripWakeAndGetTail(text)
-
-	if !awakeFlag.Load() {
-		// 休眠态：只有命中唤醒词才进入后续处理，其余任何指令都忽略
-		if !hitWake {
-			log.Printf("[休眠] 未检测到唤醒词，忽略: [%s]", text)
-			musicMgr.Unduck()
-			return
-		}
-
-		awakeFlag.Store(true)
-		touchActive()
-
-		// 纯唤醒词：播报“我在”
-		if pureWake {
-			log.Println("[伪唤醒] 唤醒成功")
-			speakWakeAck()
-			musicMgr.Unduck()
-			return
-		}
-
-		// 唤醒词后携带指令：直接处理（不播“我在”）
-		if strings.TrimSpace(tail) != "" {
-			log.Printf("[伪唤醒] 唤醒并转入指令: [%s]", tail)
-			text = tail
-		} else {
-			// 理论不会出现：pureWake=false 但 tail 为空；兜底不改原 text
-			log.Printf("[伪唤醒] 唤醒命中但未解析到后续指令，按原文处理: [%s]", text)
-		}
-	} else {
-		// 唤醒态：刷新活跃时间；若仅唤醒词则回应“我在”，若携带指令则剥离后继续处理
-		touchActive()
-		if hitWake {
-			if pureWake {
-				log.Println("[伪唤醒] 收到唤醒词")
-				speakWakeAck()
-				musicMgr.Unduck()
-				return
-			}
-			if strings.TrimSpace(tail) != "" && tail != text {
-				text = tail
-			}
+	timeWords := []string{"几点了", "几点钟", "现在几点", "现在时间", "报时", "什么时间了"}
+	for _, w := range timeWords {
+		if strings.Contains(cleaned, w) {
+			return true
 		}
 	}
+	return false
+}
 
-	log.Printf("ASR识别结果: [%s]", text)
-
-	// 1. 二级打断：退出判定
-	if isExit(text) {
-		log.Println("收到退出指令，关闭系统")
-		performStop()
-		os.Exit(0)
-	}
-
-	// 2. 获取物理占用状态
-	playerMutex.Lock()
-	isTtsBusy := playerCmd != nil && playerCmd.Process != nil
-	playerMutex.Unlock()
-	isMusicBusy := musicMgr.IsPlaying()
-
-	// 3. 意图判断与错误指令过滤
-	interrupt := isInterrupt(text)
-	randomPlay := isRandomPlayIntent(text)
-	musicReq := hasMusicIntent(text) || randomPlay
-	quickSwitch := isQuickSwitchCommand(text, isMusicBusy)
-	songQuery := ""
-	invalidMusic := false
-	if musicReq && !randomPlay {
-		songQuery = extractSongQuery(text)
-		if songQuery == "" || !hasLocalSongMatch(songQuery) {
-			invalidMusic = true
-			musicReq = false
-		}
+func formatTimeAnswer(now time.Time) string {
+	if now.Minute() == 0 {
+		return fmt.Sprintf("现在是%d点整", now.Hour())
 	}
-	if invalidMusic && !interrupt && !quickSwitch {
-		log.Printf("音乐指令未命中本地曲库，忽略: text=%q query=%q", text, songQuery)
-		musicMgr.Unduck()
-		return
-	}
-
-	// 4. 核心改进：忙碌状态下的穿透逻辑
-	if isTtsBusy || isMusicBusy {
-		log.Printf("忙碌判断: text=%q cleaned=%q musicReq=%v randomPlay=%v interrupt=%v quickSwitch=%v", text, normalizeIntentText(text), musicReq, randomPlay, interrupt, quickSwitch)
-
-		// 允许打断词或点歌意图“穿透”锁定
-		if interrupt || musicReq || quickSwitch {
-			log.Printf("忙碌穿透: 指令 [%s] 合法，执行物理清理并重置意图", text)
-			var currentSong string
-			if quickSwitch || randomPlay {
-				currentSong = musicMgr.CurrentSongPath()
-			}
-			if interrupt {
-				ttsMuted.Store(true)
-			}
-			performStop()
-			resetSessionForTTS()
-
-			// 快速切歌：直接执行随机播放，避免 LLM 推理延迟
-			if quickSwitch {
-				path, title, ok := selectSong("RANDOM", currentSong)
-				if ok {
-					speakPlayConfirmationAndWait(title)
-					musicMgr.PlayFile(path)
-				}
-				return
-			}
-
-			// 随机播放意图：直接随机播放
-			if randomPlay {
-				path, title, ok := selectSong("RANDOM", currentSong)
-				if ok {
-					speakPlayConfirmationAndWait(title)
-					musicMgr.PlayFile(path)
-				}
-				return
-			}
+	return fmt.Sprintf("现在是%d点%d分", now.Hour(), now.Minute())
+}
 
-			// 打断词：物理切断后直接返回，避免再次进入 LLM/TTS
-			if interrupt {
-				return
-			}
+func answerTimeQuery(now time.Time) {
+	answer := formatTimeAnswer(now)
+	log.Printf("本地报时: %s", answer)
+	flushChannel(ttsManagerChan)
+	speakText(answer)
+}
 
-			// 如果是“听庙堂之外”，执行完 performStop 后不 return，
-			// 而是继续往下走，交给 LLM 解析出 [PLAY:庙堂之外]
-		} else {
-			// 真正的无关闲聊，在忙碌时依然拦截
-			log.Printf("锁定拦截: 忽略非控制类指令: [%s]", text)
-			musicMgr.Unduck()
-			return
-		}
+// processASR 处理一轮最终识别结果（由 asrTurn 在识别结束后调用），判定与状态迁移见 Assistant.decide。
+// localWake=true 表示本段语音中本地 KWS 已命中唤醒词。
+func processASR(text string, localWake bool, doa int) {
+	if doa != doaUnknown {
+		log.Printf("🧭 [DOA] 本轮方向 %d°: [%s]", doa, text)
 	}
+	assistant.Dispatch(EvASRResult{Text: text, LocalWake: localWake, DOA: doa})
+}
 
-	// 5. 联网搜索判定
-	enableSearch := false
-	searchKeywords := []string{"天气", "今天", "星期几", "实时", "最新"}
-	for _, k := range searchKeywords {
-		if strings.Contains(text, k) {
-			enableSearch = true
-			break
-		}
-	}
+const (
+	segmentEndSilenceFrames      = 10 // 本地 VAD 连续静音帧数（20ms/帧）超过它就收尾
+	segmentEndpointSilenceFrames = 5  // 引擎判定句末后，还要本地也听到这么多帧静音才收尾
+	segmentMaxSamples            = 16000 * 8
+)
 
-	// 6. 开启会话并执行 LLM 推理
-	if randomPlay {
-		path, title, ok := selectSong("RANDOM", musicMgr.CurrentSongPath())
-		if ok {
-			speakPlayConfirmationAndWait(title)
-			musicMgr.PlayFile(path)
-		}
-		return
-	}
-	suppressStreaming := musicReq
-	ctxMutex.Lock()
-	if sessionCancel != nil {
-		sessionCancel()
-	}
-	sessionCtx, sessionCancel = context.WithCancel(context.Background())
-	currentCtx := sessionCtx
-	ctxMutex.Unlock()
-
-	go callAgentStream(currentCtx, text, enableSearch, suppressStreaming)
-}
-
-func audioLoop(aecProc *aec.Processor, vadEng *vado.VAD) {
-	cmd := exec.Command("arecord",
-		"-D", arecordDevice,
-		"-c", strconv.Itoa(arecordChannels),
-		"-r", strconv.Itoa(arecordRate),
-		"-f", "S16_LE",
-		"-t", "raw",
-		"--period-size="+strconv.Itoa(arecordPeriodSize),
-		"--buffer-size="+strconv.Itoa(arecordBufferSize),
-	)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
+// segmentShouldEnd 语音段是否收尾：本地静音够长、超长，或引擎判定句末且本地也已静音。
+// 引擎的句末只在句中停顿时才提前收尾，避免“播放……庙堂之外”说到一半就被切成两段。
+func segmentShouldEnd(silenceFrames int, endpointed bool, samples int) bool {
+	switch {
+	case silenceFrames > segmentEndSilenceFrames, samples > segmentMaxSamples:
+		return true
+	case endpointed:
+		return silenceFrames >= segmentEndpointSilenceFrames
 	}
-	log.Println("🎤 麦克风已开启...")
+	return false
+}
+
+func audioLoop(src captureSource, aecProc *aec.Processor, vadEng *vado.VAD) {
+	defer src.Close()
+	settler, _ := src.(interface{ AfterSegment() })
 
-	readBuf := make([]byte, 256*10*2)
 	vadAccumulator := make([]int16, 0, 1024)
 	var asrBuffer []int16
 	silenceCount, speechCount := 0, 0
 	triggered := false
 	ducked := false
-	fallbackMono := make([]int16, 256)
+	fallbackMono := make([]int16, captureBlockSamples)
+	wakeInSegment := false
+	wasAwake := assistant.Awake()
+	var turn *asrTurn
+	var doas doaTracker
+	blockDOA := doaUnknown
+	aecFailed := false
 
 	for {
-		if _, err := io.ReadFull(stdout, readBuf); err != nil {
+		rawInt16, channels, err := src.ReadBlock()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("❌ 录音读取失败: %v", err)
+			}
 			break
 		}
-		rawInt16 := make([]int16, 256*10)
-		for i := 0; i < len(rawInt16); i++ {
-			rawInt16[i] = int16(binary.LittleEndian.Uint16(readBuf[i*2 : i*2+2]))
+		clean := rawInt16
+		blockDOA = doaUnknown
+		if channels > 1 {
+			clean = nil
+			if aecProc != nil {
+				var err error
+				clean, blockDOA, err = aecProc.Process(rawInt16)
+				if err != nil && !aecFailed {
+					log.Printf("❌ [AEC] 处理失败，改用第 0 通道直通: %v", err)
+				}
+				aecFailed = err != nil
+			}
+			if clean == nil {
+				// AEC 异常回退：取第 0 通道直通，避免整段音频被丢弃导致“说了却识别不到”
+				for i := 0; i < captureBlockSamples; i++ {
+					fallbackMono[i] = rawInt16[i*channels+0]
+				}
+				clean = fallbackMono
+			}
 		}
-		clean, _ := aecProc.Process(rawInt16)
-		if clean == nil {
-			// AEC 异常回退：取第 0 通道直通，避免整段音频被丢弃导致“说了却识别不到”
-			for i := 0; i < 256; i++ {
-				fallbackMono[i] = rawInt16[i*10+0]
+
+		// 本地唤醒：仅休眠态运行 KWS；唤醒态 -> 休眠态时清掉残留解码状态
+		if kwsSpotter != nil {
+			awake := assistant.Awake()
+			if wasAwake && !awake {
+				kwsSpotter.Reset()
+			}
+			if !awake {
+				if kw, ok := kwsSpotter.AcceptPCM(clean); ok && !wakeExcluded(kw, &doas) {
+					onLocalWake(kw)
+					awake = true
+					if triggered {
+						wakeInSegment = true
+						// 休眠态触发的语音段此前未开识别会话：唤醒后补开，并补推已录部分
+						if turn == nil {
+							turn = startASRTurn(asrBuffer)
+						}
+					} else {
+						// VAD 尚未成段（唤醒词过短/过轻），直接回应，避免把下一句指令误当作唤醒段
+						speakWakeAck()
+					}
+				}
 			}
-			clean = fallbackMono
+			wasAwake = awake
 		}
 		vadAccumulator = append(vadAccumulator, clean...)
 
@@ -1411,34 +1537,79 @@ func audioLoop(aecProc *aec.Processor, vadEng *vado.VAD) {
 			if active {
 				speechCount++
 				silenceCount = 0
+				// 方向从起声就开始累计，Duck、开识别会话、本地唤醒都按目前为止的估计放行
+				doas.Add(blockDOA)
 			} else {
 				silenceCount++
 				speechCount = 0
+				if !triggered && silenceCount >= segmentEndSilenceFrames {
+					doas.Reset()
+				}
 			}
+			_, _, doaHeld := doas.Excluded()
 
-			// 先快速 Duck（听感上立刻压低背景音），再决定是否进入 ASR 录音段
-			if speechCount > 2 && !ducked {
+			// 先快速 Duck（听感上立刻压低背景音），再决定是否进入 ASR 录音段；
+			// 方向估计在排除扇区内时先不压，移出后再压
+			if speechCount > 2 && !ducked && !doaHeld {
 				ducked = true
-				musicMgr.Duck()
+				assistant.Dispatch(EvDuck{})
 			}
 
 			if speechCount > 10 && !triggered {
 				triggered = true
+				emitEvent(EventSegmentStart, "", "")
 			}
 			if triggered {
+				// 流式识别：一进入语音段就开会话，先推预录缓冲（保住起始音节），之后边说边推。
+				// 本地唤醒模式下休眠态不开会话，音频不上云；方向在排除扇区内时等移出后再开（补推已录部分）。
+				if turn == nil && !doaHeld && (kwsSpotter == nil || assistant.Awake()) {
+					turn = startASRTurn(asrBuffer)
+				}
 				asrBuffer = append(asrBuffer, frame...)
-				if silenceCount > 10 || len(asrBuffer) > 16000*8 {
-					if len(asrBuffer) > 4800 {
-						finalData := make([]int16, len(asrBuffer))
-						copy(finalData, asrBuffer)
-						go processASR(finalData)
-					} else {
-						musicMgr.Unduck()
+				if turn != nil {
+					turn.Push(frame)
+				}
+				endpointed := turn != nil && turn.Endpointed()
+				if segmentShouldEnd(silenceCount, endpointed, len(asrBuffer)) {
+					doa, sector, excluded := doas.Excluded()
+					switch {
+					case excluded:
+						// 来自排除扇区（如电视方向）的语音不识别
+						log.Printf("🧭 [DOA] 语音段方向 %d° 在排除扇区 %s 内，丢弃", doa, sector)
+						emitEventDOA(EventSegmentEnd, "excluded", sector.String(), doa)
+						if turn != nil {
+							turn.Cancel()
+						}
+						assistant.Dispatch(EvUnduck{})
+					case turn == nil:
+						// 本地唤醒模式下，休眠态的语音段直接丢弃
+						emitEventDOA(EventSegmentEnd, "dropped", "", doa)
+						assistant.Dispatch(EvUnduck{})
+					case len(asrBuffer) >= 16000/2:
+						emitEventDOA(EventSegmentEnd, "asr", "", doa)
+						if assistant.Awake() {
+							playEarcon(earconEndOfSpeech)
+						}
+						turn.Finish(wakeInSegment, doa)
+					default:
+						// 不足 0.5s 视为噪声
+						emitEventDOA(EventSegmentEnd, "noise", "", doa)
+						turn.Cancel()
+						if wakeInSegment {
+							speakWakeAck()
+						}
+						assistant.Dispatch(EvUnduck{})
 					}
+					turn = nil
 					asrBuffer = []int16{}
+					doas.Reset()
 					triggered = false
 					ducked = false
 					silenceCount = 0
+					wakeInSegment = false
+					if settler != nil {
+						settler.AfterSegment()
+					}
 				}
 			} else {
 				if len(asrBuffer) > 8000 {
@@ -1450,53 +1621,6 @@ func audioLoop(aecProc *aec.Processor, vadEng *vado.VAD) {
 	}
 }
 
-func callASRWebSocket(data []byte) string {
-	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	headers := http.Header{}
-	headers.Add("Authorization", "Bearer "+dashAPIKey)
-	conn, _, err := dialer.Dial(asrWsURL, headers)
-	if err != nil {
-		return ""
-	}
-	defer conn.Close()
-	id := fmt.Sprintf("%032x", rand.Int63())
-	conn.WriteJSON(map[string]interface{}{
-		"header":  map[string]interface{}{"task_id": id, "action": "run-task", "streaming": "duplex"},
-		"payload": map[string]interface{}{"task_group": "audio", "task": "asr", "function": "recognition", "model": asrModel, "parameters": map[string]interface{}{"format": "pcm", "sample_rate": asrSampleRate}, "input": map[string]interface{}{}},
-	})
-	for i := 0; i < len(data); i += 3200 {
-		end := i + 3200
-		if end > len(data) {
-			end = len(data)
-		}
-		conn.WriteMessage(websocket.BinaryMessage, data[i:end])
-		time.Sleep(5 * time.Millisecond)
-	}
-	conn.WriteJSON(map[string]interface{}{"header": map[string]interface{}{"task_id": id, "action": "finish-task"}, "payload": map[string]interface{}{"input": map[string]interface{}{}}})
-	res := ""
-	for {
-		_, msg, err := conn.ReadMessage()
-		if err != nil {
-			break
-		}
-		var r map[string]interface{}
-		json.Unmarshal(msg, &r)
-		h, _ := r["header"].(map[string]interface{})
-		if h["event"] == "result-generated" {
-			p, _ := r["payload"].(map[string]interface{})
-			if o, ok := p["output"].(map[string]interface{}); ok {
-				if s, ok := o["sentence"].(map[string]interface{}); ok {
-					res = s["text"].(string)
-				}
-			}
-		}
-		if h["event"] == "task-finished" {
-			break
-		}
-	}
-	return res
-}
-
 func flushChannel[T any](c chan T) {
 	for {
 		select {
@@ -1,35 +1,15 @@
 package main
 
-import "testing"
-
-func TestControlTagFilter_Filter(t *testing.T) {
-	filter := &controlTagFilter{}
-
-	got := filter.Filter("好的，正在为您播放《庙堂之外》[PLAY: 庙堂之外]")
-	if got != "好的，正在为您播放《庙堂之外》" {
-		t.Fatalf("过滤控制标记失败，got=%q", got)
-	}
-}
-
-func TestControlTagFilter_FilterSplit(t *testing.T) {
-	filter := &controlTagFilter{}
-
-	part1 := filter.Filter("好的，正在为您播放《庙堂之外》[")
-	part2 := filter.Filter("PLAY: 庙堂之外]")
-	got := part1 + part2
-	if got != "好的，正在为您播放《庙堂之外》" {
-		t.Fatalf("跨分片过滤失败，got=%q", got)
-	}
-}
-
-func TestControlTagFilter_FilterDropAfterTag(t *testing.T) {
-	filter := &controlTagFilter{}
-
-	got := filter.Filter("好的，正在为您播放《庙堂之外》[PLAY: 庙堂之外]庙堂之外")
-	if got != "好的，正在为您播放《庙堂之外》" {
-		t.Fatalf("控制标记后仍输出文本，got=%q", got)
-	}
-}
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestPickRandomExcluding(t *testing.T) {
 	candidates := []string{"a.wav", "b.wav", "c.wav"}
@@ -97,3 +77,337 @@ func TestExtractTitleFromPath(t *testing.T) {
 		t.Fatalf("标题提取异常，got=%q", fallback)
 	}
 }
+
+func TestIsTimeQuery(t *testing.T) {
+	if !isTimeQuery("现在几点了？") {
+		t.Fatalf("报时意图识别失败")
+	}
+	if isTimeQuery("播放庙堂之外") {
+		t.Fatalf("报时意图误判")
+	}
+}
+
+func TestSegmentShouldEnd(t *testing.T) {
+	cases := []struct {
+		silence    int
+		endpointed bool
+		samples    int
+		want       bool
+	}{
+		{0, false, 16000, false},
+		{11, false, 16000, true},
+		{0, false, 16000*8 + 1, true},
+		{0, true, 16000, false}, // 引擎判了句末但人还在说（句中停顿前的一句）：不收尾
+		{3, true, 16000, false},
+		{5, true, 16000, true},
+		{5, false, 16000, false},
+	}
+	for _, c := range cases {
+		if got := segmentShouldEnd(c.silence, c.endpointed, c.samples); got != c.want {
+			t.Errorf("silence=%d endpointed=%v samples=%d: got %v", c.silence, c.endpointed, c.samples, got)
+		}
+	}
+}
+
+func TestFormatTimeAnswer(t *testing.T) {
+	got := formatTimeAnswer(time.Date(2026, 1, 23, 9, 0, 0, 0, time.Local))
+	if got != "现在是9点整" {
+		t.Fatalf("整点报时异常，got=%q", got)
+	}
+	got = formatTimeAnswer(time.Date(2026, 1, 23, 15, 7, 0, 0, time.Local))
+	if got != "现在是15点7分" {
+		t.Fatalf("报时异常，got=%q", got)
+	}
+}
+
+func TestReadSSEOpenAIChunks(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}]}\n\n" +
+		": keep-alive\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"，世界\"}}]}\n\n" +
+		"data: [DONE]\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"多余\"}}]}\n\n"
+	var got strings.Builder
+	err := readSSE(context.Background(), strings.NewReader(stream), func(data []byte) {
+		got.WriteString(parseOpenAIChunk(data).Content)
+	})
+	if err != nil {
+		t.Fatalf("解析 SSE 失败: %v", err)
+	}
+	if got.String() != "你好，世界" {
+		t.Fatalf("OpenAI 增量拼接异常，got=%q", got.String())
+	}
+}
+
+func TestReadSSEErrorEvent(t *testing.T) {
+	stream := "id:1\nevent:result\ndata:{\"output\":{\"text\":\"你好\"}}\n\n" +
+		"id:2\nevent:error\n:HTTP_STATUS/500\ndata:{\"code\":\"InternalError\",\"message\":\"overloaded\"}\n\n"
+	var got strings.Builder
+	err := readSSE(context.Background(), strings.NewReader(stream), func(data []byte) {
+		got.WriteString(parseDashScopeChunk(data).Content)
+	})
+	if err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Fatalf("event:error 应返回错误，got=%v", err)
+	}
+	if got.String() != "你好" {
+		t.Fatalf("错误之前的增量应保留，got=%q", got.String())
+	}
+}
+
+func TestParseDashScopeChunk(t *testing.T) {
+	if got := parseDashScopeChunk([]byte(`{"output":{"text":"晴天"}}`)).Content; got != "晴天" {
+		t.Fatalf("DashScope 增量解析异常，got=%q", got)
+	}
+	if got := parseDashScopeChunk([]byte(`not json`)).Content; got != "" {
+		t.Fatalf("非法数据应忽略，got=%q", got)
+	}
+}
+
+func TestToolCallAccumulatorOpenAI(t *testing.T) {
+	chunks := []string{
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"play_music","arguments":""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"query\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"庙堂之外\"}"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","function":{"name":"set_volume","arguments":"{\"level\":30}"}}]}}]}`,
+	}
+	acc := &toolCallAccumulator{}
+	for _, c := range chunks {
+		for _, d := range parseOpenAIChunk([]byte(c)).ToolCalls {
+			acc.Add(d)
+		}
+	}
+	calls := acc.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("工具调用数量异常，got=%+v", calls)
+	}
+	if calls[0].ID != "call_a" || calls[0].Function.Name != "play_music" || calls[0].Function.Arguments != `{"query":"庙堂之外"}` {
+		t.Fatalf("工具调用拼接异常，got=%+v", calls[0])
+	}
+	if calls[1].Function.Name != "set_volume" || calls[1].Function.Arguments != `{"level":30}` {
+		t.Fatalf("第二个工具调用异常，got=%+v", calls[1])
+	}
+}
+
+func TestToolCallAccumulatorDashScope(t *testing.T) {
+	chunks := []string{
+		`{"output":{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_x","type":"function","function":{"name":"stop_music","arguments":""}}]}}]}}`,
+		`{"output":{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"arguments":"{}"}}]}}]}}`,
+	}
+	acc := &toolCallAccumulator{}
+	for _, c := range chunks {
+		for _, d := range parseDashScopeChunk([]byte(c)).ToolCalls {
+			acc.Add(d)
+		}
+	}
+	calls := acc.Calls()
+	if len(calls) != 1 || calls[0].ID != "call_x" || calls[0].Function.Name != "stop_music" || calls[0].Function.Arguments != "{}" {
+		t.Fatalf("DashScope 工具调用拼接异常，got=%+v", calls)
+	}
+}
+
+func TestToolRegistryDispatch(t *testing.T) {
+	r := newToolRegistry()
+	r.Register(&Tool{
+		Name:       "echo",
+		Parameters: map[string]interface{}{"type": "object"},
+		Handler: func(ctx context.Context, args json.RawMessage) ToolResult {
+			return toolOK(map[string]interface{}{"args": string(args)})
+		},
+	})
+	if specs := r.Specs(); len(specs) != 1 || specs[0]["type"] != "function" {
+		t.Fatalf("工具描述导出异常，got=%+v", specs)
+	}
+	res := r.Dispatch(context.Background(), ToolCall{Function: ToolFunctionCall{Name: "echo"}})
+	if res.Content != `{"args":"{}","ok":true}` {
+		t.Fatalf("空参数应按 {} 处理，got=%s", res.Content)
+	}
+	res = r.Dispatch(context.Background(), ToolCall{Function: ToolFunctionCall{Name: "echo", Arguments: "{oops"}})
+	if !strings.Contains(res.Content, `"ok":false`) {
+		t.Fatalf("非法参数应回传错误，got=%s", res.Content)
+	}
+	res = r.Dispatch(context.Background(), ToolCall{Function: ToolFunctionCall{Name: "missing"}})
+	if !strings.Contains(res.Content, "未知工具") {
+		t.Fatalf("未知工具应回传错误，got=%s", res.Content)
+	}
+}
+
+func TestConversationMemoryTrim(t *testing.T) {
+	m := newConversationMemory(2, 1000)
+	for _, q := range []string{"周杰伦的老婆是谁", "她多大了", "她是哪里人"} {
+		r := m.BeginReply(q)
+		m.ReplyText(r, "回答"+q)
+		m.CommitReply(r)
+	}
+	msgs := m.Messages()
+	if len(msgs) != 4 || msgs[0].Content != "她多大了" || msgs[3].Content != "回答她是哪里人" {
+		t.Fatalf("按轮数裁剪异常，got=%+v", msgs)
+	}
+
+	m = newConversationMemory(10, 12)
+	r := m.BeginReply("一二三")
+	m.ReplyText(r, "四五六")
+	m.CommitReply(r)
+	r = m.BeginReply("七八九")
+	m.ReplyText(r, "十十十十")
+	m.CommitReply(r)
+	if msgs := m.Messages(); len(msgs) != 2 || msgs[0].Content != "七八九" {
+		t.Fatalf("按字数裁剪异常，got=%+v", msgs)
+	}
+
+	m.Clear()
+	if len(m.Messages()) != 0 {
+		t.Fatalf("休眠后应清空上下文")
+	}
+}
+
+func TestConversationMemoryInterrupt(t *testing.T) {
+	m := newConversationMemory(6, 1000)
+	r := m.BeginReply("讲个故事")
+	m.ReplyText(r, "从前有座山，山里有座庙，庙里有个老和尚。")
+	m.ReplyAudio(r, 1000)
+	m.CommitReply(r)
+	m.ReplyPlayed(r, 500)
+	m.Interrupt()
+	msgs := m.Messages()
+	if len(msgs) != 2 || msgs[1].Content != "从前有座山，……" {
+		t.Fatalf("打断后应只记录已播出的部分，got=%+v", msgs)
+	}
+
+	// 还没出声就被打断：整轮丢弃
+	r = m.BeginReply("今天天气怎么样")
+	m.ReplyText(r, "今天晴。")
+	m.Interrupt()
+	m.CommitReply(r)
+	if len(m.Messages()) != 2 {
+		t.Fatalf("未播出的回复不应记录，got=%+v", m.Messages())
+	}
+
+	// 正常播完后的打断不影响已记录内容
+	r = m.BeginReply("你好")
+	m.ReplyText(r, "你好呀。")
+	m.ReplyAudio(r, 100)
+	m.ReplyPlayed(r, 100)
+	m.CommitReply(r)
+	m.PlaybackDone(r)
+	m.Interrupt()
+	if msgs := m.Messages(); len(msgs) != 4 || msgs[3].Content != "你好呀。" {
+		t.Fatalf("播完的回复不应被截断，got=%+v", msgs)
+	}
+
+	// 送进播放器的只有一半真正播出去了（另一半还在混音缓冲里）
+	m = newConversationMemory(6, 1000)
+	r = m.BeginReply("讲个笑话")
+	m.ReplyText(r, "小明上学迟到了，老师问他为什么。")
+	m.ReplyAudio(r, 1000)
+	m.ReplyPlayed(r, 1000)
+	m.ReplyUnplayed(500)
+	m.Interrupt()
+	m.CommitReply(r)
+	if msgs := m.Messages(); len(msgs) != 2 || msgs[1].Content != "小明上学迟到了，……" {
+		t.Fatalf("缓冲里没播出的部分不应记录，got=%+v", msgs)
+	}
+}
+
+func TestConversationMemoryStaleReply(t *testing.T) {
+	m := newConversationMemory(6, 1000)
+	old := m.BeginReply("讲个故事")
+	m.ReplyText(old, "从前有座山，山里有座庙。")
+	m.ReplyAudio(old, 1000)
+	m.ReplyPlayed(old, 500)
+	m.Interrupt()
+
+	// 新一轮开始时，被打断的旧一轮按已播出的部分写入；之后旧句柄的进度和写入都不算数
+	cur := m.BeginReply("现在几点")
+	m.ReplyAudio(old, 1000)
+	m.ReplyPlayed(old, 1000)
+	m.CommitReply(old)
+	m.ReplyAudio(nil, 100) // 不属于任何一轮的播报（唤醒应答、报时）
+	m.ReplyText(cur, "三点了。")
+	m.CommitReply(cur)
+	msgs := m.Messages()
+	if len(msgs) != 4 || msgs[1].Content != "从前有座山，……" || msgs[3].Content != "三点了。" {
+		t.Fatalf("旧句柄不应影响新一轮，got=%+v", msgs)
+	}
+}
+
+func TestMusicManagerPauseResume(t *testing.T) {
+	oldOutDir := replayOutputDir
+	defer func() { replayOutputDir = oldOutDir }()
+	replayOutputDir = t.TempDir()
+
+	src := make([]int16, 16000)
+	for i := range src {
+		src[i] = int16(i % 20000)
+	}
+	path := filepath.Join(t.TempDir(), "song.wav")
+	writeTestWAV(t, path, 16000, 1, src)
+
+	m := NewMusicManager()
+	if err := m.PlayFile(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if !m.Pause() || !m.IsPaused() || m.IsPlaying() || m.CurrentSongPath() != path {
+		t.Fatal("暂停后应保留当前曲目")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !m.Resume() || m.Resume() {
+		t.Fatal("应只恢复一次")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.IsPlaying() {
+		if time.Now().After(deadline) {
+			t.Fatal("恢复后没有播完")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// 暂停前后两段输出首尾相接，正好是整首歌：没有从头重放，也没有丢样本
+	outs, _ := filepath.Glob(filepath.Join(replayOutputDir, "music-*.wav"))
+	sort.Strings(outs)
+	if len(outs) != 2 {
+		t.Fatalf("应输出暂停前后两段，got %v", outs)
+	}
+	var got []int16
+	for _, out := range outs {
+		f, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, samples, err := readWAV(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, samples...)
+	}
+	if len(got) != len(src) {
+		t.Fatalf("样本数=%d want %d", len(got), len(src))
+	}
+	for i := range src {
+		if got[i] != src[i] {
+			t.Fatalf("第 %d 个样本=%d want %d", i, got[i], src[i])
+		}
+	}
+}
+
+func TestMusicManagerPausedAtEnd(t *testing.T) {
+	m := NewMusicManager()
+	pauseCh := make(chan struct{}, 1)
+	m.paused = true
+	m.pauseChan = pauseCh
+	m.currentPath = "a.wav"
+	m.queue = newPlayQueue([]string{"a.wav", "b.wav"}, PlayRepeatAll)
+
+	// 暂停恰好赶上读到结尾：清掉暂停状态，不接下一首
+	if q, end := m.trackEnded(nil, pauseCh); end != trackPausedAtEnd || q != nil {
+		t.Fatalf("end=%v q=%v, want trackPausedAtEnd 且不返回队列", end, q)
+	}
+	if m.IsPaused() || m.IsPlaying() || m.CurrentSongPath() != "" {
+		t.Fatalf("应停在暂停处: paused=%v playing=%v path=%q", m.IsPaused(), m.IsPlaying(), m.CurrentSongPath())
+	}
+	// 已经结算过的协程再次读到结尾与当前播放无关
+	if _, end := m.trackEnded(nil, pauseCh); end != trackSuperseded {
+		t.Fatalf("end=%v, want trackSuperseded", end)
+	}
+}
@@ -0,0 +1,552 @@
+// Package mockdash 本地模拟的 DashScope 服务，供集成测试使用。
+//
+// 说明：
+//   - 同一个 websocket 入口按 run-task 里的 task 区分 ASR（asr/recognition）与 TTS（tts/SpeechSynthesizer），
+//     协议与线上一致：run-task -> task-started -> result-generated/二进制 PCM -> task-finished/task-failed；
+//   - 文本生成支持 DashScope 原生 SSE（output.choices[].message）与 OpenAI 兼容 SSE（choices[].delta）；
+//   - 每类服务各有一个剧本队列，按请求顺序取用，队列空时使用默认剧本；
+//     剧本可设置首包延迟、中途断连、错误事件等异常情况。
+package mockdash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 与线上一致的路径
+const (
+	WSPath     = "/api-ws/v1/inference/"
+	LLMPath    = "/api/v1/services/aigc/text-generation/generation"
+	CompatPath = "/compatible-mode/v1/chat/completions"
+)
+
+// ---------- 剧本 ----------
+
+// ASRSentence 一条识别结果
+type ASRSentence struct {
+	Text  string
+	End   bool          // sentence_end=true，该句已定稿
+	Delay time.Duration // 下发前等待
+}
+
+// ASRScript 一次识别任务的剧本：收到第一帧音频（或 finish-task）后依次下发 Sentences，
+// 收到 finish-task 且结果发完后回 task-finished
+type ASRScript struct {
+	StartDelay time.Duration // run-task 后多久回 task-started
+	Sentences  []ASRSentence
+	FailWith   string // 非空时结果发完后回 task-failed（error_message）
+	Disconnect bool   // 结果发完后直接断开连接，不回 task-finished
+}
+
+// TTSScript 一次合成任务的剧本：每条 continue-task 文本回一帧 PCM
+type TTSScript struct {
+	StartDelay       time.Duration // run-task 后多久回 task-started
+	FirstPacketDelay time.Duration // 第一帧音频前额外等待（模拟首包慢）
+	BytesPerRune     int           // 每个字对应的 PCM 字节数，默认 640（16k 下 20ms）
+	FailOnStart      string        // 非空时不回 task-started，直接 task-failed
+	FailAfter        int           // >0：第 N 条文本的音频发出后回 task-failed
+	DisconnectAfter  int           // >0：第 N 条文本的音频发出后直接断开
+	FailMessage      string        // FailAfter 时的 error_message
+}
+
+// LLMScript 一次文本生成的剧本
+type LLMScript struct {
+	Status     int           // 非 0 且非 200 时直接返回该 HTTP 状态码
+	FirstDelay time.Duration // 第一个分片前等待
+	ChunkDelay time.Duration // 分片之间等待
+	Chunks     []string      // 增量文本
+	ToolCalls  []ToolCall    // 文本之后下发的工具调用
+	ErrorEvent string        // 非空时分片发完后下发 event:error
+	Disconnect bool          // 分片发完后直接断开（不发结束标记）
+}
+
+// ToolCall 剧本里的工具调用
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ---------- 请求记录 ----------
+
+// ASRRequest 收到的一次识别任务
+type ASRRequest struct {
+	Model      string
+	SampleRate int
+	AudioBytes int
+	Finished   bool // 收到了 finish-task
+}
+
+// TTSRequest 收到的一次合成任务
+type TTSRequest struct {
+	Model      string
+	Voice      string
+	SampleRate int
+	Texts      []string
+	Finished   bool
+}
+
+// LLMRequest 收到的一次文本生成请求（原始 JSON）
+type LLMRequest struct {
+	Path          string
+	Authorization string
+	Body          map[string]interface{}
+}
+
+// Messages 请求里的对话消息（兼容两种格式）
+func (r LLMRequest) Messages() []map[string]interface{} {
+	raw, ok := r.Body["messages"]
+	if !ok {
+		if input, _ := r.Body["input"].(map[string]interface{}); input != nil {
+			raw = input["messages"]
+		}
+	}
+	list, _ := raw.([]interface{})
+	out := make([]map[string]interface{}, 0, len(list))
+	for _, m := range list {
+		if mm, ok := m.(map[string]interface{}); ok {
+			out = append(out, mm)
+		}
+	}
+	return out
+}
+
+// ---------- Server ----------
+
+// Server 模拟服务；URL 由 httptest 分配
+type Server struct {
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu         sync.Mutex
+	asrQueue   []ASRScript
+	ttsQueue   []TTSScript
+	llmQueue   []LLMScript
+	DefaultASR ASRScript
+	DefaultTTS TTSScript
+	DefaultLLM LLMScript
+
+	asrReqs []*ASRRequest
+	ttsReqs []*TTSRequest
+	llmReqs []LLMRequest
+}
+
+// New 启动模拟服务
+func New() *Server {
+	s := &Server{
+		DefaultTTS: TTSScript{},
+		DefaultLLM: LLMScript{Chunks: []string{"好的。"}},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(WSPath, s.handleWS)
+	mux.HandleFunc(LLMPath, s.handleLLM)
+	mux.HandleFunc(CompatPath, s.handleLLM)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// Close 关闭服务
+func (s *Server) Close() { s.srv.Close() }
+
+// WSURL ASR/TTS websocket 地址（对应 AI_BOX_ASR_WS_URL / AI_BOX_TTS_WS_URL）
+func (s *Server) WSURL() string { return "ws" + strings.TrimPrefix(s.srv.URL, "http") + WSPath }
+
+// LLMURL DashScope 原生文本生成地址（对应 AI_BOX_LLM_URL）
+func (s *Server) LLMURL() string { return s.srv.URL + LLMPath }
+
+// CompatURL OpenAI 兼容地址
+func (s *Server) CompatURL() string { return s.srv.URL + CompatPath }
+
+// QueueASR 追加识别剧本
+func (s *Server) QueueASR(scripts ...ASRScript) {
+	s.mu.Lock()
+	s.asrQueue = append(s.asrQueue, scripts...)
+	s.mu.Unlock()
+}
+
+// QueueTTS 追加合成剧本
+func (s *Server) QueueTTS(scripts ...TTSScript) {
+	s.mu.Lock()
+	s.ttsQueue = append(s.ttsQueue, scripts...)
+	s.mu.Unlock()
+}
+
+// QueueLLM 追加文本生成剧本
+func (s *Server) QueueLLM(scripts ...LLMScript) {
+	s.mu.Lock()
+	s.llmQueue = append(s.llmQueue, scripts...)
+	s.mu.Unlock()
+}
+
+// ASRRequests 已收到的识别任务（副本）
+func (s *Server) ASRRequests() []ASRRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ASRRequest, len(s.asrReqs))
+	for i, r := range s.asrReqs {
+		out[i] = *r
+	}
+	return out
+}
+
+// TTSRequests 已收到的合成任务（副本）
+func (s *Server) TTSRequests() []TTSRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]TTSRequest, len(s.ttsReqs))
+	for i, r := range s.ttsReqs {
+		out[i] = *r
+		out[i].Texts = append([]string(nil), r.Texts...)
+	}
+	return out
+}
+
+// LLMRequests 已收到的文本生成请求
+func (s *Server) LLMRequests() []LLMRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LLMRequest(nil), s.llmReqs...)
+}
+
+// Reset 清空剧本队列与请求记录
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.asrQueue, s.ttsQueue, s.llmQueue = nil, nil, nil
+	s.asrReqs, s.ttsReqs, s.llmReqs = nil, nil, nil
+	s.mu.Unlock()
+}
+
+func (s *Server) nextASR() ASRScript {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.asrQueue) == 0 {
+		return s.DefaultASR
+	}
+	sc := s.asrQueue[0]
+	s.asrQueue = s.asrQueue[1:]
+	return sc
+}
+
+func (s *Server) nextTTS() TTSScript {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ttsQueue) == 0 {
+		return s.DefaultTTS
+	}
+	sc := s.ttsQueue[0]
+	s.ttsQueue = s.ttsQueue[1:]
+	return sc
+}
+
+func (s *Server) nextLLM() LLMScript {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.llmQueue) == 0 {
+		return s.DefaultLLM
+	}
+	sc := s.llmQueue[0]
+	s.llmQueue = s.llmQueue[1:]
+	return sc
+}
+
+// ---------- websocket ----------
+
+type wsMessage struct {
+	Header struct {
+		TaskID string `json:"task_id"`
+		Action string `json:"action"`
+	} `json:"header"`
+	Payload struct {
+		Task       string `json:"task"`
+		Function   string `json:"function"`
+		Model      string `json:"model"`
+		Parameters struct {
+			Voice      string `json:"voice"`
+			SampleRate int    `json:"sample_rate"`
+		} `json:"parameters"`
+		Input struct {
+			Text string `json:"text"`
+		} `json:"input"`
+	} `json:"payload"`
+}
+
+// wsConn 串行化写操作（读循环与剧本 goroutine 都会写）
+type wsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *wsConn) event(taskID, event string, extra map[string]interface{}, payload interface{}) error {
+	header := map[string]interface{}{"task_id": taskID, "event": event}
+	for k, v := range extra {
+		header[k] = v
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(map[string]interface{}{"header": header, "payload": payload})
+}
+
+func (c *wsConn) binary(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	c := &wsConn{conn: conn}
+
+	var start wsMessage
+	if err := conn.ReadJSON(&start); err != nil || start.Header.Action != "run-task" {
+		return
+	}
+	switch start.Payload.Task {
+	case "asr":
+		s.serveASR(c, start)
+	case "tts":
+		s.serveTTS(c, start)
+	default:
+		c.event(start.Header.TaskID, "task-failed", map[string]interface{}{"error_code": "InvalidParameter", "error_message": "unknown task: " + start.Payload.Task}, map[string]interface{}{})
+	}
+}
+
+func (s *Server) serveASR(c *wsConn, start wsMessage) {
+	sc := s.nextASR()
+	req := &ASRRequest{Model: start.Payload.Model, SampleRate: start.Payload.Parameters.SampleRate}
+	s.mu.Lock()
+	s.asrReqs = append(s.asrReqs, req)
+	s.mu.Unlock()
+	taskID := start.Header.TaskID
+
+	time.Sleep(sc.StartDelay)
+	if c.event(taskID, "task-started", nil, map[string]interface{}{}) != nil {
+		return
+	}
+
+	firstAudio := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		var audioOnce, finishOnce sync.Once
+		defer audioOnce.Do(func() { close(firstAudio) })
+		defer finishOnce.Do(func() { close(finished) })
+		for {
+			typ, msg, err := c.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if typ == websocket.BinaryMessage {
+				s.mu.Lock()
+				req.AudioBytes += len(msg)
+				s.mu.Unlock()
+				audioOnce.Do(func() { close(firstAudio) })
+				continue
+			}
+			var m wsMessage
+			if json.Unmarshal(msg, &m) == nil && m.Header.Action == "finish-task" {
+				s.mu.Lock()
+				req.Finished = true
+				s.mu.Unlock()
+				audioOnce.Do(func() { close(firstAudio) })
+				finishOnce.Do(func() { close(finished) })
+			}
+		}
+	}()
+
+	<-firstAudio
+	var beginMs int64
+	for _, sent := range sc.Sentences {
+		time.Sleep(sent.Delay)
+		sentence := map[string]interface{}{"begin_time": beginMs, "text": sent.Text, "sentence_end": sent.End, "end_time": nil}
+		if sent.End {
+			beginMs += 1000
+			sentence["end_time"] = beginMs
+		}
+		if c.event(taskID, "result-generated", nil, map[string]interface{}{"output": map[string]interface{}{"sentence": sentence}}) != nil {
+			return
+		}
+	}
+	if sc.Disconnect {
+		return
+	}
+	if sc.FailWith != "" {
+		c.event(taskID, "task-failed", map[string]interface{}{"error_code": "ClientError", "error_message": sc.FailWith}, map[string]interface{}{})
+		return
+	}
+	<-finished
+	c.event(taskID, "task-finished", nil, map[string]interface{}{"output": map[string]interface{}{}})
+}
+
+func (s *Server) serveTTS(c *wsConn, start wsMessage) {
+	sc := s.nextTTS()
+	req := &TTSRequest{Model: start.Payload.Model, Voice: start.Payload.Parameters.Voice, SampleRate: start.Payload.Parameters.SampleRate}
+	s.mu.Lock()
+	s.ttsReqs = append(s.ttsReqs, req)
+	s.mu.Unlock()
+	taskID := start.Header.TaskID
+
+	if sc.FailOnStart != "" {
+		c.event(taskID, "task-failed", map[string]interface{}{"error_code": "InvalidParameter", "error_message": sc.FailOnStart}, map[string]interface{}{})
+		return
+	}
+	time.Sleep(sc.StartDelay)
+	if c.event(taskID, "task-started", nil, map[string]interface{}{}) != nil {
+		return
+	}
+	perRune := sc.BytesPerRune
+	if perRune <= 0 {
+		perRune = 640
+	}
+
+	sent := 0
+	for {
+		var m wsMessage
+		if err := c.conn.ReadJSON(&m); err != nil {
+			return
+		}
+		switch m.Header.Action {
+		case "continue-task":
+			text := m.Payload.Input.Text
+			s.mu.Lock()
+			req.Texts = append(req.Texts, text)
+			s.mu.Unlock()
+			if sent == 0 {
+				time.Sleep(sc.FirstPacketDelay)
+			}
+			if c.binary(tone(len([]rune(text))*perRune, sent)) != nil {
+				return
+			}
+			sent++
+			if sc.DisconnectAfter > 0 && sent >= sc.DisconnectAfter {
+				return
+			}
+			if sc.FailAfter > 0 && sent >= sc.FailAfter {
+				msg := sc.FailMessage
+				if msg == "" {
+					msg = "synthesis failed"
+				}
+				c.event(taskID, "task-failed", map[string]interface{}{"error_code": "InternalError", "error_message": msg}, map[string]interface{}{})
+				return
+			}
+		case "finish-task":
+			s.mu.Lock()
+			req.Finished = true
+			s.mu.Unlock()
+			c.event(taskID, "task-finished", nil, map[string]interface{}{"output": map[string]interface{}{}})
+			return
+		}
+	}
+}
+
+// tone 生成 n 字节（偶数）PCM16；内容非零，便于确认音频确实落到了输出端
+func tone(n, seed int) []byte {
+	n &^= 1
+	b := make([]byte, n)
+	for i := 0; i+1 < n; i += 2 {
+		v := int16(((i/2+seed*7)%32 - 16) * 256)
+		b[i] = byte(v)
+		b[i+1] = byte(uint16(v) >> 8)
+	}
+	return b
+}
+
+// ---------- SSE ----------
+
+func (s *Server) handleLLM(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := LLMRequest{Path: r.URL.Path, Authorization: r.Header.Get("Authorization")}
+	_ = json.Unmarshal(body, &req.Body)
+	s.mu.Lock()
+	s.llmReqs = append(s.llmReqs, req)
+	s.mu.Unlock()
+
+	sc := s.nextLLM()
+	if sc.Status != 0 && sc.Status != http.StatusOK {
+		http.Error(w, fmt.Sprintf(`{"code":"MockError","message":"status %d"}`, sc.Status), sc.Status)
+		return
+	}
+	compat := r.URL.Path == CompatPath
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+
+	seq := 0
+	send := func(delta map[string]interface{}, finish string) {
+		var chunk map[string]interface{}
+		if compat {
+			choice := map[string]interface{}{"index": 0, "delta": delta}
+			if finish != "" {
+				choice["finish_reason"] = finish
+			}
+			chunk = map[string]interface{}{"choices": []interface{}{choice}}
+		} else {
+			delta["role"] = "assistant"
+			choice := map[string]interface{}{"message": delta, "finish_reason": "null"}
+			if finish != "" {
+				choice["finish_reason"] = finish
+			}
+			chunk = map[string]interface{}{"output": map[string]interface{}{"choices": []interface{}{choice}}}
+		}
+		seq++
+		b, _ := json.Marshal(chunk)
+		if compat {
+			fmt.Fprintf(w, "data: %s\n\n", b)
+		} else {
+			fmt.Fprintf(w, "id:%d\nevent:result\n:HTTP_STATUS/200\ndata:%s\n\n", seq, b)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	time.Sleep(sc.FirstDelay)
+	for i, text := range sc.Chunks {
+		if i > 0 {
+			time.Sleep(sc.ChunkDelay)
+		}
+		send(map[string]interface{}{"content": text}, "")
+	}
+	for i, tc := range sc.ToolCalls {
+		id := tc.ID
+		if id == "" {
+			id = fmt.Sprintf("call_mock_%d", i)
+		}
+		send(map[string]interface{}{"content": "", "tool_calls": []interface{}{map[string]interface{}{
+			"index": i, "id": id, "type": "function",
+			"function": map[string]interface{}{"name": tc.Name, "arguments": tc.Arguments},
+		}}}, "")
+	}
+	if sc.Disconnect {
+		// 不发结束分片，直接掐断 TCP 连接
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+		return
+	}
+	if sc.ErrorEvent != "" {
+		b, _ := json.Marshal(map[string]interface{}{"code": "InternalError", "message": sc.ErrorEvent})
+		fmt.Fprintf(w, "event:error\n:HTTP_STATUS/500\ndata:%s\n\n", b)
+		return
+	}
+	finish := "stop"
+	if len(sc.ToolCalls) > 0 {
+		finish = "tool_calls"
+	}
+	send(map[string]interface{}{"content": ""}, finish)
+	if compat {
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ai_box/mockdash"
+)
+
+// useMockDashScope 起一个本地模拟服务，把三路后端指过去。
+// 只改这几个全局量，不重跑 initRuntimeConfig：流水线协程已在运行，重新初始化会和它们读配置产生竞争
+func useMockDashScope(t *testing.T) *mockdash.Server {
+	t.Helper()
+	srv := mockdash.New()
+	t.Cleanup(srv.Close)
+
+	oldKey, oldLLMKey := dashAPIKey, llmAPIKey
+	oldASRURL, oldTTSURL, oldLLMURL := asrWsURL, ttsWsURL, llmURL
+	oldASR, oldTTS, oldLLM, oldRate, oldOutDir := asrEngine, ttsEngine, llmClient, ttsSampleRate, replayOutputDir
+	t.Cleanup(func() {
+		dashAPIKey, llmAPIKey = oldKey, oldLLMKey
+		asrWsURL, ttsWsURL, llmURL = oldASRURL, oldTTSURL, oldLLMURL
+		asrEngine, ttsEngine, llmClient, ttsSampleRate, replayOutputDir = oldASR, oldTTS, oldLLM, oldRate, oldOutDir
+	})
+
+	dashAPIKey, llmAPIKey = "mock-key", "mock-key"
+	asrWsURL, ttsWsURL, llmURL = srv.WSURL(), srv.WSURL(), srv.LLMURL()
+	ttsSampleRate = 16000
+	replayOutputDir = t.TempDir()
+
+	asrEngine = dashScopeASR{}
+	ttsEngine = dashScopeTTS{}
+	llmClient = initLLMClient()
+	pipelineOnce.Do(startPipeline)
+	return srv
+}
+
+// speakAndWait 走 ttsManagerLoop 播报一组文本，返回是否收到播报结束信号
+func speakAndWait(texts ...string) bool {
+	resetSessionForTTS()
+	drainTTSDone()
+	for _, text := range texts {
+		ttsManagerChan <- ttsMessage{text: text}
+	}
+	ttsManagerChan <- ttsMessage{text: "[[END]]"}
+	ok := waitTTSDone(5 * time.Second)
+	waitPipelineIdle(5 * time.Second)
+	return ok
+}
+
+// ttsOutputBytes 输出目录里 TTS 文件的 PCM 字节总数
+func ttsOutputBytes(t *testing.T) int64 {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(replayOutputDir, "tts-*.wav"))
+	var n int64
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += st.Size() - 44
+	}
+	return n
+}
+
+func TestMockDashScopeASR(t *testing.T) {
+	srv := useMockDashScope(t)
+	audio := make([]byte, 16000*2) // 1s 静音，识别结果由剧本决定
+
+	srv.QueueASR(mockdash.ASRScript{
+		StartDelay: 100 * time.Millisecond,
+		Sentences: []mockdash.ASRSentence{
+			{Text: "今天"},
+			{Text: "今天天气怎么样", End: true, Delay: 50 * time.Millisecond},
+			{Text: "明天"},
+		},
+	})
+	text, err := callASRWebSocket(audio)
+	if err != nil || text != "今天天气怎么样明天" {
+		t.Fatalf("text=%q err=%v", text, err)
+	}
+	reqs := srv.ASRRequests()
+	if len(reqs) != 1 || reqs[0].AudioBytes != len(audio) || !reqs[0].Finished || reqs[0].SampleRate != asrSampleRate || reqs[0].Model != asrModel {
+		t.Fatalf("服务端收到的识别任务不符: %+v", reqs)
+	}
+
+	// task-failed：返回错误，但保留已定稿的文本
+	srv.QueueASR(mockdash.ASRScript{
+		Sentences: []mockdash.ASRSentence{{Text: "播放音乐", End: true}},
+		FailWith:  "audio format invalid",
+	})
+	text, err = callASRWebSocket(audio)
+	if err == nil || !strings.Contains(err.Error(), "audio format invalid") || text != "播放音乐" {
+		t.Fatalf("task-failed: text=%q err=%v", text, err)
+	}
+
+	// 中途断连
+	srv.QueueASR(mockdash.ASRScript{
+		Sentences:  []mockdash.ASRSentence{{Text: "停"}},
+		Disconnect: true,
+	})
+	text, err = callASRWebSocket(audio)
+	if err == nil || text != "停" {
+		t.Fatalf("断连: text=%q err=%v", text, err)
+	}
+}
+
+func TestMockDashScopeTTS(t *testing.T) {
+	srv := useMockDashScope(t)
+
+	// 首包慢：task-started 和第一帧音频都延迟，仍应完整播完
+	srv.QueueTTS(mockdash.TTSScript{StartDelay: 200 * time.Millisecond, FirstPacketDelay: 300 * time.Millisecond})
+	if !speakAndWait("你好。", "今天晴。") {
+		t.Fatal("首包慢时未收到播报结束信号")
+	}
+	reqs := srv.TTSRequests()
+	if len(reqs) != 1 || !reqs[0].Finished || strings.Join(reqs[0].Texts, "|") != "你好。|今天晴。" || reqs[0].SampleRate != 16000 {
+		t.Fatalf("服务端收到的合成任务不符: %+v", reqs)
+	}
+	if n := ttsOutputBytes(t); n != 7*640 {
+		t.Fatalf("输出 PCM 字节数 = %d, want %d", n, 7*640)
+	}
+
+	// 合成中途 task-failed / 断连：已合成的部分照常播出，且不能卡住 waitTTSDone
+	for _, sc := range []mockdash.TTSScript{{FailAfter: 1, FailMessage: "quota exceeded"}, {DisconnectAfter: 1}} {
+		srv.Reset()
+		before := ttsOutputBytes(t)
+		srv.QueueTTS(sc)
+		if !speakAndWait("第一句。", "第二句。", "第三句。") {
+			t.Fatalf("%+v: 未收到播报结束信号", sc)
+		}
+		if got := ttsOutputBytes(t) - before; got != 4*640 {
+			t.Fatalf("%+v: 应只播出第一句，got %d 字节", sc, got)
+		}
+	}
+
+	// 建连即失败：回退到备用引擎
+	srv.Reset()
+	ttsEngine = &fallbackTTS{primary: dashScopeTTS{}, fallback: beepTTS{}}
+	srv.QueueTTS(mockdash.TTSScript{FailOnStart: "voice not found"})
+	before := ttsOutputBytes(t)
+	if !speakAndWait("回退测试。") {
+		t.Fatal("回退后未收到播报结束信号")
+	}
+	if got := ttsOutputBytes(t) - before; got != 16000/20*2 {
+		t.Fatalf("应由备用引擎合成，got %d 字节", got)
+	}
+}
+
+func TestMockDashScopeCallAgentStream(t *testing.T) {
+	srv := useMockDashScope(t)
+	oldVolume := deviceVolume
+	deviceVolume = newSoftwareVolume()
+	defer func() {
+		deviceVolume = oldVolume
+		currentMixer().SetMasterGain(1)
+	}()
+
+	// runAgent 按剧本跑一轮 callAgentStream，返回本轮送去合成的文本
+	runAgent := func(prompt string, scripts ...mockdash.LLMScript) []string {
+		t.Helper()
+		srv.Reset()
+		srv.QueueLLM(scripts...)
+		ctx := assistant.NewSession()
+		callAgentStream(ctx, prompt, false)
+		if !waitTTSDone(5 * time.Second) {
+			t.Fatalf("%s: 未收到播报结束信号", prompt)
+		}
+		waitPipelineIdle(5 * time.Second)
+		var texts []string
+		for _, r := range srv.TTSRequests() {
+			texts = append(texts, r.Texts...)
+		}
+		return texts
+	}
+
+	// 工具调用：第一轮返回 tool_calls，结果回传后第二轮给出确认话术
+	texts := runAgent("音量小一点",
+		mockdash.LLMScript{ToolCalls: []mockdash.ToolCall{{Name: "set_volume", Arguments: `{"level":30}`}}},
+		mockdash.LLMScript{FirstDelay: 200 * time.Millisecond, Chunks: []string{"好的，", "音量调到三十了。"}},
+	)
+	if strings.Join(texts, "|") != "好的，|音量调到三十了。" {
+		t.Fatalf("工具调用后的播报 = %q", texts)
+	}
+	if v, _ := deviceVolume.Get(); v != 30 {
+		t.Fatalf("set_volume 未调整设备音量，volume=%d", v)
+	}
+	reqs := srv.LLMRequests()
+	if len(reqs) != 2 || reqs[0].Authorization != "Bearer mock-key" {
+		t.Fatalf("LLM 请求数/鉴权不符: %+v", reqs)
+	}
+	msgs := reqs[1].Messages()
+	if last := msgs[len(msgs)-1]; last["role"] != "tool" || last["name"] != "set_volume" {
+		t.Fatalf("第二轮应带上工具结果，got %v", last)
+	}
+
+	// 首包慢 + 中途断连：已经生成的内容照常播完，不插入报错
+	texts = runAgent("讲个故事", mockdash.LLMScript{
+		FirstDelay: 300 * time.Millisecond,
+		ChunkDelay: 50 * time.Millisecond,
+		Chunks:     []string{"从前有座山，", "山里有座庙"},
+		Disconnect: true,
+	})
+	if strings.Join(texts, "|") != "从前有座山，|山里有座庙" {
+		t.Fatalf("断连后的播报 = %q", texts)
+	}
+
+	// HTTP 错误 / SSE 错误事件：什么都没说时播报兜底话术
+	for _, sc := range []mockdash.LLMScript{{Status: 500}, {ErrorEvent: "model overloaded"}} {
+		texts = runAgent("你好", sc)
+		if strings.Join(texts, "|") != llmErrorText {
+			t.Fatalf("%+v: 应播报兜底话术，got %q", sc, texts)
+		}
+	}
+}
+
+// gatedLLM 每个输入先通知 started，等 release 放行后才出字；ctx 取消后返回
+type gatedLLM struct {
+	started map[string]chan struct{}
+	release map[string]chan struct{}
+	text    map[string]string
+}
+
+func (l *gatedLLM) Name() string { return "gated" }
+
+func (l *gatedLLM) Stream(ctx context.Context, req ChatRequest, onDelta LLMDeltaFunc) ([]ToolCall, error) {
+	user := req.Messages[len(req.Messages)-1].Content
+	close(l.started[user])
+	<-l.release[user]
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	onDelta(l.text[user])
+	return nil, nil
+}
+
+func TestCallAgentStreamOverlap(t *testing.T) {
+	useMockDashScope(t)
+	chatMemory.Clear()
+	defer chatMemory.Clear()
+	llm := &gatedLLM{
+		started: map[string]chan struct{}{"讲个故事": make(chan struct{}), "现在几点": make(chan struct{})},
+		release: map[string]chan struct{}{"讲个故事": make(chan struct{}), "现在几点": make(chan struct{})},
+		text:    map[string]string{"现在几点": "三点了。"},
+	}
+	llmClient = llm
+
+	// 旧一轮被打断后迟迟不返回，新一轮已经 BeginReply；旧一轮这时才返回并 CommitReply
+	oldDone := make(chan struct{})
+	go func() {
+		callAgentStream(assistant.NewSession(), "讲个故事", false)
+		close(oldDone)
+	}()
+	<-llm.started["讲个故事"]
+	chatMemory.Interrupt()
+	newDone := make(chan struct{})
+	go func() {
+		callAgentStream(assistant.NewSession(), "现在几点", false)
+		close(newDone)
+	}()
+	<-llm.started["现在几点"]
+	close(llm.release["讲个故事"])
+	<-oldDone
+	close(llm.release["现在几点"])
+	<-newDone
+	waitTTSDone(5 * time.Second)
+	waitPipelineIdle(5 * time.Second)
+
+	msgs := chatMemory.Messages()
+	if len(msgs) != 2 || msgs[0].Content != "现在几点" || msgs[1].Content != "三点了。" {
+		t.Fatalf("旧一轮迟到的 CommitReply 不应提前写入新一轮，got=%+v", msgs)
+	}
+}
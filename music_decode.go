@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+)
+
+// ================= 音乐解码 =================
+// 说明：
+// - 曲库里的 WAV/MP3/FLAC 都在进程内解码，不依赖外部播放器；
+// - 解码结果统一混成单声道并重采样到 musicSampleRate，MusicManager 的音量平滑/Duck 只需处理一种格式。
+
+// musicSampleRate 音乐输出采样率（与 aplay 打开的格式一致）
+const musicSampleRate = 16000
+
+// musicExts 曲库支持的文件后缀
+var musicExts = []string{".wav", ".mp3", ".flac"}
+
+func isMusicFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range musicExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// pcmFrameSource 某种格式的解码器：按块吐出交织 PCM16
+type pcmFrameSource interface {
+	// ReadFrames 返回下一块交织采样，结束时返回 io.EOF
+	ReadFrames() ([]int16, error)
+	SampleRate() int
+	Channels() int
+}
+
+// musicStream 把任意解码器包装成 musicSampleRate 单声道 PCM16 字节流
+type musicStream struct {
+	closer  io.Closer
+	src     pcmFrameSource
+	res     *linearResampler
+	pending []byte
+	err     error
+}
+
+// openMusicStream 按后缀选择解码器
+func openMusicStream(path string) (*musicStream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := newPCMFrameSource(f, strings.ToLower(filepath.Ext(path)))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return newMusicStream(src, f), nil
+}
+
+// checkMusicFile 打开并解析文件头，确认能解码后再播报“正在播放”
+func checkMusicFile(path string) error {
+	s, err := openMusicStream(path)
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+func newPCMFrameSource(r io.Reader, ext string) (pcmFrameSource, error) {
+	switch ext {
+	case ".wav":
+		return newWAVFrameSource(r)
+	case ".mp3":
+		return newMP3FrameSource(r)
+	case ".flac":
+		return newFLACFrameSource(r)
+	}
+	return nil, fmt.Errorf("%w: %q", errUnsupportedAudio, ext)
+}
+
+func newMusicStream(src pcmFrameSource, closer io.Closer) *musicStream {
+	return &musicStream{
+		closer: closer,
+		src:    src,
+		res:    newLinearResampler(src.SampleRate(), musicSampleRate),
+	}
+}
+
+// Read 实现 io.Reader，输出 musicSampleRate 单声道 PCM16（小端）
+func (s *musicStream) Read(p []byte) (int, error) {
+	for len(s.pending) < 2 && s.err == nil {
+		frames, err := s.src.ReadFrames()
+		if len(frames) > 0 {
+			mono := downmixInt16(frames, s.src.Channels())
+			s.pending = append(s.pending, pcmInt16ToBytes(s.res.Process(mono))...)
+		}
+		if err != nil {
+			s.err = err
+		}
+	}
+	if len(s.pending) == 0 {
+		return 0, s.err
+	}
+	// 始终按整采样输出，避免调用方拿到半个采样
+	n := copy(p, s.pending[:len(s.pending)/2*2])
+	n -= n % 2
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *musicStream) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// ---------- WAV ----------
+
+type wavFrameSource struct {
+	data   io.Reader
+	format wavFormat
+	buf    []byte
+}
+
+func newWAVFrameSource(r io.Reader) (*wavFrameSource, error) {
+	format, data, err := parseWAVHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &wavFrameSource{data: data, format: format, buf: make([]byte, 2048*format.FrameBytes())}, nil
+}
+
+func (w *wavFrameSource) ReadFrames() ([]int16, error) {
+	n, err := io.ReadFull(w.data, w.buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return decodeWAVSamples(w.format, w.buf[:n]), err
+}
+
+func (w *wavFrameSource) SampleRate() int { return w.format.Rate }
+func (w *wavFrameSource) Channels() int   { return w.format.Channels }
+
+// ---------- MP3 ----------
+
+// mp3FrameSource go-mp3 固定输出 16bit 双声道
+type mp3FrameSource struct {
+	dec *mp3.Decoder
+	buf []byte
+}
+
+func newMP3FrameSource(r io.Reader) (*mp3FrameSource, error) {
+	dec, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("mp3 解码失败: %w", err)
+	}
+	return &mp3FrameSource{dec: dec, buf: make([]byte, 4608)}, nil
+}
+
+func (m *mp3FrameSource) ReadFrames() ([]int16, error) {
+	n, err := io.ReadFull(m.dec, m.buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return pcmBytesToInt16(m.buf[:n/4*4]), err
+}
+
+func (m *mp3FrameSource) SampleRate() int { return m.dec.SampleRate() }
+func (m *mp3FrameSource) Channels() int   { return 2 }
+
+// ---------- FLAC ----------
+
+type flacFrameSource struct {
+	stream *flac.Stream
+}
+
+func newFLACFrameSource(r io.Reader) (*flacFrameSource, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("flac 解码失败: %w", err)
+	}
+	if stream.Info.NChannels == 0 || stream.Info.SampleRate == 0 {
+		return nil, fmt.Errorf("flac 流信息异常: %d 声道 %dHz", stream.Info.NChannels, stream.Info.SampleRate)
+	}
+	return &flacFrameSource{stream: stream}, nil
+}
+
+func (f *flacFrameSource) ReadFrames() ([]int16, error) {
+	fr, err := f.stream.ParseNext()
+	if err != nil {
+		return nil, err
+	}
+	bps := int(fr.BitsPerSample)
+	if bps == 0 {
+		bps = int(f.stream.Info.BitsPerSample)
+	}
+	channels := len(fr.Subframes)
+	out := make([]int16, int(fr.BlockSize)*channels)
+	for c, sub := range fr.Subframes {
+		for i, v := range sub.Samples {
+			// 统一缩放到 16bit
+			if bps > 16 {
+				v >>= uint(bps - 16)
+			} else if bps < 16 {
+				v <<= uint(16 - bps)
+			}
+			out[i*channels+c] = int16(v)
+		}
+	}
+	return out, nil
+}
+
+func (f *flacFrameSource) SampleRate() int { return int(f.stream.Info.SampleRate) }
+func (f *flacFrameSource) Channels() int   { return int(f.stream.Info.NChannels) }
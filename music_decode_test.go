@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+// readAllSamples 读完整个 musicStream，返回 16k 单声道采样
+func readAllSamples(t *testing.T, s *musicStream) []int16 {
+	t.Helper()
+	var out bytes.Buffer
+	buf := make([]byte, 1281) // 故意用奇数长度，验证不会切出半个采样
+	for {
+		n, err := s.Read(buf)
+		if n%2 != 0 {
+			t.Fatalf("Read 返回了半个采样: n=%d", n)
+		}
+		out.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pcmBytesToInt16(out.Bytes())
+}
+
+func TestMusicStreamFLAC(t *testing.T) {
+	// 44.1kHz 24bit 双声道：左声道 440Hz 正弦，右声道静音，1 秒
+	const rate, bps, block = 44100, 24, 4096
+	var file bytes.Buffer
+	enc, err := flac.NewEncoder(&file, &meta.StreamInfo{
+		BlockSizeMin: block, BlockSizeMax: block,
+		SampleRate: rate, NChannels: 2, BitsPerSample: bps,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := 0; start < rate; start += block {
+		n := block
+		if start+n > rate {
+			n = rate - start
+		}
+		left := make([]int32, n)
+		for i := range left {
+			left[i] = int32(0.5 * (1 << (bps - 1)) * math.Sin(2*math.Pi*440*float64(start+i)/rate))
+		}
+		fr := &frame.Frame{
+			Header: frame.Header{HasFixedBlockSize: true, BlockSize: uint16(n), SampleRate: rate, Channels: frame.ChannelsLR, BitsPerSample: bps},
+			Subframes: []*frame.Subframe{
+				{SubHeader: frame.SubHeader{Pred: frame.PredVerbatim}, Samples: left, NSamples: n},
+				{SubHeader: frame.SubHeader{Pred: frame.PredVerbatim}, Samples: make([]int32, n), NSamples: n},
+			},
+		}
+		if err := enc.WriteFrame(fr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newPCMFrameSource(bytes.NewReader(file.Bytes()), ".flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := readAllSamples(t, newMusicStream(src, nil))
+	if n := len(samples); n < musicSampleRate-20 || n > musicSampleRate+20 {
+		t.Fatalf("1 秒音频重采样后应约 %d 点，got=%d", musicSampleRate, n)
+	}
+	// 24bit 半幅正弦 → 16bit 半幅；再与静音右声道平均 → 约 1/4 满幅
+	peak := 0
+	for _, v := range samples {
+		if a := int(math.Abs(float64(v))); a > peak {
+			peak = a
+		}
+	}
+	if peak < 7800 || peak > 8400 {
+		t.Fatalf("混音/位深换算异常，peak=%d", peak)
+	}
+}
+
+func TestMusicStreamMP3(t *testing.T) {
+	s, err := openMusicStream("testdata/speech.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	samples := readAllSamples(t, s)
+	// 60 帧 × 576 点 @22050Hz ≈ 1.57s
+	want := 60 * 576 * musicSampleRate / 22050
+	if n := len(samples); n < want-musicSampleRate/10 || n > want+musicSampleRate/10 {
+		t.Fatalf("MP3 解码长度异常，got=%d want≈%d", n, want)
+	}
+	var energy float64
+	for _, v := range samples {
+		energy += float64(v) * float64(v)
+	}
+	if energy == 0 {
+		t.Fatal("MP3 解码结果全为静音")
+	}
+}
+
+func TestMusicStreamWAV(t *testing.T) {
+	// 8kHz 双声道 PCM16，左右声道取平均后升采样到 16k
+	stereo := make([]int16, 8000*2)
+	for i := 0; i < 8000; i++ {
+		stereo[2*i], stereo[2*i+1] = 1000, 3000
+	}
+	path := filepath.Join(t.TempDir(), "stereo.wav")
+	writeTestWAV(t, path, 8000, 2, stereo)
+	s, err := openMusicStream(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	samples := readAllSamples(t, s)
+	if n := len(samples); n < 15990 || n > 16000 {
+		t.Fatalf("重采样长度异常，got=%d", n)
+	}
+	for _, v := range samples {
+		if v != 2000 {
+			t.Fatalf("双声道混音异常，got=%d", v)
+		}
+	}
+
+	if _, err := newPCMFrameSource(bytes.NewReader(nil), ".ogg"); err == nil {
+		t.Fatal("未知格式应返回错误")
+	}
+	if !isMusicFile("陈楚生《庙堂之外》.FLAC") || isMusicFile("cover.jpg") {
+		t.Fatal("曲库后缀识别异常")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+// ================= 拼音模糊匹配 =================
+// 说明：
+// - ASR 经常把歌名识别成同音/近音字（庙堂之外 → 妙堂之外），按汉字比对会直接落空；
+// - 这里把歌名和查询都转成拼音音节，按音节做编辑距离：同音字代价为 0，
+//   平翘舌（z/zh）、前后鼻音（n/ng）、n/l 这类近音代价减半，其余替换/增删代价为 1；
+// - 歌名可能只是查询的一部分（“陈楚生的妙堂之外”），反之亦然，所以算的是短串在长串中的最佳对齐。
+
+// musicFuzzyThreshold 音节相似度阈值（0~1），低于它不算命中；可用 AI_BOX_MUSIC_FUZZY_THRESHOLD 调整
+var musicFuzzyThreshold = 0.75
+
+var pinyinArgs = pinyin.NewArgs()
+
+// toSyllables 汉字逐字转拼音（不带声调），连续的字母数字作为一个音节，其余符号丢弃
+func toSyllables(s string) []string {
+	var out []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			out = append(out, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			if py := pinyin.SinglePinyin(r, pinyinArgs); len(py) > 0 {
+				out = append(out, py[0])
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return out
+}
+
+// looseSyllable 去掉容易混淆的区别：平翘舌、n/l、前后鼻音
+func looseSyllable(s string) string {
+	for _, p := range [][2]string{{"zh", "z"}, {"ch", "c"}, {"sh", "s"}, {"l", "n"}} {
+		if strings.HasPrefix(s, p[0]) {
+			s = p[1] + s[len(p[0]):]
+			break
+		}
+	}
+	return strings.TrimSuffix(s, "g")
+}
+
+func syllableCost(a, b string) float64 {
+	switch {
+	case a == b:
+		return 0
+	case looseSyllable(a) == looseSyllable(b):
+		return 0.5
+	}
+	return 1
+}
+
+// syllableAlignDistance 短串 pattern 与长串 text 任意一段之间的最小编辑距离
+func syllableAlignDistance(pattern, text []string) float64 {
+	prev := make([]float64, len(text)+1) // 首行全 0：pattern 可以从 text 任意位置开始
+	cur := make([]float64, len(text)+1)
+	for i := 1; i <= len(pattern); i++ {
+		cur[0] = float64(i)
+		for j := 1; j <= len(text); j++ {
+			cur[j] = min(
+				prev[j-1]+syllableCost(pattern[i-1], text[j-1]),
+				prev[j]+1,
+				cur[j-1]+1,
+			)
+		}
+		prev, cur = cur, prev
+	}
+	best := prev[0]
+	for _, d := range prev[1:] {
+		best = min(best, d)
+	}
+	return best
+}
+
+// pinyinSimilarity 返回音节相似度（短串对齐后的 1-距离/长度）与覆盖率（短串占长串的比例）；
+// 任一方少于两个音节时不做模糊匹配，单字太容易撞车
+func pinyinSimilarity(field, query string) (sim, coverage float64) {
+	a, b := toSyllables(field), toSyllables(query)
+	if len(a) < 2 || len(b) < 2 {
+		return 0, 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	sim = 1 - syllableAlignDistance(a, b)/float64(len(a))
+	if sim < 0 {
+		sim = 0
+	}
+	return sim, float64(len(a)) / float64(len(b))
+}
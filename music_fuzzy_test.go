@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToSyllables(t *testing.T) {
+	if got := strings.Join(toSyllables("妙堂之外"), " "); got != "miao tang zhi wai" {
+		t.Fatalf("got %q", got)
+	}
+	if got := strings.Join(toSyllables("Hello，世界2"), " "); got != "hello shi jie 2" {
+		t.Fatalf("中英混排: got %q", got)
+	}
+}
+
+func TestPinyinSimilarity(t *testing.T) {
+	cases := []struct {
+		field, query string
+		sim          float64
+	}{
+		{"庙堂之外", "妙堂之外", 1},     // 同音字
+		{"说好不哭", "索好不哭", 0.875}, // 平翘舌
+		{"庙堂之外", "陈楚生的妙堂之外", 1}, // 歌名只是查询的一部分
+		{"庙堂之外", "今天天气", 0},
+		{"晴天", "天", 0}, // 单音节不做模糊
+	}
+	for _, c := range cases {
+		sim, _ := pinyinSimilarity(c.field, c.query)
+		if sim < c.sim-0.01 || sim > c.sim+0.01 {
+			t.Fatalf("%s / %s: sim=%.3f want %.3f", c.field, c.query, sim, c.sim)
+		}
+	}
+}
+
+func TestCatalogFuzzySearch(t *testing.T) {
+	oldDir, oldIndex := musicDir, musicCatalogPath
+	defer func() { musicDir, musicCatalogPath = oldDir, oldIndex }()
+	musicDir, musicCatalogPath = t.TempDir(), ""
+	for _, name := range []string{"陈楚生《庙堂之外》.wav", "周杰伦 - 晴天.wav", "某歌手 - 情天.wav", "周杰伦 - 稻香.wav"} {
+		writeTestWAV(t, filepath.Join(musicDir, name), 16000, 1, make([]int16, 160))
+	}
+	currentCatalog().Refresh()
+
+	// ASR 同音字：本地意图判定不再因“曲库没有”而丢掉请求
+	if !hasLocalSongMatch("妙堂之外") {
+		t.Fatal("同音字歌名应命中")
+	}
+	m := currentCatalog().Search("妙堂之外")
+	if len(m) != 1 || m[0].Title != "庙堂之外" || !m[0].Fuzzy {
+		t.Fatalf("模糊匹配结果异常: %+v", m)
+	}
+	if ambiguousMatches(m) != nil {
+		t.Fatal("只有一首候选时不应视为含糊")
+	}
+	if hasLocalSongMatch("天气预报") {
+		t.Fatal("不相关的查询不应命中")
+	}
+
+	// 汉字完全一致优先于拼音模糊
+	if m := currentCatalog().Search("晴天"); len(m) < 2 || m[0].Title != "晴天" || m[0].Fuzzy || ambiguousMatches(m) != nil {
+		t.Fatalf("精确命中应排第一且不含糊: %+v", m)
+	}
+
+	// 请天 → 晴天 / 情天 同音，交给用户确认
+	res := toolPlayMusic(context.Background(), []byte(`{"query":"请天"}`))
+	if res.After != nil || !strings.Contains(res.Content, "candidates") || !strings.Contains(res.Speech, "《晴天》") || !strings.Contains(res.Speech, "《情天》") {
+		t.Fatalf("含糊时应返回候选而不是直接播放: %+v", res)
+	}
+
+	// 阈值调高后不再模糊命中
+	oldThreshold := musicFuzzyThreshold
+	defer func() { musicFuzzyThreshold = oldThreshold }()
+	musicFuzzyThreshold = 1.01
+	if hasLocalSongMatch("妙堂之外") {
+		t.Fatal("阈值以下不应命中")
+	}
+}
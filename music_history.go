@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ================= 播放历史 =================
+// 说明：
+// - MusicManager 记下最近开始播放的曲目（lastPlayed）和它之前放过的若干首（history，旧 → 新）；
+// - “上一首”从 history 往回走，往回走时不把离开的那首再压回去，连说几次就一路往前翻；
+// - “再放一遍”重放 lastPlayed；“刚才那首歌叫什么”在本地按历史回答，不经过 LLM；
+// - “这是什么歌”按当前曲目和曲库信息回答（歌手、已播/剩余时间），播报时音乐保持压低。
+
+// musicHistoryLimit 最多记住多少首放过的歌
+const musicHistoryLimit = 20
+
+// recordPlayedLocked 调用方持有 m.mu；单曲循环重复同一首时不重复记录
+func (m *MusicManager) recordPlayedLocked(path string) {
+	if m.lastPlayed != "" && m.lastPlayed != path {
+		m.history = append(m.history, m.lastPlayed)
+		if len(m.history) > musicHistoryLimit {
+			m.history = m.history[len(m.history)-musicHistoryLimit:]
+		}
+	}
+	m.lastPlayed = path
+}
+
+// LastPlayed 最近开始播放的曲目（正在放、暂停或已经停下）
+func (m *MusicManager) LastPlayed() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastPlayed
+}
+
+// PreviousPlayed lastPlayed 之前放的那首
+func (m *MusicManager) PreviousPlayed() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.history) == 0 {
+		return ""
+	}
+	return m.history[len(m.history)-1]
+}
+
+// TakePrevious 取出上一首用于回放：从历史里移除，并且回放时不把当前这首记回历史
+func (m *MusicManager) TakePrevious() (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.history) == 0 {
+		return "", false
+	}
+	prev := m.history[len(m.history)-1]
+	m.history = m.history[:len(m.history)-1]
+	m.lastPlayed = ""
+	return prev, true
+}
+
+// 辅助判定：上一首
+func isPreviousTrackCommand(text string) bool {
+	return containsAnyWord(text, []string{"上一首", "上首", "上一曲", "前一首"})
+}
+
+// 辅助判定：重放（当前/刚才那首）
+func isReplayCommand(text string) bool {
+	return containsAnyWord(text, []string{"再放一遍", "再放一次", "再播一遍", "再听一遍", "再听一次", "再来一遍", "重新播放", "重放", "重播"})
+}
+
+// 辅助判定：问刚才放的是什么歌
+func isLastSongQuery(text string) bool {
+	cleaned := normalizeIntentText(text)
+	if !strings.Contains(cleaned, "刚才") && !strings.Contains(cleaned, "刚刚") && !strings.Contains(cleaned, "上一首") {
+		return false
+	}
+	for _, w := range []string{"叫什么", "什么歌", "哪首歌", "歌名", "谁唱的", "是什么"} {
+		if strings.Contains(cleaned, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// 辅助判定：问正在放的歌（歌名/歌手/放了多久）；只在有歌时使用。
+// “谁唱的”“还有多久”这类泛问须带指代（这首/这是/现在放的/正在放），否则“离春节还有多久”“《青花瓷》是谁唱的”也会被截走
+func isNowPlayingQuery(text string) bool {
+	if containsAnyWord(text, []string{
+		"这是什么歌", "这首是什么歌", "这是哪首歌", "放的什么歌", "放的是什么歌", "在放什么歌", "正在放什么", "现在放的是什么",
+	}) {
+		return true
+	}
+	return containsAnyWord(text, []string{"这首", "这是", "现在放的", "正在放"}) &&
+		containsAnyWord(text, []string{"谁唱的", "叫什么", "歌名是什么", "还有多久", "还剩多久", "放了多久", "放到哪了"})
+}
+
+func containsAnyWord(text string, words []string) bool {
+	cleaned := normalizeIntentText(text)
+	if cleaned == "" {
+		return false
+	}
+	for _, w := range words {
+		if strings.Contains(cleaned, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupTrack 曲库里的曲目信息；曲库里没有（文件已删）时用文件名兜底
+func lookupTrack(path string) Track {
+	if t, ok := currentCatalog().Lookup(path); ok {
+		return t
+	}
+	artist, title := parseTrackFilename(path)
+	if title == "" {
+		title = extractTitleFromPath(path, filepath.Base(path))
+	}
+	return Track{Path: path, Title: title, Artist: artist}
+}
+
+// songLabel “陈楚生的《庙堂之外》”
+func songLabel(t Track) string {
+	if t.Artist != "" {
+		return fmt.Sprintf("%s的《%s》", t.Artist, t.Title)
+	}
+	return fmt.Sprintf("《%s》", t.Title)
+}
+
+// lastSongText 回答“刚才那首歌叫什么”；path 为空表示还没放过歌
+func lastSongText(path string) string {
+	if path == "" {
+		return "刚才没有放歌哦"
+	}
+	return "刚才放的是" + songLabel(lookupTrack(path))
+}
+
+// nowPlayingText 回答“这是什么歌”：歌名、歌手，时长已知时带上已播/剩余时间
+func nowPlayingText(t Track, elapsed time.Duration, paused bool) string {
+	prefix := "现在放的是"
+	if paused {
+		prefix = "暂停的是"
+	}
+	text := prefix + songLabel(t)
+	switch {
+	case t.Duration > elapsed && elapsed >= time.Second:
+		text += "，已经放了" + formatSpokenDuration(elapsed) + "，还剩" + formatSpokenDuration(t.Duration-elapsed)
+	case t.Duration > 0:
+		text += "，全长" + formatSpokenDuration(t.Duration)
+	}
+	return text
+}
+
+// answerNowPlaying 本地回答正在放的歌，等播报结束再返回（期间音乐保持压低）
+func answerNowPlaying() {
+	path := musicMgr.CurrentSongPath()
+	answer := "现在没有在放歌哦"
+	if path != "" {
+		answer = nowPlayingText(lookupTrack(path), musicMgr.Position(), musicMgr.IsPaused())
+	}
+	log.Printf("本地回答正在播放: %s", answer)
+	drainTTSDone()
+	speakText(answer)
+	waitTTSDone(10 * time.Second)
+}
+
+func speakText(text string) {
+	ttsManagerChan <- ttsMessage{text: text}
+	ttsManagerChan <- ttsMessage{text: "[[END]]"}
+}
+
+// answerLastSong 本地回答刚才放的歌；音乐还在放时“刚才那首”指当前这首之前的一首
+func answerLastSong(musicOn bool) {
+	path := musicMgr.LastPlayed()
+	if musicOn {
+		path = musicMgr.PreviousPlayed()
+	}
+	answer := lastSongText(path)
+	log.Printf("本地回答播放历史: %s", answer)
+	speakText(answer)
+}
+
+// previousTrack 上一首指向的曲目（不从历史里取出）；没有时为空
+func previousTrack(musicOn bool) string {
+	if musicOn {
+		return musicMgr.PreviousPlayed()
+	}
+	return musicMgr.LastPlayed()
+}
+
+// playPrevious 上一首：音乐开着时回到之前那首，没在放时把最近放过的那首重新放起来
+func playPrevious(musicOn bool) {
+	var path string
+	if musicOn {
+		path, _ = musicMgr.TakePrevious()
+	} else {
+		path = musicMgr.LastPlayed()
+	}
+	if path == "" {
+		speakText("前面没有歌了")
+		return
+	}
+	playTrackWithConfirmation(path)
+}
+
+// replaySong 从头重放最近那首
+func replaySong() {
+	path := musicMgr.LastPlayed()
+	if path == "" {
+		speakText("刚才没有放歌哦")
+		return
+	}
+	playTrackWithConfirmation(path)
+}
+
+// playTrackWithConfirmation 播报确认后从 path 开始放，之后按播放模式接着放曲库
+func playTrackWithConfirmation(path string) {
+	t := lookupTrack(path)
+	if err := checkMusicFile(path); err != nil {
+		log.Printf("❌ [MUSIC] 无法播放 %s: %v", filepath.Base(path), err)
+		speakMusicError(t.Title)
+		return
+	}
+	speakPlayConfirmationAndWait(t.Title)
+	musicMgr.PlayQueue(currentCatalog().QueueFrom(path))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMusicHistory(t *testing.T) {
+	m := NewMusicManager()
+	record := func(paths ...string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, p := range paths {
+			m.recordPlayedLocked(p)
+		}
+	}
+	record("a", "b", "b", "c") // 单曲循环重复的 b 只记一次
+	if m.LastPlayed() != "c" || m.PreviousPlayed() != "b" {
+		t.Fatalf("last=%s prev=%s", m.LastPlayed(), m.PreviousPlayed())
+	}
+
+	// 连说两次“上一首”：c → b → a
+	for _, want := range []string{"b", "a"} {
+		prev, ok := m.TakePrevious()
+		if !ok || prev != want {
+			t.Fatalf("上一首=%q want %q", prev, want)
+		}
+		record(prev)
+	}
+	if _, ok := m.TakePrevious(); ok {
+		t.Fatal("历史已经翻到头")
+	}
+
+	for i := 0; i < musicHistoryLimit+5; i++ {
+		record(fmt.Sprint(i))
+	}
+	if len(m.history) != musicHistoryLimit || m.PreviousPlayed() != fmt.Sprint(musicHistoryLimit+3) {
+		t.Fatalf("历史应有上限: len=%d", len(m.history))
+	}
+}
+
+func TestMusicHistoryIntents(t *testing.T) {
+	cases := []struct {
+		text                    string
+		previous, replay, query bool
+	}{
+		{"上一首", true, false, false},
+		{"放上一首歌", true, false, false},
+		{"再放一遍刚才那首", false, true, false},
+		{"重新播放", false, true, false},
+		{"刚才那首歌叫什么", false, false, true},
+		{"刚刚放的是谁唱的", false, false, true},
+		{"刚才说了什么", false, false, false},
+		{"下一首", false, false, false},
+	}
+	for _, c := range cases {
+		if isPreviousTrackCommand(c.text) != c.previous || isReplayCommand(c.text) != c.replay || isLastSongQuery(c.text) != c.query {
+			t.Fatalf("%s: previous=%v replay=%v query=%v", c.text, isPreviousTrackCommand(c.text), isReplayCommand(c.text), isLastSongQuery(c.text))
+		}
+	}
+}
+
+func TestLastSongText(t *testing.T) {
+	oldDir, oldIndex := musicDir, musicCatalogPath
+	defer func() { musicDir, musicCatalogPath = oldDir, oldIndex }()
+	musicDir, musicCatalogPath = t.TempDir(), ""
+	path := filepath.Join(musicDir, "陈楚生《庙堂之外》.wav")
+	writeTestWAV(t, path, 16000, 1, make([]int16, 160))
+
+	if got := lastSongText(path); got != "刚才放的是陈楚生的《庙堂之外》" {
+		t.Fatalf("got %q", got)
+	}
+	// 文件已经从曲库删掉：按文件名回答
+	if got := lastSongText(filepath.Join(musicDir, "稻香.mp3")); got != "刚才放的是《稻香》" {
+		t.Fatalf("got %q", got)
+	}
+	if got := lastSongText(""); got != "刚才没有放歌哦" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestNowPlayingText(t *testing.T) {
+	tr := Track{Title: "庙堂之外", Artist: "陈楚生", Duration: 3*time.Minute + 30*time.Second}
+	cases := []struct {
+		t       Track
+		elapsed time.Duration
+		paused  bool
+		want    string
+	}{
+		{tr, 80 * time.Second, false, "现在放的是陈楚生的《庙堂之外》，已经放了1分20秒，还剩2分10秒"},
+		{tr, 0, false, "现在放的是陈楚生的《庙堂之外》，全长3分30秒"},
+		{tr, 10 * time.Second, true, "暂停的是陈楚生的《庙堂之外》，已经放了10秒，还剩3分20秒"},
+		{Track{Title: "稻香"}, 30 * time.Second, false, "现在放的是《稻香》"},
+	}
+	for _, c := range cases {
+		if got := nowPlayingText(c.t, c.elapsed, c.paused); got != c.want {
+			t.Fatalf("got %q want %q", got, c.want)
+		}
+	}
+	for text, want := range map[string]bool{
+		"这是什么歌": true, "这是谁唱的呀": true, "这首歌还剩多久": true, "现在放的歌叫什么": true, "讲个笑话": false,
+		"离春节还有多久": false, "《青花瓷》是谁唱的": false, "那首歌叫什么名字": false,
+	} {
+		if isNowPlayingQuery(text) != want {
+			t.Fatalf("%s: want %v", text, want)
+		}
+	}
+}
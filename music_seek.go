@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================= 曲内跳转 =================
+// 说明：
+// - 播放协程用 wroteSamples 记着已经送出的样本数，也就是当前播放位置；跳转请求经 seekChan 交给它处理；
+// - 往后跳直接解码并丢掉中间的样本，往前跳重新打开文件再跳；MP3/FLAC 没有可靠的索引，丢样本最稳妥，
+//   代价是长距离跳转要多解码一段（RK3308 上一分钟 MP3 约几百毫秒）；
+// - 语音指令：“快进30秒”“倒回去一点”“从头放”“跳到一分半”，数字支持汉字。
+
+// musicSeekStep 没说多少时（“快进一点”“倒回去一下”）跳多远
+const musicSeekStep = 10 * time.Second
+
+type seekRequest struct {
+	offset   time.Duration
+	relative bool // true：相对当前位置（负数往回）；false：距开头
+	done     chan time.Duration
+}
+
+// target 跳转目标（样本数），不早于开头
+func (r seekRequest) target(current int64) int64 {
+	target := int64(r.offset) * musicSampleRate / int64(time.Second)
+	if r.relative {
+		target += current
+	}
+	return max(target, 0)
+}
+
+func samplesDuration(samples int64) time.Duration {
+	return time.Duration(samples) * time.Second / musicSampleRate
+}
+
+// discardSamples 从 PCM16 流里丢掉 n 个样本，返回实际丢掉的数量（读到结尾时会少于 n）
+func discardSamples(r io.Reader, n int64) (int64, error) {
+	buf := make([]byte, 32*1024)
+	remaining := n * 2
+	for remaining > 0 {
+		k, err := r.Read(buf[:min(int64(len(buf)), remaining)])
+		remaining -= int64(k)
+		if err != nil {
+			return (n*2 - remaining) / 2, err
+		}
+	}
+	return n, nil
+}
+
+// Seek 在当前曲目（播放或暂停中）内跳转，返回跳转后的位置；没有曲目或播放协程没响应时返回 false
+func (m *MusicManager) Seek(offset time.Duration, relative bool) (time.Duration, bool) {
+	m.mu.Lock()
+	ch := m.seekChan
+	active := m.isPlaying || m.paused
+	m.mu.Unlock()
+	if !active {
+		return 0, false
+	}
+	req := seekRequest{offset: offset, relative: relative, done: make(chan time.Duration, 1)}
+	select {
+	case ch <- req:
+	default:
+		return 0, false
+	}
+	select {
+	case pos := <-req.done:
+		return pos, true
+	case <-time.After(10 * time.Second):
+		return 0, false
+	}
+}
+
+// Position 当前曲目的播放位置
+func (m *MusicManager) Position() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.position == nil || (!m.isPlaying && !m.paused) {
+		return 0
+	}
+	return samplesDuration(m.position.Load())
+}
+
+// ---------- 语音指令 ----------
+
+// seekCommand 解析出的跳转指令
+type seekCommand struct {
+	Offset   time.Duration
+	Relative bool
+}
+
+const numeralChars = `[0-9零〇一二两三四五六七八九十百]`
+
+var (
+	minutesRe      = regexp.MustCompile(`(` + numeralChars + `+|半)个?分(?:钟)?(半)?(` + numeralChars + `+)?`)
+	secondsRe      = regexp.MustCompile(`(` + numeralChars + `+)秒`)
+	bareNumeralRe  = regexp.MustCompile(numeralChars + `+`)
+	seekForward    = []string{"快进", "往后跳", "向后跳", "往后快进"}
+	seekBackward   = []string{"快退", "倒回", "后退", "退回", "倒退", "回退"}
+	seekAbsolute   = []string{"跳到", "拖到", "快进到", "从第"}
+	seekFromStarts = []string{"从头放", "从头播", "从头开始", "从头听", "从头唱", "重头放", "重头播", "从头再来"}
+)
+
+// “前进/往回”太常见（“放一首前进之歌”“往回走的歌”），只认开头且后面只跟时长的说法
+var (
+	seekForwardLead  = []string{"前进"}
+	seekBackwardLead = []string{"往回"}
+)
+
+// parseChineseNumber 解析“三十五”“两百”“90”这类数字
+func parseChineseNumber(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	digits := map[rune]int{'零': 0, '〇': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	total, cur := 0, 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			cur = cur*10 + int(r-'0')
+		case r == '十' || r == '百':
+			unit := 10
+			if r == '百' {
+				unit = 100
+			}
+			if cur == 0 {
+				cur = 1
+			}
+			total += cur * unit
+			cur = 0
+		default:
+			d, ok := digits[r]
+			if !ok {
+				return 0, false
+			}
+			cur = d
+		}
+	}
+	return total + cur, true
+}
+
+// parseSpokenDuration 从指令里找时长：“30秒”“一分半”“两分十秒”“半分钟”；bareSeconds 时只有数字也按秒算（“快进30”）
+func parseSpokenDuration(s string, bareSeconds bool) (time.Duration, bool) {
+	if m := minutesRe.FindStringSubmatch(s); m != nil {
+		d := 30 * time.Second
+		if m[1] != "半" {
+			n, ok := parseChineseNumber(m[1])
+			if !ok {
+				return 0, false
+			}
+			d = time.Duration(n) * time.Minute
+		}
+		if m[2] != "" {
+			d += 30 * time.Second
+		}
+		if n, ok := parseChineseNumber(m[3]); ok {
+			d += time.Duration(n) * time.Second
+		}
+		return d, true
+	}
+	if m := secondsRe.FindStringSubmatch(s); m != nil {
+		n, ok := parseChineseNumber(m[1])
+		return time.Duration(n) * time.Second, ok
+	}
+	if m := bareNumeralRe.FindString(s); bareSeconds && m != "" {
+		n, ok := parseChineseNumber(m)
+		return time.Duration(n) * time.Second, ok
+	}
+	return 0, false
+}
+
+// parseSeekCommand 识别跳转指令
+func parseSeekCommand(text string) (seekCommand, bool) {
+	cleaned := normalizeIntentText(text)
+	if cleaned == "" {
+		return seekCommand{}, false
+	}
+	for _, w := range seekFromStarts {
+		if strings.Contains(cleaned, w) {
+			return seekCommand{}, true
+		}
+	}
+	for _, w := range seekAbsolute {
+		if i := strings.Index(cleaned, w); i >= 0 {
+			if d, ok := parseSpokenDuration(cleaned[i+len(w):], false); ok {
+				return seekCommand{Offset: d}, true
+			}
+		}
+	}
+	// “一点/一下”是没说多少，别把里面的“一”当成一秒
+	for _, vague := range []string{"一点儿", "一点", "一下", "一些", "一会儿"} {
+		cleaned = strings.ReplaceAll(cleaned, vague, "")
+	}
+	for _, dir := range []struct {
+		words []string
+		sign  time.Duration
+	}{{seekForward, 1}, {seekBackward, -1}} {
+		for _, w := range dir.words {
+			i := strings.Index(cleaned, w)
+			if i < 0 {
+				continue
+			}
+			d, ok := parseSpokenDuration(cleaned[i+len(w):], true)
+			if !ok || d == 0 {
+				d = musicSeekStep
+			}
+			return seekCommand{Offset: dir.sign * d, Relative: true}, true
+		}
+	}
+	for _, dir := range []struct {
+		words []string
+		sign  time.Duration
+	}{{seekForwardLead, 1}, {seekBackwardLead, -1}} {
+		for _, w := range dir.words {
+			rest, found := strings.CutPrefix(cleaned, w)
+			if !found {
+				continue
+			}
+			d := musicSeekStep
+			if rest != "" {
+				// 后面必须整段都是时长（“前进10秒”“往回一分半”），否则是别的话
+				if minutesRe.FindString(rest) != rest && secondsRe.FindString(rest) != rest {
+					continue
+				}
+				if d, _ = parseSpokenDuration(rest, false); d == 0 {
+					continue
+				}
+			}
+			return seekCommand{Offset: dir.sign * d, Relative: true}, true
+		}
+	}
+	return seekCommand{}, false
+}
+
+// formatSpokenDuration 播报用的时长：“30秒”“1分30秒”“2分钟”
+func formatSpokenDuration(d time.Duration) string {
+	sec := int(d.Round(time.Second) / time.Second)
+	switch {
+	case sec < 60:
+		return fmt.Sprintf("%d秒", sec)
+	case sec%60 == 0:
+		return fmt.Sprintf("%d分钟", sec/60)
+	}
+	return fmt.Sprintf("%d分%d秒", sec/60, sec%60)
+}
+
+func seekConfirmationText(cmd seekCommand) string {
+	switch {
+	case !cmd.Relative && cmd.Offset == 0:
+		return "好的，从头播放"
+	case !cmd.Relative:
+		return "好的，跳到" + formatSpokenDuration(cmd.Offset)
+	case cmd.Offset < 0:
+		return "好的，后退" + formatSpokenDuration(-cmd.Offset)
+	}
+	return "好的，快进" + formatSpokenDuration(cmd.Offset)
+}
+
+// seekMusic 执行跳转并播报确认
+func seekMusic(cmd seekCommand) {
+	pos, ok := musicMgr.Seek(cmd.Offset, cmd.Relative)
+	if !ok {
+		speakText("现在没有在放歌哦")
+		return
+	}
+	log.Printf("语音跳转: offset=%s relative=%v -> %s", cmd.Offset, cmd.Relative, pos)
+	speakText(seekConfirmationText(cmd))
+}
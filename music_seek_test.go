@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseChineseNumber(t *testing.T) {
+	cases := map[string]int{"三十": 30, "十五": 15, "两": 2, "一百二十": 120, "九十九": 99, "45": 45, "零": 0}
+	for s, want := range cases {
+		if n, ok := parseChineseNumber(s); !ok || n != want {
+			t.Fatalf("%s: got %d ok=%v", s, n, ok)
+		}
+	}
+	if _, ok := parseChineseNumber("三点"); ok {
+		t.Fatal("非数字不应解析成功")
+	}
+}
+
+func TestParseSeekCommand(t *testing.T) {
+	cases := []struct {
+		text string
+		want seekCommand
+		ok   bool
+	}{
+		{"快进30秒", seekCommand{30 * time.Second, true}, true},
+		{"快进三十秒", seekCommand{30 * time.Second, true}, true},
+		{"倒回去一点", seekCommand{-musicSeekStep, true}, true},
+		{"往回退十五秒", seekCommand{-15 * time.Second, true}, true},
+		{"快进一分半", seekCommand{90 * time.Second, true}, true},
+		{"从头放", seekCommand{0, false}, true},
+		{"从头播放", seekCommand{0, false}, true},
+		{"跳到两分十秒", seekCommand{130 * time.Second, false}, true},
+		{"快进到一分钟", seekCommand{time.Minute, false}, true},
+		{"前进10秒", seekCommand{10 * time.Second, true}, true},
+		{"往回一分半", seekCommand{-90 * time.Second, true}, true},
+		{"前进", seekCommand{musicSeekStep, true}, true},
+		{"播放庙堂之外", seekCommand{}, false},
+		{"放一首前进之歌", seekCommand{}, false},
+		{"往回走的歌", seekCommand{}, false},
+		{"前进之歌", seekCommand{}, false},
+		{"跳到下一首", seekCommand{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parseSeekCommand(c.text)
+		if ok != c.ok || got != c.want {
+			t.Fatalf("%s: got %+v ok=%v", c.text, got, ok)
+		}
+	}
+}
+
+func TestSeekConfirmationText(t *testing.T) {
+	cases := map[seekCommand]string{
+		{30 * time.Second, true}:   "好的，快进30秒",
+		{-10 * time.Second, true}:  "好的，后退10秒",
+		{0, false}:                 "好的，从头播放",
+		{90 * time.Second, false}:  "好的，跳到1分30秒",
+		{120 * time.Second, false}: "好的，跳到2分钟",
+	}
+	for cmd, want := range cases {
+		if got := seekConfirmationText(cmd); got != want {
+			t.Fatalf("%+v: got %q want %q", cmd, got, want)
+		}
+	}
+}
+
+// playAndCollect 播放 src，start 后执行 action，播完返回输出的全部样本
+func playAndCollect(t *testing.T, src []int16, action func(m *MusicManager)) []int16 {
+	t.Helper()
+	oldOutDir := replayOutputDir
+	defer func() { replayOutputDir = oldOutDir }()
+	replayOutputDir = t.TempDir()
+	path := filepath.Join(t.TempDir(), "song.wav")
+	writeTestWAV(t, path, 16000, 1, src)
+
+	m := NewMusicManager()
+	if err := m.PlayFile(path); err != nil {
+		t.Fatal(err)
+	}
+	action(m)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.IsPlaying() {
+		if time.Now().After(deadline) {
+			t.Fatal("没有播完")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	outs, _ := filepath.Glob(filepath.Join(replayOutputDir, "music-*.wav"))
+	if len(outs) != 1 {
+		t.Fatalf("应只有一段输出: %v", outs)
+	}
+	f, err := os.Open(outs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, _, got, err := readWAV(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestMusicManagerSeek(t *testing.T) {
+	src := make([]int16, 32000)
+	for i := range src {
+		src[i] = int16(i % 20000)
+	}
+
+	// 快进 1 秒：输出正好少 1 秒，且结尾对得上
+	got := playAndCollect(t, src, func(m *MusicManager) {
+		time.Sleep(200 * time.Millisecond)
+		pos, ok := m.Seek(time.Second, true)
+		if !ok || pos < time.Second || m.Position() < time.Second {
+			t.Fatalf("快进后位置=%s ok=%v", pos, ok)
+		}
+	})
+	if len(got) != len(src)-16000 || got[len(got)-1] != src[len(src)-1] {
+		t.Fatalf("快进后输出样本数=%d", len(got))
+	}
+
+	// 从头放：重新打开文件，输出比原曲多出跳转前已放的那一段
+	var before time.Duration
+	got = playAndCollect(t, src, func(m *MusicManager) {
+		time.Sleep(300 * time.Millisecond)
+		before = m.Position()
+		if pos, ok := m.Seek(0, false); !ok || pos != 0 {
+			t.Fatalf("从头放后位置=%s ok=%v", pos, ok)
+		}
+	})
+	if extra := len(got) - len(src); extra <= 0 || extra > int(before/time.Millisecond)*16+640 {
+		t.Fatalf("从头放后输出样本数=%d（跳转前位置 %s）", len(got), before)
+	}
+
+	if _, ok := NewMusicManager().Seek(time.Second, true); ok {
+		t.Fatal("没有曲目时不应跳转")
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/meta"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// ================= 曲目元数据 =================
+// 说明：
+// - MP3 读 ID3v2（2.2/2.3/2.4），没有时退回文件末尾的 ID3v1；FLAC 读 Vorbis Comment；
+// - 国内很多 MP3 的 ID3 文本标成 ISO-8859-1，实际是 GBK：不是合法 UTF-8 的按 GBK 解码；
+// - 标签缺失的字段用文件名补齐（“歌手《歌名》”/“歌手 - 歌名”）。
+
+// trackTags 从文件里读到的元数据
+type trackTags struct {
+	Title    string
+	Artist   string
+	Album    string
+	Genre    string
+	Duration time.Duration
+}
+
+// readTrackTags 读取标签与时长；读不到的字段留空，不返回错误
+func readTrackTags(path string) trackTags {
+	var tags trackTags
+	f, err := os.Open(path)
+	if err != nil {
+		return tags
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		tags = readID3v2(f)
+		if tags.Title == "" && tags.Artist == "" {
+			v1 := readID3v1(f)
+			v1.Duration = tags.Duration
+			tags = v1
+		}
+		if tags.Duration == 0 {
+			f.Seek(0, io.SeekStart)
+			if dec, err := mp3.NewDecoder(f); err == nil && dec.SampleRate() > 0 && dec.Length() > 0 {
+				tags.Duration = time.Duration(dec.Length()/4) * time.Second / time.Duration(dec.SampleRate())
+			}
+		}
+	case ".flac":
+		tags = readFLACTags(f)
+	case ".wav":
+		if format, data, err := parseWAVHeader(f); err == nil {
+			if lr, ok := data.(*io.LimitedReader); ok && format.FrameBytes() > 0 {
+				tags.Duration = time.Duration(lr.N/int64(format.FrameBytes())) * time.Second / time.Duration(format.Rate)
+			}
+		}
+	}
+	return tags
+}
+
+// ---------- 文件名 ----------
+
+var filenameDashSep = regexp.MustCompile(`\s+[-－—]+\s+|\s*[-－—]{2,}\s*`)
+
+// parseTrackFilename 从文件名猜歌手与歌名：“陈楚生《庙堂之外》2025-7-17”“陈楚生 - 庙堂之外”
+func parseTrackFilename(path string) (artist, title string) {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if start := strings.Index(base, "《"); start >= 0 && strings.Contains(base[start:], "》") {
+		artist = strings.Trim(base[:start], " -_－—")
+		return artist, extractTitleFromPath(path, base)
+	}
+	if parts := filenameDashSep.Split(base, 2); len(parts) == 2 {
+		artist, title = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if artist != "" && title != "" {
+			return artist, title
+		}
+	}
+	return "", strings.TrimSpace(base)
+}
+
+// ---------- ID3v2 ----------
+
+func readID3v2(r io.Reader) trackTags {
+	var tags trackTags
+	var hdr [10]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[0:3]) != "ID3" {
+		return tags
+	}
+	version, flags := hdr[3], hdr[5]
+	size := syncsafe(hdr[6:10])
+	if version < 2 || version > 4 || size <= 0 || size > 16<<20 {
+		return tags
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return tags
+	}
+	// 2.3 及以前整块做了 unsynchronisation；2.4 按帧标记
+	if flags&0x80 != 0 && version < 4 {
+		body = bytes.ReplaceAll(body, []byte{0xFF, 0x00}, []byte{0xFF})
+	}
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		ext := int(binary.BigEndian.Uint32(body[0:4])) + 4
+		if version == 4 {
+			ext = syncsafe(body[0:4])
+		}
+		if ext > len(body) {
+			return tags
+		}
+		body = body[ext:]
+	}
+
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(body) >= hdrLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var n int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			n = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(body[4:8]))
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		default:
+			n = syncsafe(body[4:8])
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		}
+		if n < 0 || hdrLen+n > len(body) {
+			break
+		}
+		data := body[hdrLen : hdrLen+n]
+		body = body[hdrLen+n:]
+		if version == 4 && frameFlags&0x0002 != 0 {
+			data = bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+		}
+		// 压缩/加密帧直接跳过
+		if (version == 3 && frameFlags&0x00C0 != 0) || (version == 4 && frameFlags&0x000C != 0) {
+			continue
+		}
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = decodeID3Text(data)
+		case "TPE1", "TP1":
+			tags.Artist = decodeID3Text(data)
+		case "TALB", "TAL":
+			tags.Album = decodeID3Text(data)
+		case "TCON", "TCO":
+			tags.Genre = cleanID3Genre(decodeID3Text(data))
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(decodeID3Text(data)); err == nil && ms > 0 {
+				tags.Duration = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return tags
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// decodeID3Text 文本帧：首字节为编码，多值以 NUL 分隔时只取第一个
+func decodeID3Text(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	enc, b := data[0], data[1:]
+	var s string
+	switch enc {
+	case 1, 2:
+		s = decodeUTF16(b, enc == 2)
+	case 3:
+		s = string(b)
+	default:
+		s = decodeLegacyText(b)
+	}
+	if i := strings.IndexRune(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFF && b[1] == 0xFE:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xFE && b[1] == 0xFF:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[i*2:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[i*2:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// decodeLegacyText 标成 ISO-8859-1 的文本：纯 ASCII/合法 UTF-8 原样返回，否则按 GBK 解码
+func decodeLegacyText(b []byte) string {
+	b = bytes.TrimRight(b, "\x00")
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	if out, err := simplifiedchinese.GBK.NewDecoder().Bytes(b); err == nil {
+		return string(out)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+var id3GenreRef = regexp.MustCompile(`^\(\d+\)`)
+
+// cleanID3Genre 去掉 “(13)Pop” 这类数字引用，只剩数字引用时丢弃
+func cleanID3Genre(s string) string {
+	s = strings.TrimSpace(id3GenreRef.ReplaceAllString(s, ""))
+	if _, err := strconv.Atoi(s); err == nil {
+		return ""
+	}
+	return s
+}
+
+// ---------- ID3v1 ----------
+
+func readID3v1(f *os.File) trackTags {
+	var tags trackTags
+	st, err := f.Stat()
+	if err != nil || st.Size() < 128 {
+		return tags
+	}
+	var b [128]byte
+	if _, err := f.ReadAt(b[:], st.Size()-128); err != nil || string(b[0:3]) != "TAG" {
+		return tags
+	}
+	field := func(x []byte) string {
+		if i := bytes.IndexByte(x, 0); i >= 0 {
+			x = x[:i]
+		}
+		return strings.TrimSpace(decodeLegacyText(x))
+	}
+	tags.Title = field(b[3:33])
+	tags.Artist = field(b[33:63])
+	tags.Album = field(b[63:93])
+	return tags
+}
+
+// ---------- FLAC ----------
+
+func readFLACTags(r io.Reader) trackTags {
+	var tags trackTags
+	stream, err := flac.Parse(r)
+	if err != nil {
+		return tags
+	}
+	if stream.Info.SampleRate > 0 {
+		tags.Duration = time.Duration(stream.Info.NSamples) * time.Second / time.Duration(stream.Info.SampleRate)
+	}
+	for _, block := range stream.Blocks {
+		vc, ok := block.Body.(*meta.VorbisComment)
+		if !ok {
+			continue
+		}
+		for _, kv := range vc.Tags {
+			v := strings.TrimSpace(kv[1])
+			switch strings.ToUpper(kv[0]) {
+			case "TITLE":
+				tags.Title = v
+			case "ARTIST":
+				tags.Artist = v
+			case "ALBUM":
+				tags.Album = v
+			case "GENRE":
+				tags.Genre = v
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// ================= PCM 辅助 =================
+// 全链路统一使用 16bit 小端 PCM；不同来源（云端 TTS、本地 TTS、音乐文件）采样率不一致时在这里转换。
+
+func pcmBytesToInt16(b []byte) []int16 {
+	out := make([]int16, len(b)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(b[i*2:]))
+	}
+	return out
+}
+
+func pcmInt16ToBytes(s []int16) []byte {
+	out := make([]byte, len(s)*2)
+	for i, v := range s {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(v))
+	}
+	return out
+}
+
+// downmixInt16 交织多声道取平均混成单声道；单声道原样返回
+func downmixInt16(samples []int16, channels int) []int16 {
+	if channels <= 1 {
+		return samples
+	}
+	mono := make([]int16, len(samples)/channels)
+	for i := range mono {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += int(samples[i*channels+c])
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return mono
+}
+
+// linearResampler 流式线性插值重采样（单声道）。
+// 跨分片保留上一片的最后一个采样点与小数相位，避免分片边界出现“咔哒”声。
+type linearResampler struct {
+	from, to int
+	step     float64
+	pos      float64 // 相对当前分片起点的读位置，-1 表示上一片的最后一个点
+	last     int16
+	hasLast  bool
+}
+
+func newLinearResampler(from, to int) *linearResampler {
+	return &linearResampler{from: from, to: to, step: float64(from) / float64(to)}
+}
+
+func (r *linearResampler) Process(in []int16) []int16 {
+	if len(in) == 0 {
+		return nil
+	}
+	if r.from == r.to || r.from <= 0 || r.to <= 0 {
+		out := make([]int16, len(in))
+		copy(out, in)
+		return out
+	}
+	if !r.hasLast {
+		r.last = in[0]
+		r.hasLast = true
+	}
+	at := func(i int) float64 {
+		if i < 0 {
+			return float64(r.last)
+		}
+		return float64(in[i])
+	}
+
+	n := len(in)
+	out := make([]int16, 0, int(float64(n)/r.step)+2)
+	for r.pos < float64(n-1) {
+		i := int(math.Floor(r.pos))
+		frac := r.pos - float64(i)
+		v := at(i) + (at(i+1)-at(i))*frac
+		out = append(out, clampInt16(v))
+		r.pos += r.step
+	}
+	r.pos -= float64(n)
+	r.last = in[n-1]
+	return out
+}
+
+func clampInt16(v float64) int16 {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(math.Round(v))
+}
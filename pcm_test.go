@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestLinearResamplerLength(t *testing.T) {
+	r := newLinearResampler(16000, 22050)
+	total := 0
+	for i := 0; i < 10; i++ {
+		total += len(r.Process(make([]int16, 1600)))
+	}
+	// 1 秒 16k 输入应得到约 22050 个点（允许边界误差）
+	if total < 22040 || total > 22060 {
+		t.Fatalf("重采样长度异常，got=%d", total)
+	}
+}
+
+func TestLinearResamplerContinuity(t *testing.T) {
+	r := newLinearResampler(22050, 16000)
+	ramp := make([]int16, 2000)
+	for i := range ramp {
+		ramp[i] = int16(i)
+	}
+	out := append(r.Process(ramp[:999]), r.Process(ramp[999:])...)
+	for i := 1; i < len(out); i++ {
+		if d := int(out[i]) - int(out[i-1]); d < 1 || d > 2 {
+			t.Fatalf("分片边界不连续: i=%d %d->%d", i, out[i-1], out[i])
+		}
+	}
+}
+
+func TestPCMRoundTrip(t *testing.T) {
+	in := []int16{0, 1, -1, 32767, -32768}
+	got := pcmBytesToInt16(pcmInt16ToBytes(in))
+	for i := range in {
+		if got[i] != in[i] {
+			t.Fatalf("PCM 转换异常: %v", got)
+		}
+	}
+}
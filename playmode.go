@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// ================= 播放模式与播放队列 =================
+// 说明：
+// - 顺序播放：按队列顺序放完即停；列表循环：放完从头再来；单曲循环：一直重复当前曲目；
+// - 随机播放：把队列洗牌后依次放，一轮内不重复，放完重新洗牌（新一轮第一首避开刚放完的那首）；
+// - 用户主动切歌（下一首）时单曲循环也会前进，只有自然播完才原地重复。
+
+// PlayMode 播放模式
+type PlayMode string
+
+const (
+	PlaySequential PlayMode = "sequential"
+	PlayShuffle    PlayMode = "shuffle"
+	PlayRepeatOne  PlayMode = "repeat_one"
+	PlayRepeatAll  PlayMode = "repeat_all"
+)
+
+// Label 播报用的中文名
+func (m PlayMode) Label() string {
+	switch m {
+	case PlayShuffle:
+		return "随机播放"
+	case PlayRepeatOne:
+		return "单曲循环"
+	case PlayRepeatAll:
+		return "列表循环"
+	}
+	return "顺序播放"
+}
+
+// validPlayMode 未知取值（旧版本/手改的配置）一律按顺序播放
+func validPlayMode(m PlayMode) PlayMode {
+	switch m {
+	case PlayShuffle, PlayRepeatOne, PlayRepeatAll:
+		return m
+	}
+	return PlaySequential
+}
+
+// playModeWords 语音切换播放模式；“单曲循环”要排在“循环播放”前面
+var playModeWords = []struct {
+	word string
+	mode PlayMode
+}{
+	{"单曲循环", PlayRepeatOne},
+	{"单曲重复", PlayRepeatOne},
+	{"循环这首", PlayRepeatOne},
+	{"列表循环", PlayRepeatAll},
+	{"全部循环", PlayRepeatAll},
+	{"循环播放", PlayRepeatAll},
+	{"随机播放", PlayShuffle},
+	{"随机模式", PlayShuffle},
+	{"打乱顺序", PlayShuffle},
+	{"顺序播放", PlaySequential},
+	{"按顺序播放", PlaySequential},
+	{"按顺序放", PlaySequential},
+}
+
+// playModeFillers 模式词前后不算“别的内容”的客套和动词
+var playModeFillers = []string{"切换到", "切换成", "切换为", "切到", "换成", "换到", "改成", "改为", "开启", "打开", "用", "请", "帮我", "模式", "吧", "呢", "啊", "一下"}
+
+// parsePlayModeCommand 识别“单曲循环/随机播放/顺序播放”等模式切换指令；
+// rest 为去掉模式词后剩下的内容（如“循环播放周杰伦的歌”里的“周杰伦的歌”），为空才是单纯的模式切换
+func parsePlayModeCommand(text string) (mode PlayMode, rest string, ok bool) {
+	cleaned := normalizeIntentText(text)
+	if cleaned == "" || strings.Contains(cleaned, "不要") || strings.Contains(cleaned, "别") {
+		return "", "", false
+	}
+	for _, w := range playModeWords {
+		if before, after, found := strings.Cut(cleaned, w.word); found {
+			return w.mode, trimPlayModeFillers(before + after), true
+		}
+	}
+	return "", "", false
+}
+
+// playModeRequest 模式词之外剩下的部分当作点歌处理（“周杰伦的歌” → “播放周杰伦的歌”）
+func playModeRequest(rest string) string {
+	if hasMusicIntent(rest) {
+		return rest
+	}
+	return "播放" + rest
+}
+
+// trimPlayModeFillers 反复去掉首尾的客套和动词
+func trimPlayModeFillers(s string) string {
+	for changed := true; changed; {
+		changed = false
+		for _, f := range playModeFillers {
+			if t := strings.TrimSuffix(strings.TrimPrefix(s, f), f); t != s {
+				s, changed = t, true
+			}
+		}
+	}
+	return s
+}
+
+// playQueue 播放队列；不是并发安全的，由 MusicManager 在 m.mu 下访问
+type playQueue struct {
+	items []string
+	order []int // 播放顺序（items 下标）；随机模式下为洗牌结果
+	pos   int   // 当前曲目在 order 中的位置
+	mode  PlayMode
+}
+
+// newPlayQueue 以 items[0] 为当前曲目建队列
+func newPlayQueue(items []string, mode PlayMode) *playQueue {
+	q := &playQueue{items: append([]string(nil), items...), mode: validPlayMode(mode)}
+	q.reorder(0)
+	return q
+}
+
+// reorder 按当前模式重排播放顺序，cur 所指曲目排在最前
+func (q *playQueue) reorder(cur int) {
+	n := len(q.items)
+	q.order = make([]int, 0, n)
+	q.pos = 0
+	if n == 0 {
+		return
+	}
+	if q.mode == PlayShuffle {
+		q.order = append(q.order, cur)
+		for _, i := range rand.Perm(n) {
+			if i != cur {
+				q.order = append(q.order, i)
+			}
+		}
+		return
+	}
+	for i := 0; i < n; i++ {
+		q.order = append(q.order, i)
+	}
+	q.pos = cur
+}
+
+// Current 当前曲目
+func (q *playQueue) Current() string {
+	if q == nil || len(q.order) == 0 {
+		return ""
+	}
+	return q.items[q.order[q.pos]]
+}
+
+// Remaining 按当前顺序，本轮在当前曲目之后还有几首
+func (q *playQueue) Remaining() int {
+	if q == nil || len(q.order) == 0 {
+		return 0
+	}
+	return len(q.order) - q.pos - 1
+}
+
+// SetMode 切换模式，当前曲目不变
+func (q *playQueue) SetMode(mode PlayMode) {
+	mode = validPlayMode(mode)
+	if q.mode == mode {
+		return
+	}
+	cur := 0
+	if len(q.order) > 0 {
+		cur = q.order[q.pos]
+	}
+	q.mode = mode
+	q.reorder(cur)
+}
+
+// Next 前进到下一首并返回；auto 表示自然播完（单曲循环时原地重复）。没有下一首时返回 false
+func (q *playQueue) Next(auto bool) (string, bool) {
+	if q == nil || len(q.order) == 0 {
+		return "", false
+	}
+	if auto && q.mode == PlayRepeatOne {
+		return q.Current(), true
+	}
+	if q.pos+1 < len(q.order) {
+		q.pos++
+		return q.Current(), true
+	}
+	switch q.mode {
+	case PlaySequential:
+		return "", false
+	case PlayShuffle:
+		last := q.order[q.pos]
+		q.order = rand.Perm(len(q.items))
+		if len(q.order) > 1 && q.order[0] == last {
+			q.order[0], q.order[len(q.order)-1] = q.order[len(q.order)-1], q.order[0]
+		}
+		q.pos = 0
+	default:
+		// 列表循环；单曲循环下用户主动切歌也走到这里
+		q.pos = 0
+	}
+	return q.Current(), true
+}
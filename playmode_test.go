@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drainQueue 从当前曲目开始，按自然播完的方式连续取 n 首
+func drainQueue(q *playQueue, n int) []string {
+	out := []string{q.Current()}
+	for len(out) < n {
+		next, ok := q.Next(true)
+		if !ok {
+			break
+		}
+		out = append(out, next)
+	}
+	return out
+}
+
+func TestPlayQueueModes(t *testing.T) {
+	items := []string{"a", "b", "c"}
+
+	if got := strings.Join(drainQueue(newPlayQueue(items, PlaySequential), 5), ""); got != "abc" {
+		t.Fatalf("顺序播放: %s", got)
+	}
+	if got := strings.Join(drainQueue(newPlayQueue(items, PlayRepeatAll), 7), ""); got != "abcabca" {
+		t.Fatalf("列表循环: %s", got)
+	}
+	q := newPlayQueue(items, PlayRepeatOne)
+	if got := strings.Join(drainQueue(q, 3), ""); got != "aaa" {
+		t.Fatalf("单曲循环: %s", got)
+	}
+	if next, _ := q.Next(false); next != "b" {
+		t.Fatalf("单曲循环下手动切歌应前进，got %s", next)
+	}
+
+	// 随机：每一轮都是不重复的全排列，轮与轮交界处不连着放同一首
+	for i := 0; i < 20; i++ {
+		got := drainQueue(newPlayQueue(items, PlayShuffle), 9)
+		if got[0] != "a" {
+			t.Fatalf("随机播放应从指定的第一首开始: %v", got)
+		}
+		for r := 0; r < 3; r++ {
+			round := append([]string(nil), got[r*3:r*3+3]...)
+			sort.Strings(round)
+			if strings.Join(round, "") != "abc" {
+				t.Fatalf("第 %d 轮有重复: %v", r+1, got)
+			}
+		}
+		for k := 1; k < len(got); k++ {
+			if got[k] == got[k-1] {
+				t.Fatalf("连续两次放同一首: %v", got)
+			}
+		}
+	}
+}
+
+func TestPlayQueueSetModeKeepsCurrent(t *testing.T) {
+	q := newPlayQueue([]string{"a", "b", "c", "d"}, PlaySequential)
+	q.Next(true)
+	q.SetMode(PlayShuffle)
+	if q.Current() != "b" || q.Remaining() != 3 {
+		t.Fatalf("切到随机后当前曲目应不变: cur=%s remaining=%d", q.Current(), q.Remaining())
+	}
+	q.SetMode(PlaySequential)
+	if q.Current() != "b" || q.Remaining() != 2 {
+		t.Fatalf("切回顺序后应从当前曲目往后放: cur=%s remaining=%d", q.Current(), q.Remaining())
+	}
+}
+
+func TestParsePlayModeCommand(t *testing.T) {
+	cases := []struct {
+		text string
+		mode PlayMode
+		rest string
+		ok   bool
+	}{
+		{"单曲循环", PlayRepeatOne, "", true},
+		{"切到单曲循环", PlayRepeatOne, "", true},
+		{"循环播放", PlayRepeatAll, "", true},
+		{"随机播放。", PlayShuffle, "", true},
+		{"顺序播放", PlaySequential, "", true},
+		{"列表循环吧", PlayRepeatAll, "", true},
+		{"帮我切换到随机播放模式吧", PlayShuffle, "", true},
+		{"循环播放周杰伦的歌", PlayRepeatAll, "周杰伦的歌", true},
+		{"单曲循环播放庙堂之外", PlayRepeatOne, "播放庙堂之外", true},
+		{"不要单曲循环", "", "", false},
+		{"播放庙堂之外", "", "", false},
+	}
+	for _, c := range cases {
+		mode, rest, ok := parsePlayModeCommand(c.text)
+		if ok != c.ok || mode != c.mode || rest != c.rest {
+			t.Fatalf("%s: mode=%q rest=%q ok=%v", c.text, mode, rest, ok)
+		}
+	}
+}
+
+func TestPlayModePersists(t *testing.T) {
+	oldPath, oldMgr := settingsPath, musicMgr
+	defer func() { settingsPath, musicMgr = oldPath, oldMgr }()
+	settingsPath = filepath.Join(t.TempDir(), "settings.json")
+
+	if s := loadSettings(); s.PlayMode != "" {
+		t.Fatalf("没有设置文件时应为默认值: %+v", s)
+	}
+	if err := updateSettings(func(s *deviceSettings) { s.PlayMode = PlayRepeatOne }); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟重启
+	musicMgr = NewMusicManager()
+	applySettings(loadSettings())
+	if musicMgr.PlayMode() != PlayRepeatOne {
+		t.Fatalf("重启后播放模式=%s", musicMgr.PlayMode())
+	}
+	if musicMgr.SetPlayMode("bogus") != PlaySequential {
+		t.Fatal("未知模式应回落到顺序播放")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "settings.json")
+	for _, data := range []string{`{"a":1}`, `{"a":2}`} {
+		if err := writeFileAtomic(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(path); string(b) != data {
+			t.Fatalf("内容=%s want %s", b, data)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("不应留下临时文件")
+	}
+}
+
+func TestMusicManagerRepeatOne(t *testing.T) {
+	oldOutDir := replayOutputDir
+	defer func() { replayOutputDir = oldOutDir }()
+	replayOutputDir = t.TempDir()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.wav"), filepath.Join(dir, "b.wav")
+	writeTestWAV(t, a, 16000, 1, make([]int16, 1600))
+	writeTestWAV(t, b, 16000, 1, make([]int16, 1600))
+
+	m := NewMusicManager()
+	m.SetPlayMode(PlayRepeatOne)
+	if err := m.PlayQueue([]string{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	// 单曲循环：播完自动重放同一首，不会切到 b
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out, _ := filepath.Glob(filepath.Join(replayOutputDir, "music-*.wav"))
+		if len(out) >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("单曲循环应自动重放，got %v", out)
+		}
+		if p := m.CurrentSongPath(); p != "" && p != a {
+			t.Fatalf("单曲循环不应切到 %s", p)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
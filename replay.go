@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	vado "github.com/maxhawkins/go-webrtc-vad"
+
+	"ai_box/aec"
+)
+
+// ================= 录音输入 / 离线回放 =================
+// 说明：
+// - 输入由 AI_BOX_AUDIO_IN 选择：alsa（arecord）| wav（离线回放）| stdin（标准输入的裸 PCM）；
+// - 实时模式：arecord 采集 10 通道原始麦克风阵列，经 AEC 后得到单声道；
+// - 回放模式（AI_BOX_REPLAY_INPUT）：读 WAV 文件或目录（按文件名排序），10 通道走 AEC，其他通道数直接混成单声道；
+//   文件之间与结尾补静音，保证每个文件都能被 VAD 切成独立语音段；
+// - AI_BOX_REPLAY_SPEED=0 时不按实时节奏喂数据，而是在每个语音段结束后等整条链路（ASR/LLM/TTS）空闲再继续，
+//   结果与机器快慢无关，适合在 go test 里做回归。
+
+// 每次处理的块大小（16k 下 16ms），与 AEC 的帧长一致
+const captureBlockSamples = 256
+
+// captureSource 录音输入
+type captureSource interface {
+	// ReadBlock 返回一块交织 PCM 及其通道数：10 表示原始麦克风阵列（需 AEC），1 表示已是单声道
+	ReadBlock() ([]int16, int, error)
+	Close() error
+}
+
+// ---------- arecord ----------
+
+type arecordSource struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	buf    []byte
+}
+
+func newArecordSource() (*arecordSource, error) {
+	cmd := exec.Command("arecord",
+		"-D", arecordDevice,
+		"-c", strconv.Itoa(arecordChannels),
+		"-r", strconv.Itoa(arecordRate),
+		"-f", "S16_LE",
+		"-t", "raw",
+		"--period-size="+strconv.Itoa(arecordPeriodSize),
+		"--buffer-size="+strconv.Itoa(arecordBufferSize),
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &arecordSource{cmd: cmd, stdout: stdout, buf: make([]byte, captureBlockSamples*arecordChannels*2)}, nil
+}
+
+func (s *arecordSource) ReadBlock() ([]int16, int, error) {
+	if _, err := io.ReadFull(s.stdout, s.buf); err != nil {
+		return nil, 0, err
+	}
+	return pcmBytesToInt16(s.buf), arecordChannels, nil
+}
+
+func (s *arecordSource) Close() error {
+	if s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+	return s.cmd.Wait()
+}
+
+// audioInKind 实际使用的输入：没配置 AI_BOX_AUDIO_IN 时，设置了回放输入就是 wav，否则 alsa
+func audioInKind() string {
+	if audioIn != "" {
+		return audioIn
+	}
+	if replayInput != "" {
+		return "wav"
+	}
+	return "alsa"
+}
+
+// openCaptureSource 按 AI_BOX_AUDIO_IN 打开实时输入（wav 回放另走 newWAVReplaySource）
+func openCaptureSource() (captureSource, error) {
+	switch kind := audioInKind(); kind {
+	case "alsa":
+		return newArecordSource()
+	case "stdin":
+		return newPipeSource(os.Stdin, arecordChannels), nil
+	default:
+		return nil, fmt.Errorf("未知的输入设备 %q（可选 alsa/wav/stdin）", kind)
+	}
+}
+
+// ---------- 标准输入 ----------
+
+// pipeSource 从管道读 16kHz S16_LE 交织 PCM，例如 sox 录音.wav -r 16000 -t raw - | ai_box；
+// 通道数同 AI_BOX_ARECORD_CHANNELS，10 通道走 AEC，其他通道数混成单声道
+type pipeSource struct {
+	r        io.Reader
+	channels int
+	buf      []byte
+}
+
+func newPipeSource(r io.Reader, channels int) *pipeSource {
+	return &pipeSource{r: r, channels: channels, buf: make([]byte, captureBlockSamples*channels*2)}
+}
+
+func (s *pipeSource) ReadBlock() ([]int16, int, error) {
+	if _, err := io.ReadFull(s.r, s.buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, 0, err
+	}
+	samples := pcmBytesToInt16(s.buf)
+	if s.channels == 10 {
+		return samples, 10, nil
+	}
+	return downmixInt16(samples, s.channels), 1, nil
+}
+
+func (s *pipeSource) Close() error { return nil }
+
+// ---------- WAV 回放 ----------
+
+// replayGap 文件之间/结尾补的静音
+const replayGap = 1500 * time.Millisecond
+
+type replayClip struct {
+	name     string
+	channels int
+	samples  []int16 // 交织；10 通道时保持原样，否则已混成 16k 单声道
+}
+
+type wavReplaySource struct {
+	clips []replayClip
+	speed float64
+
+	clip      int
+	pos       int // 当前片段内的帧位置
+	gapLeft   int // 剩余静音样点
+	started   time.Time
+	consumed  atomic.Int64 // 已读入的 16k 样点数（回放时钟）
+	announced bool
+}
+
+func newWAVReplaySource(input string, speed float64) (*wavReplaySource, error) {
+	paths, err := replayInputFiles(input)
+	if err != nil {
+		return nil, err
+	}
+	src := &wavReplaySource{speed: speed}
+	for _, p := range paths {
+		clip, err := loadReplayClip(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		src.clips = append(src.clips, clip)
+	}
+	return src, nil
+}
+
+func replayInputFiles(input string) ([]string, error) {
+	st, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return []string{input}, nil
+	}
+	entries, err := os.ReadDir(input)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(strings.ToLower(e.Name()), ".wav") {
+			paths = append(paths, filepath.Join(input, e.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s 下没有 wav 文件", input)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func loadReplayClip(path string) (replayClip, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return replayClip{}, err
+	}
+	defer f.Close()
+	rate, channels, samples, err := readWAV(f)
+	if err != nil {
+		return replayClip{}, err
+	}
+	clip := replayClip{name: filepath.Base(path), channels: channels}
+	if channels == 10 {
+		if rate != 16000 {
+			return replayClip{}, fmt.Errorf("10 通道输入需为 16kHz，实际 %d", rate)
+		}
+		clip.samples = samples
+		return clip, nil
+	}
+	mono := downmixInt16(samples, channels)
+	if rate != 16000 {
+		mono = newLinearResampler(rate, 16000).Process(mono)
+	}
+	clip.channels = 1
+	clip.samples = mono
+	return clip, nil
+}
+
+func (s *wavReplaySource) ReadBlock() ([]int16, int, error) {
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	// 按实时节奏喂数据
+	if s.speed > 0 {
+		due := time.Duration(float64(time.Duration(s.consumed.Load())*time.Second/16000) / s.speed)
+		if wait := due - time.Since(s.started); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	defer s.consumed.Add(captureBlockSamples)
+
+	if s.gapLeft > 0 {
+		s.gapLeft -= captureBlockSamples
+		return make([]int16, captureBlockSamples), 1, nil
+	}
+	if s.clip >= len(s.clips) {
+		return nil, 0, io.EOF
+	}
+	c := s.clips[s.clip]
+	if !s.announced {
+		s.announced = true
+		log.Printf("▶️ [回放] %s（%d 通道）", c.name, c.channels)
+	}
+	start := s.pos * c.channels
+	end := start + captureBlockSamples*c.channels
+	block := make([]int16, captureBlockSamples*c.channels)
+	if start < len(c.samples) {
+		copy(block, c.samples[start:min(end, len(c.samples))])
+	}
+	s.pos += captureBlockSamples
+	if end >= len(c.samples) {
+		s.clip++
+		s.pos = 0
+		s.announced = false
+		s.gapLeft = int(replayGap * 16000 / time.Second)
+	}
+	return block, c.channels, nil
+}
+
+func (s *wavReplaySource) Close() error { return nil }
+
+// Position 已回放的音频时长（事件时钟）
+func (s *wavReplaySource) Position() time.Duration {
+	return time.Duration(s.consumed.Load()) * time.Second / 16000
+}
+
+// AfterSegment 非实时回放时，语音段结束后等整条链路空闲
+func (s *wavReplaySource) AfterSegment() {
+	if s.speed <= 0 {
+		waitPipelineIdle(60 * time.Second)
+	}
+}
+
+// ---------- 链路空闲判定 ----------
+
+// pipelineInflight 正在处理的识别轮次/LLM 会话数
+var pipelineInflight atomic.Int32
+
+// trackPipeline 标记一段异步处理开始，返回结束函数
+func trackPipeline() func() {
+	pipelineInflight.Add(1)
+	return func() { pipelineInflight.Add(-1) }
+}
+
+// pipelineIdle 识别、对话、合成、播报都已结束（音乐播放不算）
+func pipelineIdle() bool {
+	if pipelineInflight.Load() > 0 || len(ttsManagerChan) > 0 || len(audioPcmChan) > 0 {
+		return false
+	}
+	activeTTSMu.Lock()
+	synthesizing := activeTTS != nil
+	activeTTSMu.Unlock()
+	if synthesizing {
+		return false
+	}
+	playerMutex.Lock()
+	playing := playerSink != nil
+	playerMutex.Unlock()
+	return !playing
+}
+
+// waitPipelineIdle 等链路连续空闲一小段时间（避免恰好处在两步之间）
+func waitPipelineIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	stable := 0
+	for time.Now().Before(deadline) {
+		if pipelineIdle() {
+			stable++
+			if stable >= 5 {
+				return true
+			}
+		} else {
+			stable = 0
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	log.Println("⚠️ [回放] 等待链路空闲超时")
+	return false
+}
+
+// runReplay 用 WAV 输入驱动完整链路，读完并等链路空闲后返回
+func runReplay(src *wavReplaySource, aecProc *aec.Processor, vadEng *vado.VAD) {
+	events.SetClock(src.Position)
+	defer events.SetClock(nil)
+	audioLoop(src, aecProc, vadEng)
+	waitPipelineIdle(60 * time.Second)
+	emitEvent(EventReplayEnd, "", "")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vado "github.com/maxhawkins/go-webrtc-vad"
+)
+
+// ---------- 回放测试用的假后端 ----------
+
+// scriptedASR 每个语音段按顺序返回一条预设文本
+type scriptedASR struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (a *scriptedASR) Name() string { return "scripted" }
+
+func (a *scriptedASR) Start(onResult ASRResultFunc) (ASRSession, error) {
+	return &scriptedASRSession{asr: a}, nil
+}
+
+type scriptedASRSession struct{ asr *scriptedASR }
+
+func (s *scriptedASRSession) Write([]byte) error { return nil }
+
+func (s *scriptedASRSession) Finish() (string, error) {
+	s.asr.mu.Lock()
+	defer s.asr.mu.Unlock()
+	if len(s.asr.texts) == 0 {
+		return "", nil
+	}
+	text := s.asr.texts[0]
+	s.asr.texts = s.asr.texts[1:]
+	return text, nil
+}
+
+func (s *scriptedASRSession) Close() {}
+
+// beepTTS 每段文本合成 50ms 静音
+type beepTTS struct{}
+
+func (beepTTS) Name() string { return "beep" }
+
+func (beepTTS) Start(ctx context.Context, onAudio TTSAudioFunc) (SynthesisSession, error) {
+	return &beepTTSSession{onAudio: onAudio}, nil
+}
+
+type beepTTSSession struct{ onAudio TTSAudioFunc }
+
+func (s *beepTTSSession) Send(text string) error {
+	s.onAudio(make([]byte, 16000/20*2), 16000)
+	return nil
+}
+
+func (s *beepTTSSession) Finish() error { return nil }
+func (s *beepTTSSession) Close()        {}
+
+// scriptedLLM 按用户输入匹配预设回复；先返回工具调用，工具结果回传后再返回文本
+type scriptedLLM struct {
+	replies map[string]scriptedReply
+}
+
+type scriptedReply struct {
+	tool string
+	args string
+	text string
+}
+
+func (l *scriptedLLM) Name() string { return "scripted" }
+
+func (l *scriptedLLM) Stream(ctx context.Context, req ChatRequest, onDelta LLMDeltaFunc) ([]ToolCall, error) {
+	last := req.Messages[len(req.Messages)-1]
+	var user string
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			user = req.Messages[i].Content
+			break
+		}
+	}
+	r, ok := l.replies[user]
+	if !ok {
+		return nil, fmt.Errorf("未预设的输入: %s", user)
+	}
+	if r.tool != "" && last.Role == "user" {
+		return []ToolCall{{ID: "call_1", Type: "function", Function: ToolFunctionCall{Name: r.tool, Arguments: r.args}}}, nil
+	}
+	onDelta(r.text)
+	return nil, nil
+}
+
+// ---------- 测试音频 ----------
+
+// speechLike 生成带谐波与包络的“类语音”信号，足以让 WebRTC VAD 判为语音
+func speechLike(d time.Duration) []int16 {
+	n := int(d * 16000 / time.Second)
+	out := make([]int16, n)
+	for i := range out {
+		ts := float64(i) / 16000
+		v := 0.0
+		for h := 1; h <= 12; h++ {
+			v += math.Sin(2*math.Pi*150*float64(h)*ts) / float64(h)
+		}
+		v *= 0.5 + 0.5*math.Sin(2*math.Pi*4*ts)
+		out[i] = int16(v * 6000)
+	}
+	return out
+}
+
+func writeTestWAV(t *testing.T, path string, rate, channels int, samples []int16) {
+	t.Helper()
+	data := pcmInt16ToBytes(samples)
+	if err := os.WriteFile(path, append(wavHeader(rate, channels, int64(len(data))), data...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var pipelineOnce sync.Once
+
+func TestReplayPipeline(t *testing.T) {
+	root := t.TempDir()
+	inputDir := filepath.Join(root, "input")
+	songDir := filepath.Join(root, "music")
+	outDir := filepath.Join(root, "out")
+	for _, d := range []string{inputDir, songDir, outDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lead := make([]int16, 16000*3/10)
+	utterance := append(lead, speechLike(1200*time.Millisecond)...)
+	for i := 1; i <= 4; i++ {
+		writeTestWAV(t, filepath.Join(inputDir, fmt.Sprintf("%02d.wav", i)), 16000, 1, utterance)
+	}
+	writeTestWAV(t, filepath.Join(songDir, "陈楚生《庙堂之外》.wav"), 16000, 1, make([]int16, 16000*20))
+
+	oldMusicDir, oldOutDir, oldASR, oldTTS, oldLLM, oldRate := musicDir, replayOutputDir, asrEngine, ttsEngine, llmClient, ttsSampleRate
+	defer func() {
+		musicDir, replayOutputDir, asrEngine, ttsEngine, llmClient, ttsSampleRate = oldMusicDir, oldOutDir, oldASR, oldTTS, oldLLM, oldRate
+	}()
+	musicDir = songDir
+	currentCatalog().Refresh()
+	replayOutputDir = outDir
+	ttsSampleRate = 16000
+	asrEngine = &scriptedASR{texts: []string{"你好小瑞", "播放庙堂之外", "现在几点了", "停止"}}
+	ttsEngine = beepTTS{}
+	llmClient = &scriptedLLM{replies: map[string]scriptedReply{
+		"播放庙堂之外": {tool: "play_music", args: `{"query":"庙堂之外"}`, text: "好的，马上为你播放《庙堂之外》"},
+	}}
+	pipelineOnce.Do(startPipeline)
+	assistant.Dispatch(EvSleep{})
+	defer musicMgr.Stop()
+
+	evCh := make(chan Event, 1024)
+	unsubscribe := events.Subscribe(evCh)
+	defer unsubscribe()
+
+	src, err := newWAVReplaySource(inputDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vadEng, err := vado.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vadEng.SetMode(3)
+	runReplay(src, nil, vadEng)
+
+	var got []string
+	segments := 0
+	for len(evCh) > 0 {
+		ev := <-evCh
+		if ev.Type == EventSegmentEnd {
+			segments++
+		}
+		got = append(got, strings.TrimRight(ev.Type+":"+ev.Detail+":"+ev.Text, ":"))
+	}
+	if segments != 4 {
+		t.Fatalf("应切出 4 个语音段，got=%d\n%s", segments, strings.Join(got, "\n"))
+	}
+
+	want := []string{
+		"asr::你好小瑞", "wake:asr:你好小瑞", "intent:wake_ack:你好小瑞", "tts::我在",
+		"asr::播放庙堂之外", "intent:llm:播放庙堂之外", `tool:play_music:{"query":"庙堂之外"}`,
+		"tts::好的，马上为你播放《庙堂之外》", "music:play:" + filepath.Join(songDir, "陈楚生《庙堂之外》.wav"),
+		"asr::现在几点了", "intent:busy_ignore:现在几点了",
+		"asr::停止", "music:pause:" + filepath.Join(songDir, "陈楚生《庙堂之外》.wav"), "intent:pause:停止",
+		"replay_end",
+	}
+	i := 0
+	for _, g := range got {
+		if i < len(want) && g == want[i] {
+			i++
+		}
+	}
+	if i != len(want) {
+		t.Fatalf("事件序列缺少 %q\n实际:\n%s", want[i], strings.Join(got, "\n"))
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(outDir, "tts-*.wav")); len(files) == 0 {
+		t.Fatalf("TTS 应写入文件输出")
+	}
+}
+
+func TestPipeSource(t *testing.T) {
+	// 双声道：混成单声道
+	stereo := make([]int16, captureBlockSamples*2+3)
+	for i := 0; i < captureBlockSamples; i++ {
+		stereo[2*i], stereo[2*i+1] = 100, 300
+	}
+	src := newPipeSource(bytes.NewReader(pcmInt16ToBytes(stereo)), 2)
+	block, channels, err := src.ReadBlock()
+	if err != nil || channels != 1 || len(block) != captureBlockSamples || block[0] != 200 {
+		t.Fatalf("block=%d channels=%d err=%v", len(block), channels, err)
+	}
+	// 结尾不足一块按读完处理
+	if _, _, err := src.ReadBlock(); err != io.EOF {
+		t.Fatalf("err=%v", err)
+	}
+
+	// 10 通道原样交给 AEC
+	raw := make([]int16, captureBlockSamples*10)
+	src = newPipeSource(bytes.NewReader(pcmInt16ToBytes(raw)), 10)
+	if block, channels, err := src.ReadBlock(); err != nil || channels != 10 || len(block) != len(raw) {
+		t.Fatalf("block=%d channels=%d err=%v", len(block), channels, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ================= 持久化设置 =================
+// 说明：
+// - 用户用语音改过的设置（播放模式、音量等）存成 JSON，默认在 AI_BOX_HOME/settings.json（AI_BOX_SETTINGS 可改路径）；
+// - 启动时读回并应用；文件不存在或损坏时按默认值运行，不影响启动；
+// - 写入用 writeFileAtomic（曲库索引、收藏也用它），断电时不会留下半份文件。
+
+// deviceSettings 需要跨重启保留的设置
+type deviceSettings struct {
+	PlayMode PlayMode `json:"play_mode,omitempty"`
+	Volume   *int     `json:"volume,omitempty"` // 设备音量；没调过时为空，保持设备原来的音量
+}
+
+var settingsMu sync.Mutex
+
+// loadSettings 读取设置；失败时返回零值
+func loadSettings() deviceSettings {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	s, err := readSettingsLocked()
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️ [设置] 读取 %s 失败: %v", settingsPath, err)
+	}
+	return s
+}
+
+func readSettingsLocked() (deviceSettings, error) {
+	var s deviceSettings
+	if settingsPath == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+// updateSettings 读出当前设置、交给 fn 修改后写回
+func updateSettings(fn func(*deviceSettings)) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	s, _ := readSettingsLocked()
+	fn(&s)
+	if settingsPath == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(settingsPath, b)
+}
+
+// writeFileAtomic 先写同目录下的临时文件再改名，读的一方要么看到旧文件要么看到完整的新文件；目录不存在时自动创建
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// applySettings 启动时把保存的设置应用到各模块
+func applySettings(s deviceSettings) {
+	if s.PlayMode != "" {
+		musicMgr.SetPlayMode(s.PlayMode)
+	}
+	if s.Volume != nil {
+		restoreVolume(*s.Volume)
+	}
+}
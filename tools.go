@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 工具调用（function calling） =================
+// 说明：
+// - 音乐控制等动作不再依赖模型在正文里写 [PLAY: 歌名]/[STOP]，而是以工具的形式交给模型调用；
+// - 工具结果回传给模型，由模型组织确认话术（“好的，为您播放《庙堂之外》”）；
+// - 会抢占声卡的动作（开始放歌）放在 After 里，等确认播报结束后再执行。
+
+// ToolFunctionCall 模型给出的函数名与 JSON 参数
+type ToolFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCall 一次工具调用（OpenAI / DashScope 的 tool_calls 结构）
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolFunctionCall `json:"function"`
+}
+
+// ToolResult 工具执行结果
+type ToolResult struct {
+	Content string // 回传给模型的结果（JSON）
+	Speech  string // 模型没有给出确认话术时的兜底播报
+	After   func() // 确认播报结束后再执行
+}
+
+// ToolHandler 工具实现；args 为模型给出的 JSON 参数
+type ToolHandler func(ctx context.Context, args json.RawMessage) ToolResult
+
+// Tool 注册到模型的一个工具
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON Schema
+	Handler     ToolHandler
+}
+
+type toolRegistry struct {
+	mu     sync.RWMutex
+	tools  []*Tool
+	byName map[string]*Tool
+}
+
+func newToolRegistry() *toolRegistry {
+	return &toolRegistry{byName: make(map[string]*Tool)}
+}
+
+var assistantTools = newToolRegistry()
+
+// Register 注册工具，同名覆盖
+func (r *toolRegistry) Register(t *Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.byName[t.Name]; ok {
+		for i, x := range r.tools {
+			if x == old {
+				r.tools[i] = t
+			}
+		}
+	} else {
+		r.tools = append(r.tools, t)
+	}
+	r.byName[t.Name] = t
+}
+
+// Specs 以 tools 字段格式导出给模型
+func (r *toolRegistry) Specs() []map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	specs := make([]map[string]interface{}, 0, len(r.tools))
+	for _, t := range r.tools {
+		specs = append(specs, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  t.Parameters,
+			},
+		})
+	}
+	return specs
+}
+
+// Dispatch 执行一次工具调用；未知工具/参数错误也以结果形式回传，让模型自己解释
+func (r *toolRegistry) Dispatch(ctx context.Context, call ToolCall) ToolResult {
+	r.mu.RLock()
+	t, ok := r.byName[call.Function.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return toolError("未知工具: " + call.Function.Name)
+	}
+	args := strings.TrimSpace(call.Function.Arguments)
+	if args == "" {
+		args = "{}"
+	}
+	if !json.Valid([]byte(args)) {
+		return toolError("参数不是合法 JSON")
+	}
+	log.Printf("🔧 [Tool] %s(%s)", t.Name, args)
+	emitEvent(EventTool, t.Name, args)
+	res := t.Handler(ctx, json.RawMessage(args))
+	log.Printf("🔧 [Tool] %s -> %s", t.Name, res.Content)
+	return res
+}
+
+func toolOK(fields map[string]interface{}) ToolResult {
+	out := map[string]interface{}{"ok": true}
+	for k, v := range fields {
+		out[k] = v
+	}
+	b, _ := json.Marshal(out)
+	return ToolResult{Content: string(b)}
+}
+
+func toolError(msg string) ToolResult {
+	b, _ := json.Marshal(map[string]interface{}{"ok": false, "error": msg})
+	return ToolResult{Content: string(b)}
+}
+
+// toolCallDelta 流式返回中的 tool_calls 增量
+type toolCallDelta struct {
+	Index    *int             `json:"index"`
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolFunctionCall `json:"function"`
+}
+
+// toolCallAccumulator 把流式增量拼成完整的 tool_calls
+type toolCallAccumulator struct {
+	calls []ToolCall
+}
+
+func (a *toolCallAccumulator) Add(d toolCallDelta) {
+	idx := len(a.calls) - 1
+	switch {
+	case d.Index != nil:
+		idx = *d.Index
+	case d.ID != "" && (idx < 0 || a.calls[idx].ID != d.ID):
+		idx = len(a.calls)
+	case idx < 0:
+		idx = 0
+	}
+	for len(a.calls) <= idx {
+		a.calls = append(a.calls, ToolCall{Type: "function"})
+	}
+	c := &a.calls[idx]
+	if d.ID != "" {
+		c.ID = d.ID
+	}
+	if d.Type != "" {
+		c.Type = d.Type
+	}
+	if d.Function.Name != "" {
+		c.Function.Name = d.Function.Name
+	}
+	c.Function.Arguments += d.Function.Arguments
+}
+
+// Calls 返回有函数名的调用，缺 ID 的补一个
+func (a *toolCallAccumulator) Calls() []ToolCall {
+	out := make([]ToolCall, 0, len(a.calls))
+	for i, c := range a.calls {
+		if c.Function.Name == "" {
+			continue
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("call_%d", i)
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// ---------- 内置工具 ----------
+
+func init() {
+	registerBuiltinTools(assistantTools)
+}
+
+func registerBuiltinTools(r *toolRegistry) {
+	r.Register(&Tool{
+		Name:        "play_music",
+		Description: "播放本地曲库里的歌曲。仅在用户明确要求放歌/听歌时调用。",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query":  map[string]interface{}{"type": "string", "description": "歌名、歌手或专辑，例如“庙堂之外”“周杰伦”；按歌手/专辑查询时会把命中的歌依次播放"},
+				"random": map[string]interface{}{"type": "boolean", "description": "用户没有指定歌曲、只想随便听听时为 true"},
+			},
+		},
+		Handler: toolPlayMusic,
+	})
+	r.Register(&Tool{
+		Name:        "stop_music",
+		Description: "停止正在播放的音乐。",
+		Parameters:  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+		Handler:     toolStopMusic,
+	})
+	r.Register(&Tool{
+		Name:        "set_volume",
+		Description: "调整整机音量（音乐和说话声一起变）。level 为 0~100 的绝对音量；用户只说“大声点/小声点”时用 delta（如 +20、-20）。结果会限制在设备允许的范围内。",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"level": map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 100},
+				"delta": map[string]interface{}{"type": "integer", "minimum": -100, "maximum": 100},
+			},
+		},
+		Handler: toolSetVolume,
+	})
+	r.Register(&Tool{
+		Name:        "set_timer",
+		Description: "设置倒计时提醒，到点后语音提醒用户。",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"seconds": map[string]interface{}{"type": "integer", "description": "倒计时秒数，例如 5 分钟为 300"},
+				"label":   map[string]interface{}{"type": "string", "description": "提醒内容，例如“关火”"},
+			},
+			"required": []string{"seconds"},
+		},
+		Handler: toolSetTimer,
+	})
+}
+
+func toolPlayMusic(ctx context.Context, args json.RawMessage) ToolResult {
+	var p struct {
+		Query  string `json:"query"`
+		Random bool   `json:"random"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return toolError("参数错误: " + err.Error())
+	}
+	query := strings.TrimSpace(p.Query)
+	exclude := ""
+	if p.Random || query == "" || strings.EqualFold(query, "RANDOM") {
+		query = "RANDOM"
+		exclude = musicMgr.CurrentSongPath()
+	}
+	var queue []TrackMatch
+	if query == "RANDOM" {
+		if t, ok := currentCatalog().Random(exclude); ok {
+			queue = []TrackMatch{{Track: t}}
+		}
+	} else {
+		matches := currentCatalog().Search(query)
+		if cands := ambiguousMatches(matches); cands != nil {
+			return ambiguousSongResult(query, cands)
+		}
+		queue = queueForMatches(matches)
+	}
+	if len(queue) == 0 {
+		log.Printf("播放解析: query=%q 未命中", query)
+		return toolError("本地曲库里没有找到这首歌")
+	}
+	first := queue[0]
+	log.Printf("播放解析: query=%q field=%s title=%q path=%q 队列=%d", query, first.Field, first.Title, first.Path, len(queue))
+	if err := checkMusicFile(first.Path); err != nil {
+		log.Printf("❌ [MUSIC] 无法播放 %s: %v", filepath.Base(first.Path), err)
+		res := toolError("这首歌的音频格式不支持，无法播放")
+		res.Speech = musicErrorText(first.Title)
+		return res
+	}
+	paths := make([]string, len(queue))
+	for i, m := range queue {
+		paths[i] = m.Path
+	}
+	if len(paths) == 1 {
+		// 单首（点歌名/随机）：放完按播放模式接着放曲库里的其他歌
+		paths = currentCatalog().QueueFrom(paths[0])
+	}
+	fields := map[string]interface{}{"title": first.Title, "queued": len(queue)}
+	if first.Artist != "" {
+		fields["artist"] = first.Artist
+	}
+	if first.Album != "" {
+		fields["album"] = first.Album
+	}
+	res := toolOK(fields)
+	res.Speech = playConfirmationText(first.Title)
+	res.After = func() {
+		if err := musicMgr.PlayQueue(paths); err != nil {
+			speakMusicError(first.Title)
+		}
+	}
+	return res
+}
+
+// ambiguousSongResult 拼音模糊命中了好几首：不直接播放，把候选交给模型向用户确认
+func ambiguousSongResult(query string, cands []TrackMatch) ToolResult {
+	list := make([]map[string]interface{}, len(cands))
+	titles := make([]string, len(cands))
+	for i, m := range cands {
+		item := map[string]interface{}{"title": m.Title}
+		if m.Artist != "" {
+			item["artist"] = m.Artist
+		}
+		list[i] = item
+		titles[i] = "《" + m.Title + "》"
+	}
+	log.Printf("播放解析: query=%q 模糊命中多首: %s", query, strings.Join(titles, " "))
+	b, _ := json.Marshal(map[string]interface{}{
+		"ok":         false,
+		"error":      "曲库里有几首歌都可能是用户说的，请向用户确认是哪一首",
+		"candidates": list,
+	})
+	return ToolResult{Content: string(b), Speech: "你想听的是" + strings.Join(titles, "还是") + "？"}
+}
+
+func toolStopMusic(ctx context.Context, args json.RawMessage) ToolResult {
+	wasPlaying := musicMgr.IsPlaying()
+	musicMgr.Stop()
+	res := toolOK(map[string]interface{}{"was_playing": wasPlaying})
+	res.Speech = "好的，已经停了"
+	return res
+}
+
+func toolSetVolume(ctx context.Context, args json.RawMessage) ToolResult {
+	var p struct {
+		Level *int `json:"level"`
+		Delta int  `json:"delta"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return toolError("参数错误: " + err.Error())
+	}
+	var cmd volumeCommand
+	switch {
+	case p.Level != nil:
+		cmd = volumeCommand{Level: *p.Level}
+	case p.Delta != 0:
+		cmd = volumeCommand{Delta: p.Delta, Relative: true}
+	default:
+		return toolError("需要 level 或 delta")
+	}
+	before, after, err := setDeviceVolume(cmd)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	res := toolOK(map[string]interface{}{"volume": after, "previous": before})
+	res.Speech = volumeConfirmationText(cmd, before, after)
+	return res
+}
+
+func toolSetTimer(ctx context.Context, args json.RawMessage) ToolResult {
+	var p struct {
+		Seconds int    `json:"seconds"`
+		Label   string `json:"label"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return toolError("参数错误: " + err.Error())
+	}
+	if p.Seconds <= 0 || p.Seconds > 24*3600 {
+		return toolError("倒计时需在 1 秒到 24 小时之间")
+	}
+	d := time.Duration(p.Seconds) * time.Second
+	label := strings.TrimSpace(p.Label)
+	time.AfterFunc(d, func() { announceTimer(label) })
+	log.Printf("⏰ [Timer] %v 后提醒: %q", d, label)
+	res := toolOK(map[string]interface{}{"seconds": p.Seconds, "label": label})
+	res.Speech = "好的，到时间我会提醒你"
+	return res
+}
+
+// announceTimer 倒计时到点：打断当前播报，压低音乐后提醒
+func announceTimer(label string) {
+	text := "时间到了"
+	if label != "" {
+		text = "时间到了，该" + strings.TrimPrefix(label, "该") + "了"
+	}
+	log.Printf("⏰ [Timer] %s", text)
+	assistant.Dispatch(EvDuck{})
+	resetSessionForTTS()
+	drainTTSDone()
+	speakText(text)
+	waitTTSDone(10 * time.Second)
+	assistant.Dispatch(EvUnduck{})
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"ai_box/localtts"
+)
+
+// ================= TTS 后端 =================
+// 说明：
+// - ttsManagerLoop 只负责会话管理与 [[END]] 哨兵协议，具体合成交给 SpeechSynthesizer；
+// - dashscope：云端 cosyvoice 双工 websocket（run-task/continue-task/finish-task）；
+// - local：板端 sherpa-onnx 离线合成（VITS/Matcha），云端建连失败时兜底，保证唤醒应答/报错/播放确认仍能出声；
+// - 合成出的 PCM 可能与播放器采样率不同，由 ttsManagerLoop 统一重采样。
+
+// TTSAudioFunc 合成音频回调：PCM16 小端单声道 + 其采样率
+type TTSAudioFunc func(pcm []byte, sampleRate int)
+
+// SpeechSynthesizer 语音合成后端。
+type SpeechSynthesizer interface {
+	Name() string
+	// Start 开启一次合成会话；ctx 取消后不再交付音频。
+	Start(ctx context.Context, onAudio TTSAudioFunc) (SynthesisSession, error)
+}
+
+// SynthesisSession 一次合成会话：文本分片陆续 Send，Finish 声明文本结束并等待音频全部交付。
+// Close 可在任意 goroutine 调用，用于打断。
+type SynthesisSession interface {
+	Send(text string) error
+	Finish() error
+	Close()
+}
+
+// errTTSDial 云端建连失败，可回退到本地合成
+var errTTSDial = errors.New("tts: 建连失败")
+
+var ttsEngine SpeechSynthesizer
+
+// ---------- DashScope cosyvoice ----------
+
+type dashScopeTTS struct{}
+
+func (dashScopeTTS) Name() string { return "dashscope" }
+
+func (dashScopeTTS) Start(ctx context.Context, onAudio TTSAudioFunc) (SynthesisSession, error) {
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, HandshakeTimeout: 5 * time.Second}
+	headers := http.Header{}
+	headers.Add("Authorization", "Bearer "+dashAPIKey)
+	conn, _, err := dialer.Dial(ttsWsURL, headers)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errTTSDial, err)
+	}
+	s := &dashScopeTTSSession{
+		ctx:     ctx,
+		conn:    conn,
+		taskID:  uuid.New().String(),
+		onAudio: onAudio,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.readLoop()
+	conn.WriteJSON(map[string]interface{}{
+		"header": map[string]interface{}{"task_id": s.taskID, "action": "run-task", "streaming": "duplex"},
+		"payload": map[string]interface{}{
+			"task_group": "audio", "task": "tts", "function": "SpeechSynthesizer",
+			"model":      ttsModel,
+			"parameters": map[string]interface{}{"text_type": "PlainText", "voice": ttsVoice, "format": "pcm", "sample_rate": ttsSampleRate, "volume": ttsVolume, "enable_ssml": false},
+			"input":      map[string]interface{}{},
+		},
+	})
+	select {
+	case <-s.started:
+		time.Sleep(50 * time.Millisecond)
+		return s, nil
+	case <-s.done:
+		s.Close()
+		return nil, fmt.Errorf("%w: %v", errTTSDial, s.error())
+	case <-time.After(5 * time.Second):
+		s.Close()
+		return nil, fmt.Errorf("%w: 等待 task-started 超时", errTTSDial)
+	}
+}
+
+type dashScopeTTSSession struct {
+	ctx     context.Context
+	conn    *websocket.Conn
+	taskID  string
+	onAudio TTSAudioFunc
+
+	started   chan struct{}
+	startOnce sync.Once
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	err       error
+}
+
+func (s *dashScopeTTSSession) error() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *dashScopeTTSSession) setError(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *dashScopeTTSSession) readLoop() {
+	defer close(s.done)
+	for {
+		if s.ctx.Err() != nil {
+			return
+		}
+		msgType, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			s.setError(err)
+			return
+		}
+		if msgType == websocket.BinaryMessage {
+			if s.ctx.Err() == nil {
+				s.onAudio(msg, ttsSampleRate)
+			}
+			continue
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(msg, &resp); err != nil {
+			continue
+		}
+		header, _ := resp["header"].(map[string]interface{})
+		event, _ := header["event"].(string)
+		switch event {
+		case "task-started":
+			s.startOnce.Do(func() { close(s.started) })
+		case "task-finished":
+			return
+		case "task-failed":
+			s.setError(fmt.Errorf("tts: task-failed: %v", header["error_message"]))
+			return
+		}
+	}
+}
+
+func (s *dashScopeTTSSession) Send(text string) error {
+	select {
+	case <-s.done:
+		if err := s.error(); err != nil {
+			return err
+		}
+		return errors.New("tts: 会话已结束")
+	default:
+	}
+	err := s.conn.WriteJSON(map[string]interface{}{
+		"header":  map[string]interface{}{"task_id": s.taskID, "action": "continue-task", "streaming": "duplex"},
+		"payload": map[string]interface{}{"input": map[string]interface{}{"text": text}},
+	})
+	time.Sleep(50 * time.Millisecond)
+	return err
+}
+
+func (s *dashScopeTTSSession) Finish() error {
+	s.conn.WriteJSON(map[string]interface{}{
+		"header":  map[string]interface{}{"task_id": s.taskID, "action": "finish-task", "streaming": "duplex"},
+		"payload": map[string]interface{}{"input": map[string]interface{}{}},
+	})
+	select {
+	case <-s.done:
+	case <-s.ctx.Done():
+		s.Close()
+		<-s.done
+	}
+	return s.error()
+}
+
+func (s *dashScopeTTSSession) Close() {
+	s.closeOnce.Do(func() { _ = s.conn.Close() })
+}
+
+// ---------- 本地 sherpa-onnx ----------
+
+type localTTS struct {
+	syn *localtts.Synthesizer
+}
+
+func (t *localTTS) Name() string { return "local" }
+
+func (t *localTTS) Start(ctx context.Context, onAudio TTSAudioFunc) (SynthesisSession, error) {
+	s := &localTTSSession{
+		ctx:     ctx,
+		syn:     t.syn,
+		onAudio: onAudio,
+		texts:   make(chan string, 64),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go s.worker()
+	return s, nil
+}
+
+// localTTSSession 逐句离线合成；合成在后台 worker 中进行，保证 Send 不阻塞且顺序不乱
+type localTTSSession struct {
+	ctx     context.Context
+	syn     *localtts.Synthesizer
+	onAudio TTSAudioFunc
+
+	texts     chan string
+	done      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	finished bool // texts 已关闭
+}
+
+func (s *localTTSSession) worker() {
+	defer close(s.done)
+	for text := range s.texts {
+		select {
+		case <-s.closed:
+			continue
+		default:
+		}
+		if s.ctx.Err() != nil {
+			continue
+		}
+		samples, rate := s.syn.Generate(text)
+		if len(samples) == 0 || s.ctx.Err() != nil {
+			continue
+		}
+		select {
+		case <-s.closed:
+			continue
+		default:
+		}
+		s.onAudio(pcmInt16ToBytes(samples), rate)
+	}
+}
+
+func (s *localTTSSession) Send(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return errors.New("tts: 会话已结束")
+	}
+	s.texts <- text
+	return nil
+}
+
+func (s *localTTSSession) endInput() {
+	s.mu.Lock()
+	if !s.finished {
+		s.finished = true
+		close(s.texts)
+	}
+	s.mu.Unlock()
+}
+
+func (s *localTTSSession) Finish() error {
+	s.endInput()
+	<-s.done
+	return nil
+}
+
+func (s *localTTSSession) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.endInput()
+}
+
+// ---------- 回退组合 ----------
+
+// fallbackTTS 主引擎建连失败时改用备用引擎
+type fallbackTTS struct {
+	primary  SpeechSynthesizer
+	fallback SpeechSynthesizer
+}
+
+func (f *fallbackTTS) Name() string { return f.primary.Name() + "+" + f.fallback.Name() }
+
+func (f *fallbackTTS) Start(ctx context.Context, onAudio TTSAudioFunc) (SynthesisSession, error) {
+	sess, err := f.primary.Start(ctx, onAudio)
+	if err != nil && errors.Is(err, errTTSDial) {
+		log.Printf("⚠️ [TTS] %s 不可用，回退到 %s: %v", f.primary.Name(), f.fallback.Name(), err)
+		return f.fallback.Start(ctx, onAudio)
+	}
+	return sess, err
+}
+
+func initSpeechSynthesizer() SpeechSynthesizer {
+	var local SpeechSynthesizer
+	if ttsProvider == "local" || ttsFallback == "local" {
+		syn, err := localtts.NewSynthesizer(localtts.Config{
+			ModelDir:      ttsLocalModelDir,
+			AcousticModel: ttsLocalAcousticModel,
+			Vocoder:       ttsLocalVocoder,
+			DictDir:       ttsLocalDictDir,
+			RuleFsts:      ttsLocalRuleFsts,
+			SpeakerID:     ttsLocalSpeakerID,
+			Speed:         float32(ttsLocalSpeed),
+		})
+		if err != nil {
+			log.Printf("⚠️ [TTS] 本地合成初始化失败: %v", err)
+		} else {
+			local = &localTTS{syn: syn}
+		}
+	}
+
+	switch ttsProvider {
+	case "local":
+		if local != nil {
+			return local
+		}
+		log.Println("⚠️ [TTS] 本地合成不可用，改用云端 dashscope")
+		return dashScopeTTS{}
+	case "dashscope", "":
+	default:
+		log.Printf("⚠️ [TTS] 未知 AI_BOX_TTS_PROVIDER=%q，使用 dashscope", ttsProvider)
+	}
+
+	if local != nil {
+		return &fallbackTTS{primary: dashScopeTTS{}, fallback: local}
+	}
+	return dashScopeTTS{}
+}
+
+// 当前合成会话（供 performStop 从其它 goroutine 打断）
+var (
+	activeTTS   SynthesisSession
+	activeTTSMu sync.Mutex
+)
+
+func setActiveTTS(s SynthesisSession) {
+	activeTTSMu.Lock()
+	activeTTS = s
+	activeTTSMu.Unlock()
+}
+
+func abortActiveTTS() {
+	activeTTSMu.Lock()
+	if activeTTS != nil {
+		activeTTS.Close()
+		activeTTS = nil
+	}
+	activeTTSMu.Unlock()
+}
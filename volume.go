@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ================= 设备音量 =================
+// 说明：
+// - 整机音量 0~100，对所有声音生效（与音乐自己的音量、Duck 叠加）；
+// - 默认用 amixer 调功放（AI_BOX_VOLUME_CARD / AI_BOX_VOLUME_CONTROL），amixer 不可用时退回混音器的软件增益；
+// - 语音：“大声点”“小声点”“音量调到五十”“最大音量”，结果限制在 AI_BOX_VOLUME_MIN~MAX 之间；
+// - 调完播报新音量（播报中改用提示音），并写进 settings.json，开机时恢复。
+
+// volumeController 设备音量
+type volumeController interface {
+	Name() string
+	// Get 当前音量（0~100）
+	Get() (int, error)
+	// Set 设置音量（0~100）
+	Set(level int) error
+}
+
+var deviceVolume volumeController
+
+// initVolumeController 按 AI_BOX_VOLUME_BACKEND 选择实现；auto 时 amixer 读不到音量就用软件增益
+func initVolumeController() volumeController {
+	switch volumeBackend {
+	case "amixer":
+		return &amixerVolume{card: volumeCard, control: volumeControl}
+	case "software":
+		return newSoftwareVolume()
+	}
+	amixer := &amixerVolume{card: volumeCard, control: volumeControl}
+	if _, err := amixer.Get(); err != nil {
+		log.Printf("⚠️ [音量] amixer 不可用，改用软件音量: %v", err)
+		return newSoftwareVolume()
+	}
+	return amixer
+}
+
+// ---------- amixer ----------
+
+type amixerVolume struct {
+	card    string
+	control string // 例如 aw_dev_0_rx_volume,0
+}
+
+var amixerPercentRe = regexp.MustCompile(`\[(\d+)%\]`)
+
+func (v *amixerVolume) Name() string { return "amixer" }
+
+func (v *amixerVolume) Get() (int, error) {
+	out, err := exec.Command("amixer", "-c", v.card, "sget", v.control).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	m := amixerPercentRe.FindStringSubmatch(string(out))
+	if m == nil {
+		return 0, fmt.Errorf("无法解析 amixer 输出: %s", strings.TrimSpace(string(out)))
+	}
+	return strconv.Atoi(m[1])
+}
+
+func (v *amixerVolume) Set(level int) error {
+	out, err := exec.Command("amixer", "-c", v.card, "sset", v.control, strconv.Itoa(level)+"%").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ---------- 软件增益 ----------
+
+// softwareVolume 在混音器输出上整体缩放
+type softwareVolume struct {
+	mu    sync.Mutex
+	level int
+}
+
+func newSoftwareVolume() *softwareVolume { return &softwareVolume{level: 100} }
+
+func (v *softwareVolume) Name() string { return "software" }
+
+func (v *softwareVolume) Get() (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.level, nil
+}
+
+func (v *softwareVolume) Set(level int) error {
+	v.mu.Lock()
+	v.level = level
+	v.mu.Unlock()
+	currentMixer().SetMasterGain(float64(level) / 100)
+	return nil
+}
+
+// ---------- 语音指令 ----------
+
+// volumeCommand 解析出的音量指令：Relative 时 Delta 为增减量，否则 Level 为目标音量
+type volumeCommand struct {
+	Level    int
+	Delta    int
+	Relative bool
+}
+
+var (
+	volumeUpWords   = []string{"大声", "大点声", "响一点", "响点", "调大", "大一点", "大一些", "加大", "调高", "高一点", "增大"}
+	volumeDownWords = []string{"小声", "小点声", "轻一点", "轻点", "调小", "小一点", "小一些", "减小", "调低", "低一点", "降低"}
+	volumeNounWords = []string{"音量", "声音"}
+	// 数字要紧跟在“音量/调到/大/小”这类词后面才算音量，免得把“大声朗读一首诗”的“一”当成数字
+	volumeAmountRe = regexp.MustCompile(`(?:音量|声音|调到|调成|调为|设成|设为|设到|开到|调大|调小|调高|调低|加大|减小|增大|降低|大|小|高|低|到|成)(?:百分之)?(` + numeralChars + `+)`)
+)
+
+var (
+	// “别那么大声”“太小声了”：说的是反话，方向要倒过来
+	volumeNegations = []string{"别那么", "别这么", "别太", "别", "不要那么", "不要这么", "不要太", "不要", "不用那么", "太"}
+	// 不带“音量/声音”时，去掉这些客套和语气词后只能剩下“大声/小声”本身
+	volumeBareLeads  = []string{"请", "麻烦", "你", "说话", "再", "稍微"}
+	volumeBareTrails = []string{"一点儿", "一点", "一些", "点儿", "点", "些", "吧", "啊", "呀", "哦", "了"}
+)
+
+// parseVolumeCommand 识别音量指令
+func parseVolumeCommand(text string) (volumeCommand, bool) {
+	cleaned := normalizeIntentText(text)
+	if cleaned == "" {
+		return volumeCommand{}, false
+	}
+	hasAny := func(words []string) bool {
+		for _, w := range words {
+			if strings.Contains(cleaned, w) {
+				return true
+			}
+		}
+		return false
+	}
+	noun := hasAny(volumeNounWords)
+	if !noun {
+		// “大声点”单独就够；“大一点/调高”这类需要带上“音量/声音”，免得把“字大一点”也当成调音量
+		return parseBareVolumeCommand(cleaned)
+	}
+	switch {
+	case hasAny([]string{"最大", "最响"}):
+		return volumeCommand{Level: 100}, true
+	case hasAny([]string{"最小", "最低"}):
+		return volumeCommand{Level: 0}, true
+	}
+	up := hasAny(volumeUpWords)
+	down := hasAny(volumeDownWords)
+	if up != down && hasAny(volumeNegations) {
+		up, down = down, up
+	}
+	amount, hasAmount := volumeAmount(cleaned)
+	switch {
+	case up || down:
+		delta := volumeStep
+		if hasAmount && amount > 0 {
+			delta = amount
+		}
+		if down {
+			delta = -delta
+		}
+		return volumeCommand{Delta: delta, Relative: true}, true
+	case hasAmount:
+		// “音量调到五十”“音量五十”“声音设成百分之三十”
+		return volumeCommand{Level: amount}, true
+	}
+	return volumeCommand{}, false
+}
+
+// parseBareVolumeCommand 只认整句就是“大声点/小点声/别那么大声”这样的短指令
+func parseBareVolumeCommand(cleaned string) (volumeCommand, bool) {
+	s := cleaned
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, w := range volumeBareTrails {
+			if rest, ok := strings.CutSuffix(s, w); ok && rest != "" {
+				s, trimmed = rest, true
+			}
+		}
+	}
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, w := range volumeBareLeads {
+			if rest, ok := strings.CutPrefix(s, w); ok && rest != "" {
+				s, trimmed = rest, true
+			}
+		}
+	}
+	negated := false
+	for _, w := range volumeNegations {
+		if rest, ok := strings.CutPrefix(s, w); ok && rest != "" {
+			s, negated = rest, true
+			break
+		}
+	}
+	var delta int
+	switch s {
+	case "大声", "大点声":
+		delta = volumeStep
+	case "小声", "小点声":
+		delta = -volumeStep
+	default:
+		return volumeCommand{}, false
+	}
+	if negated {
+		delta = -delta
+	}
+	return volumeCommand{Delta: delta, Relative: true}, true
+}
+
+// volumeAmount 指令里紧跟在音量用语后面的数字（“五十”“百分之三十”“80”）
+func volumeAmount(cleaned string) (int, bool) {
+	// “大一点/小一些”里的“一”不是数字
+	s := cleaned
+	for _, vague := range []string{"一点儿", "一点", "一些", "一下"} {
+		s = strings.ReplaceAll(s, vague, "")
+	}
+	m := volumeAmountRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	return parseChineseNumber(m[1])
+}
+
+// clampVolume 限制在 AI_BOX_VOLUME_MIN~MAX
+func clampVolume(level int) int {
+	return max(volumeMin, min(volumeMax, level))
+}
+
+// Apply 在 current 基础上得到新音量（已限制范围）
+func (c volumeCommand) Apply(current int) int {
+	if c.Relative {
+		return clampVolume(current + c.Delta)
+	}
+	return clampVolume(c.Level)
+}
+
+// volumeConfirmationText 调完音量的播报
+func volumeConfirmationText(cmd volumeCommand, before, after int) string {
+	switch {
+	case after == before && after >= volumeMax && (cmd.Delta > 0 || !cmd.Relative):
+		return "已经是最大音量了"
+	case after == before && after <= volumeMin && (cmd.Delta < 0 || !cmd.Relative):
+		return "已经是最小音量了"
+	}
+	return fmt.Sprintf("音量%d", after)
+}
+
+// setDeviceVolume 执行音量指令并保存，返回调整前后的音量；语音指令和 LLM 工具共用
+func setDeviceVolume(cmd volumeCommand) (before, after int, err error) {
+	before, err = deviceVolume.Get()
+	if err != nil {
+		return 0, 0, fmt.Errorf("读取音量失败: %w", err)
+	}
+	after = cmd.Apply(before)
+	if err := deviceVolume.Set(after); err != nil {
+		return before, before, fmt.Errorf("设置音量失败: %w", err)
+	}
+	log.Printf("🔊 [音量] %d -> %d（%s）", before, after, deviceVolume.Name())
+	emitEvent(EventVolume, deviceVolume.Name(), strconv.Itoa(after))
+	if err := updateSettings(func(s *deviceSettings) { s.Volume = &after }); err != nil {
+		log.Printf("⚠️ [设置] 保存音量失败: %v", err)
+	}
+	return before, after, nil
+}
+
+// adjustVolume 执行音量指令、保存并确认；speaking 时不插话，用提示音确认
+func adjustVolume(cmd volumeCommand, speaking bool) {
+	before, after, err := setDeviceVolume(cmd)
+	if err != nil {
+		log.Printf("❌ [音量] %v", err)
+		playEarcon(earconError)
+		return
+	}
+	if speaking || volumeConfirm == "earcon" {
+		if playEarcon(earconVolumeChange) != nil {
+			return
+		}
+		if speaking {
+			return
+		}
+	}
+	speakText(volumeConfirmationText(cmd, before, after))
+}
+
+// restoreVolume 开机恢复上次的音量
+func restoreVolume(level int) {
+	level = clampVolume(level)
+	if err := deviceVolume.Set(level); err != nil {
+		log.Printf("⚠️ [音量] 恢复音量失败: %v", err)
+		return
+	}
+	log.Printf("🔊 [音量] 恢复为 %d（%s）", level, deviceVolume.Name())
+}
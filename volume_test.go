@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseVolumeCommand(t *testing.T) {
+	cases := []struct {
+		text string
+		cmd  volumeCommand
+		ok   bool
+	}{
+		{"大声点", volumeCommand{Delta: 10, Relative: true}, true},
+		{"声音小一点", volumeCommand{Delta: -10, Relative: true}, true},
+		{"小点声", volumeCommand{Delta: -10, Relative: true}, true},
+		{"音量调大二十", volumeCommand{Delta: 20, Relative: true}, true},
+		{"音量调到五十", volumeCommand{Level: 50}, true},
+		{"把声音设成百分之三十", volumeCommand{Level: 30}, true},
+		{"音量80", volumeCommand{Level: 80}, true},
+		{"音量调到最大", volumeCommand{Level: 100}, true},
+		{"最小音量", volumeCommand{Level: 0}, true},
+		{"字大一点", volumeCommand{}, false},
+		{"播放大海", volumeCommand{}, false},
+		{"音量", volumeCommand{}, false},
+		{"请再大声一点", volumeCommand{Delta: 10, Relative: true}, true},
+		{"别那么大声", volumeCommand{Delta: -10, Relative: true}, true},
+		{"不要太大声了", volumeCommand{Delta: -10, Relative: true}, true},
+		{"太小声了", volumeCommand{Delta: 10, Relative: true}, true},
+		{"大声朗读一首诗", volumeCommand{}, false},
+		{"小声说一个故事", volumeCommand{}, false},
+		{"声音大一点读一首诗", volumeCommand{Delta: 10, Relative: true}, true},
+	}
+	for _, c := range cases {
+		cmd, ok := parseVolumeCommand(c.text)
+		if ok != c.ok || cmd != c.cmd {
+			t.Errorf("%s: got %+v %v, want %+v %v", c.text, cmd, ok, c.cmd, c.ok)
+		}
+	}
+}
+
+func TestVolumeApplyAndConfirmation(t *testing.T) {
+	up := volumeCommand{Delta: 10, Relative: true}
+	down := volumeCommand{Delta: -10, Relative: true}
+	if got := up.Apply(95); got != volumeMax {
+		t.Fatalf("调大应限制在上限: %d", got)
+	}
+	if got := down.Apply(15); got != volumeMin {
+		t.Fatalf("调小应限制在下限: %d", got)
+	}
+	if got := (volumeCommand{Level: 0}).Apply(50); got != volumeMin {
+		t.Fatalf("最小音量应为下限: %d", got)
+	}
+	if got := volumeConfirmationText(up, 100, 100); got != "已经是最大音量了" {
+		t.Fatalf("到顶: %s", got)
+	}
+	if got := volumeConfirmationText(down, volumeMin, volumeMin); got != "已经是最小音量了" {
+		t.Fatalf("到底: %s", got)
+	}
+	if got := volumeConfirmationText(up, 40, 50); got != "音量50" {
+		t.Fatalf("普通确认: %s", got)
+	}
+}
+
+func TestMixerMasterGain(t *testing.T) {
+	x := newAudioMixer(8000, nil)
+	s := x.Open("a", 8000, 200*time.Millisecond)
+	s.Write(constantPCM(1000, 400))
+	acc := make([]float64, 80)
+	out := make([]byte, 160)
+
+	x.SetMasterGain(0.5)
+	x.mixBlock(acc, out) // 这一块内从 1 过渡到 0.5
+	x.mixBlock(acc, out)
+	for i, v := range pcmBytesToInt16(out) {
+		if v != 500 {
+			t.Fatalf("第 %d 个样本: %d，应按整体增益缩放到 500", i, v)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ================= WAV（RIFF）解析 =================
+// 说明：
+// - 按块遍历 RIFF：fmt/data 之外的块（LIST、fact、bext……）一律跳过，奇数长度块按规范补齐 1 字节；
+// - 支持 PCM（8bit 无符号 / 16 / 24 / 32bit 有符号）与 IEEE float（32 / 64bit），
+//   WAVE_FORMAT_EXTENSIBLE 按 SubFormat 归到这两类；
+// - 其他编码（ADPCM、A-law 等）返回 errUnsupportedAudio，由调用方决定如何提示。
+
+// errUnsupportedAudio 文件能打开但编码不受支持
+var errUnsupportedAudio = errors.New("不支持的音频格式")
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavFormat fmt 块中与解码有关的字段
+type wavFormat struct {
+	Rate     int
+	Channels int
+	Bits     int
+	Float    bool
+}
+
+// FrameBytes 一帧（所有通道各一个采样）的字节数
+func (f wavFormat) FrameBytes() int { return f.Channels * f.Bits / 8 }
+
+// parseWAVHeader 解析 RIFF 头直到 data 块，返回格式与只覆盖 data 块的 Reader，供流式读取
+func parseWAVHeader(r io.Reader) (wavFormat, io.Reader, error) {
+	var format wavFormat
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return format, nil, err
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WAVE" {
+		return format, nil, fmt.Errorf("%w: 不是 RIFF/WAVE 文件", errUnsupportedAudio)
+	}
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				err = errors.New("缺少 data 块")
+			}
+			return format, nil, err
+		}
+		id := string(ch[0:4])
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		switch id {
+		case "fmt ":
+			buf := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return format, nil, err
+			}
+			f, err := parseWAVFormatChunk(buf[:size])
+			if err != nil {
+				return format, nil, err
+			}
+			format = f
+		case "data":
+			if format.Channels == 0 {
+				return format, nil, errors.New("data 块出现在 fmt 之前")
+			}
+			// 边录边写的文件 data 长度常为 0 或 0xFFFFFFFF：读到文件结尾为止
+			if size == 0 || size == 0xFFFFFFFF {
+				return format, r, nil
+			}
+			return format, io.LimitReader(r, size), nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return format, nil, err
+			}
+		}
+	}
+}
+
+func parseWAVFormatChunk(b []byte) (wavFormat, error) {
+	if len(b) < 16 {
+		return wavFormat{}, errors.New("fmt 块过短")
+	}
+	tag := binary.LittleEndian.Uint16(b[0:2])
+	f := wavFormat{
+		Channels: int(binary.LittleEndian.Uint16(b[2:4])),
+		Rate:     int(binary.LittleEndian.Uint32(b[4:8])),
+		Bits:     int(binary.LittleEndian.Uint16(b[14:16])),
+	}
+	if tag == wavFormatExtensible {
+		// cbSize(2) + validBits(2) + channelMask(4) + SubFormat GUID(16)，GUID 前两字节即实际格式
+		if len(b) < 40 {
+			return f, errors.New("扩展 fmt 块过短")
+		}
+		tag = binary.LittleEndian.Uint16(b[24:26])
+	}
+	if f.Channels <= 0 || f.Rate <= 0 {
+		return f, fmt.Errorf("%w: %d 声道 %dHz", errUnsupportedAudio, f.Channels, f.Rate)
+	}
+	switch {
+	case tag == wavFormatPCM && (f.Bits == 8 || f.Bits == 16 || f.Bits == 24 || f.Bits == 32):
+	case tag == wavFormatFloat && (f.Bits == 32 || f.Bits == 64):
+		f.Float = true
+	default:
+		return f, fmt.Errorf("%w: format=0x%04X bits=%d", errUnsupportedAudio, tag, f.Bits)
+	}
+	return f, nil
+}
+
+// decodeWAVSamples 把 data 块中完整的帧转成交织 PCM16，末尾不足一帧的字节忽略
+func decodeWAVSamples(f wavFormat, raw []byte) []int16 {
+	width := f.Bits / 8
+	raw = raw[:len(raw)/f.FrameBytes()*f.FrameBytes()]
+	out := make([]int16, len(raw)/width)
+	for i := range out {
+		b := raw[i*width:]
+		switch {
+		case f.Float && width == 4:
+			out[i] = floatToInt16(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+		case f.Float:
+			out[i] = floatToInt16(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		case width == 1:
+			out[i] = int16(int(b[0])-128) << 8
+		case width == 2:
+			out[i] = int16(binary.LittleEndian.Uint16(b))
+		case width == 3:
+			out[i] = int16(uint16(b[1]) | uint16(b[2])<<8)
+		default:
+			out[i] = int16(binary.LittleEndian.Uint32(b) >> 16)
+		}
+	}
+	return out
+}
+
+func floatToInt16(v float64) int16 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return clampInt16(v * 32767)
+}
+
+// readWAV 一次性读入整个 WAV，返回交织 PCM16
+func readWAV(r io.Reader) (rate, channels int, samples []int16, err error) {
+	format, data, err := parseWAVHeader(r)
+	if err != nil {
+		return
+	}
+	var raw []byte
+	if raw, err = io.ReadAll(data); err != nil {
+		return
+	}
+	return format.Rate, format.Channels, decodeWAVSamples(format, raw), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildWAV 拼一个 RIFF 文件：fmt 块之前插入 LIST（奇数长度），data 之前插入 fact
+func buildWAV(tag uint16, rate, channels, bits int, data []byte, extensible bool) []byte {
+	var fmtChunk bytes.Buffer
+	outerTag := tag
+	if extensible {
+		outerTag = wavFormatExtensible
+	}
+	binary.Write(&fmtChunk, binary.LittleEndian, outerTag)
+	binary.Write(&fmtChunk, binary.LittleEndian, uint16(channels))
+	binary.Write(&fmtChunk, binary.LittleEndian, uint32(rate))
+	binary.Write(&fmtChunk, binary.LittleEndian, uint32(rate*channels*bits/8))
+	binary.Write(&fmtChunk, binary.LittleEndian, uint16(channels*bits/8))
+	binary.Write(&fmtChunk, binary.LittleEndian, uint16(bits))
+	if extensible {
+		binary.Write(&fmtChunk, binary.LittleEndian, uint16(22))
+		binary.Write(&fmtChunk, binary.LittleEndian, uint16(bits))
+		binary.Write(&fmtChunk, binary.LittleEndian, uint32(3))
+		binary.Write(&fmtChunk, binary.LittleEndian, tag)
+		fmtChunk.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71})
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	chunk := func(id string, b []byte) {
+		body.WriteString(id)
+		binary.Write(&body, binary.LittleEndian, uint32(len(b)))
+		body.Write(b)
+		if len(b)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	chunk("LIST", []byte("INFOISFT\x05\x00\x00\x00Lavf\x00"))
+	chunk("fmt ", fmtChunk.Bytes())
+	chunk("fact", []byte{1, 0, 0, 0})
+	chunk("data", data)
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+func TestReadWAVSampleFormats(t *testing.T) {
+	// 每种格式编码同样三个点：0、半幅正、半幅负
+	le32 := func(v uint32) []byte { b := make([]byte, 4); binary.LittleEndian.PutUint32(b, v); return b }
+	le64 := func(v uint64) []byte { b := make([]byte, 8); binary.LittleEndian.PutUint64(b, v); return b }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	cases := []struct {
+		name       string
+		tag        uint16
+		bits       int
+		data       []byte
+		extensible bool
+	}{
+		{"8bit", wavFormatPCM, 8, []byte{128, 192, 64}, false},
+		{"16bit", wavFormatPCM, 16, pcmInt16ToBytes([]int16{0, 16384, -16384}), false},
+		{"24bit", wavFormatPCM, 24, []byte{0, 0, 0, 0, 0, 0x40, 0, 0, 0xC0}, false},
+		{"32bit", wavFormatPCM, 32, join(le32(0), le32(0x40000000), le32(0xC0000000)), false},
+		{"float32", wavFormatFloat, 32, join(le32(math.Float32bits(0)), le32(math.Float32bits(0.5)), le32(math.Float32bits(-0.5))), false},
+		{"float64", wavFormatFloat, 64, join(le64(math.Float64bits(0)), le64(math.Float64bits(0.5)), le64(math.Float64bits(-0.5))), false},
+		{"extensible-24bit", wavFormatPCM, 24, []byte{0, 0, 0, 0, 0, 0x40, 0, 0, 0xC0}, true},
+		{"extensible-float", wavFormatFloat, 32, join(le32(math.Float32bits(0)), le32(math.Float32bits(0.5)), le32(math.Float32bits(-0.5))), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rate, channels, samples, err := readWAV(bytes.NewReader(buildWAV(c.tag, 44100, 1, c.bits, c.data, c.extensible)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rate != 44100 || channels != 1 || len(samples) != 3 {
+				t.Fatalf("rate=%d channels=%d samples=%v", rate, channels, samples)
+			}
+			if samples[0] != 0 || math.Abs(float64(samples[1])-16384) > 1 || math.Abs(float64(samples[2])+16384) > 1 {
+				t.Fatalf("采样换算异常: %v", samples)
+			}
+		})
+	}
+}
+
+func TestReadWAVUnsupported(t *testing.T) {
+	for name, wav := range map[string][]byte{
+		"ADPCM":   buildWAV(0x0002, 16000, 1, 4, []byte{0, 0}, false),
+		"12bit":   buildWAV(wavFormatPCM, 16000, 1, 12, []byte{0, 0}, false),
+		"A-law扩展": buildWAV(0x0006, 16000, 1, 8, []byte{0, 0}, true),
+		"非RIFF":   []byte("ID3\x03\x00\x00\x00\x00\x00\x00\x00\x00junk"),
+	} {
+		if _, _, _, err := readWAV(bytes.NewReader(wav)); !errors.Is(err, errUnsupportedAudio) {
+			t.Fatalf("%s: 应返回 errUnsupportedAudio，got %v", name, err)
+		}
+	}
+}
+
+func TestMusicStreamWAVSkipsHeaderChunks(t *testing.T) {
+	// 44.1kHz 立体声 24bit，LIST/fact 块不能被当成音频播出来
+	const n = 44100
+	data := make([]byte, 0, n*6)
+	for i := 0; i < n; i++ {
+		data = append(data, 0, 0, 0x20, 0, 0, 0x20) // 左右都是 0x200000 → 16bit 8192
+	}
+	src, err := newPCMFrameSource(bytes.NewReader(buildWAV(wavFormatPCM, n, 2, 24, data, false)), ".wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := readAllSamples(t, newMusicStream(src, nil))
+	if len(samples) < musicSampleRate-10 || len(samples) > musicSampleRate {
+		t.Fatalf("重采样长度异常，got=%d", len(samples))
+	}
+	for i, v := range samples {
+		if v != 8192 {
+			t.Fatalf("第 %d 个采样=%d，文件头被当成了音频", i, v)
+		}
+	}
+}
+
+func TestToolPlayMusicUnsupportedFormat(t *testing.T) {
+	oldDir := musicDir
+	defer func() { musicDir = oldDir }()
+	musicDir = t.TempDir()
+	bad := buildWAV(0x0002, 16000, 1, 4, []byte{0, 0}, false)
+	if err := os.WriteFile(filepath.Join(musicDir, "陈楚生《庙堂之外》.wav"), bad, 0644); err != nil {
+		t.Fatal(err)
+	}
+	currentCatalog().Refresh()
+	res := toolPlayMusic(context.Background(), []byte(`{"query":"庙堂之外"}`))
+	if !strings.Contains(res.Content, `"ok":false`) || res.After != nil {
+		t.Fatalf("不支持的格式不应播放，got=%+v", res)
+	}
+	if res.Speech != musicErrorText("庙堂之外") {
+		t.Fatalf("应播报格式错误，got=%q", res.Speech)
+	}
+}